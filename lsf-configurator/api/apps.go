@@ -2,9 +2,12 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"lsf-configurator/pkg/config"
 	"lsf-configurator/pkg/core"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 const (
@@ -32,6 +35,19 @@ func NewHandlerApps(composer *core.Composer, controller core.Controller, conf co
 	h.mux.HandleFunc("POST /bulk", h.bulkCreate)
 	h.mux.HandleFunc("DELETE /{id}", h.delete)
 	h.mux.HandleFunc("PATCH /{id}/latency_limit", h.updateLatencyLimit)
+	h.mux.HandleFunc("PATCH /{id}/path_latency_limits", h.updatePathLatencyLimits)
+	h.mux.HandleFunc("PATCH /{id}/controller_policy", h.updateControllerPolicy)
+	h.mux.HandleFunc("PATCH /{id}/priority", h.updatePriority)
+	h.mux.HandleFunc("PUT /{id}/schedule", h.updateSchedule)
+	h.mux.HandleFunc("PATCH /{id}/controller", h.updateControllerSettings)
+	h.mux.HandleFunc("PATCH /{id}/controller/dry_run", h.updateDryRun)
+	h.mux.HandleFunc("GET /{id}/reconfigurations", h.listReconfigurations)
+	h.mux.HandleFunc("PUT /{id}/active_layout", h.setActiveLayout)
+	h.mux.HandleFunc("PUT /{id}/source", h.updateSource)
+	h.mux.HandleFunc("GET /{id}/versions", h.listVersions)
+	h.mux.HandleFunc("POST /{id}/versions/{v}/rollback", h.rollbackVersion)
+	h.mux.HandleFunc("POST /{id}/controller/pause", h.pauseController)
+	h.mux.HandleFunc("POST /{id}/controller/resume", h.resumeController)
 
 	return h
 }
@@ -83,13 +99,18 @@ func (h *HandlerApps) create(w http.ResponseWriter, r *http.Request) {
 	}
 
 	data := core.FunctionAppCreationData{
-		Components:   payload.Components,
-		Links:        payload.Links,
-		UploadDir:    h.conf.UploadDir,
-		Files:        files,
-		AppName:      payload.Name,
-		Runtime:      payload.Runtime,
-		LatencyLimit: payload.LatencyLimit,
+		Components:         payload.Components,
+		Links:              payload.Links,
+		UploadDir:          h.conf.UploadDir,
+		Files:              files,
+		AppName:            payload.Name,
+		Runtime:            payload.Runtime,
+		LatencyLimit:       payload.LatencyLimit,
+		ControllerPolicy:   payload.ControllerPolicy,
+		RateLevels:         payload.RateLevels,
+		ControllerSettings: payload.ControllerSettings,
+		Priority:           payload.Priority,
+		PathLatencyLimits:  payload.PathLatencyLimits,
 	}
 
 	if payload.PlatformManaged {
@@ -129,13 +150,18 @@ func (h *HandlerApps) bulkCreate(w http.ResponseWriter, r *http.Request) {
 
 	// Create the function app
 	data := core.FunctionAppCreationData{
-		Components:   payload.FunctionApp.Components,
-		Links:        payload.FunctionApp.Links,
-		UploadDir:    h.conf.UploadDir,
-		Files:        files,
-		AppName:      payload.FunctionApp.Name,
-		Runtime:      payload.FunctionApp.Runtime,
-		LatencyLimit: payload.FunctionApp.LatencyLimit,
+		Components:         payload.FunctionApp.Components,
+		Links:              payload.FunctionApp.Links,
+		UploadDir:          h.conf.UploadDir,
+		Files:              files,
+		AppName:            payload.FunctionApp.Name,
+		Runtime:            payload.FunctionApp.Runtime,
+		LatencyLimit:       payload.FunctionApp.LatencyLimit,
+		ControllerPolicy:   payload.FunctionApp.ControllerPolicy,
+		RateLevels:         payload.FunctionApp.RateLevels,
+		ControllerSettings: payload.FunctionApp.ControllerSettings,
+		Priority:           payload.FunctionApp.Priority,
+		PathLatencyLimits:  payload.FunctionApp.PathLatencyLimits,
 	}
 
 	var app *core.FunctionApp
@@ -271,3 +297,352 @@ func (h *HandlerApps) updateLatencyLimit(w http.ResponseWriter, r *http.Request)
 
 	w.WriteHeader(http.StatusOK)
 }
+
+func (h *HandlerApps) updatePathLatencyLimits(w http.ResponseWriter, r *http.Request) {
+	appId := r.PathValue("id")
+	var req UpdatePathLatencyLimitsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	app, err := h.composer.GetFunctionApp(appId)
+	if err != nil || app == nil {
+		http.Error(w, "App not found", http.StatusNotFound)
+		return
+	}
+	if err := core.ValidatePathLatencyLimits(app.Components, app.Links, req.PathLatencyLimits); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	app.PathLatencyLimits = req.PathLatencyLimits
+	if err := h.composer.UpdateFunctionApp(app); err != nil {
+		http.Error(w, "Failed to update path latency limits", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *HandlerApps) updateControllerPolicy(w http.ResponseWriter, r *http.Request) {
+	appId := r.PathValue("id")
+	var req UpdateControllerPolicyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if _, err := core.GetReconfigurationPolicy(req.Policy); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	app, err := h.composer.GetFunctionApp(appId)
+	if err != nil || app == nil {
+		http.Error(w, "App not found", http.StatusNotFound)
+		return
+	}
+
+	app.ControllerPolicy = req.Policy
+	if err := h.composer.UpdateFunctionApp(app); err != nil {
+		http.Error(w, "Failed to update controller policy", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *HandlerApps) updatePriority(w http.ResponseWriter, r *http.Request) {
+	appId := r.PathValue("id")
+	var req UpdatePriorityRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	app, err := h.composer.GetFunctionApp(appId)
+	if err != nil || app == nil {
+		http.Error(w, "App not found", http.StatusNotFound)
+		return
+	}
+
+	app.Priority = req.Priority
+	if err := h.composer.UpdateFunctionApp(app); err != nil {
+		http.Error(w, "Failed to update priority", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *HandlerApps) updateSchedule(w http.ResponseWriter, r *http.Request) {
+	appId := r.PathValue("id")
+	var req UpdateScheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	app, err := h.composer.GetFunctionApp(appId)
+	if err != nil || app == nil {
+		http.Error(w, "App not found", http.StatusNotFound)
+		return
+	}
+	if err := app.ValidateSchedule(req.Schedule); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	app.Schedule = req.Schedule
+	if err := h.composer.UpdateFunctionApp(app); err != nil {
+		http.Error(w, "Failed to update schedule", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *HandlerApps) updateControllerSettings(w http.ResponseWriter, r *http.Request) {
+	appId := r.PathValue("id")
+	var req UpdateControllerSettingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	app, err := h.composer.GetFunctionApp(appId)
+	if err != nil || app == nil {
+		http.Error(w, "App not found", http.StatusNotFound)
+		return
+	}
+
+	// dry-run mode is set through its own endpoint, replacing the settings keeps it
+	req.DryRun = nil
+	if app.ControllerSettings != nil {
+		req.DryRun = app.ControllerSettings.DryRun
+	}
+	if req == (core.ControllerSettings{}) {
+		app.ControllerSettings = nil
+	} else {
+		app.ControllerSettings = &req
+	}
+	if err := h.composer.UpdateFunctionApp(app); err != nil {
+		http.Error(w, "Failed to update controller settings", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *HandlerApps) updateDryRun(w http.ResponseWriter, r *http.Request) {
+	appId := r.PathValue("id")
+	var req UpdateDryRunRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	app, err := h.composer.GetFunctionApp(appId)
+	if err != nil || app == nil {
+		http.Error(w, "App not found", http.StatusNotFound)
+		return
+	}
+
+	settings := core.ControllerSettings{}
+	if app.ControllerSettings != nil {
+		settings = *app.ControllerSettings
+	}
+	settings.DryRun = req.DryRun
+	if settings == (core.ControllerSettings{}) {
+		app.ControllerSettings = nil
+	} else {
+		app.ControllerSettings = &settings
+	}
+	if err := h.composer.UpdateFunctionApp(app); err != nil {
+		http.Error(w, "Failed to update dry-run mode", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *HandlerApps) setActiveLayout(w http.ResponseWriter, r *http.Request) {
+	appId := r.PathValue("id")
+	var req SetActiveLayoutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	app, err := h.composer.GetFunctionApp(appId)
+	if err != nil || app == nil {
+		http.Error(w, "App not found", http.StatusNotFound)
+		return
+	}
+	if _, ok := app.LayoutCandidates[req.LayoutKey]; !ok {
+		http.Error(w, "Unknown layout key", http.StatusBadRequest)
+		return
+	}
+
+	if req.PauseController {
+		if err := h.controller.SetPaused(appId, true); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	err = h.controller.SetActiveLayout(appId, req.LayoutKey)
+	if errors.Is(err, core.ErrReconfigurationInProgress) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// updateSource stores the uploaded files as a new source revision of the app and rolls it out,
+// the returned reconfiguration record tracks the rebuild and the switch to the new deployments
+func (h *HandlerApps) updateSource(w http.ResponseWriter, r *http.Request) {
+	appId := r.PathValue("id")
+	if err := r.ParseMultipartForm(10 << 20); err != nil { // 10MB limit
+		http.Error(w, "Error parsing multipart form", http.StatusBadRequest)
+		return
+	}
+	files := r.MultipartForm.File["files"]
+	if len(files) == 0 {
+		http.Error(w, "No files were uploaded", http.StatusBadRequest)
+		return
+	}
+
+	app, err := h.composer.GetFunctionApp(appId)
+	if err != nil || app == nil {
+		http.Error(w, "App not found", http.StatusNotFound)
+		return
+	}
+
+	record, err := h.controller.UpdateSource(appId, files)
+	if errors.Is(err, core.ErrReconfigurationInProgress) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(record)
+}
+
+func (h *HandlerApps) pauseController(w http.ResponseWriter, r *http.Request) {
+	h.setControllerPaused(w, r, true)
+}
+
+func (h *HandlerApps) resumeController(w http.ResponseWriter, r *http.Request) {
+	h.setControllerPaused(w, r, false)
+}
+
+func (h *HandlerApps) setControllerPaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	appId := r.PathValue("id")
+	app, err := h.composer.GetFunctionApp(appId)
+	if err != nil || app == nil {
+		http.Error(w, "App not found", http.StatusNotFound)
+		return
+	}
+
+	if err := h.controller.SetPaused(appId, paused); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// listReconfigurations returns the reconfiguration history of an app, the optional from and to query parameters are RFC3339 timestamps
+func (h *HandlerApps) listReconfigurations(w http.ResponseWriter, r *http.Request) {
+	appId := r.PathValue("id")
+	var from, to time.Time
+	var err error
+	if v := r.URL.Query().Get("from"); v != "" {
+		if from, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "Invalid from parameter, expected RFC3339 timestamp", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := r.URL.Query().Get("to"); v != "" {
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "Invalid to parameter, expected RFC3339 timestamp", http.StatusBadRequest)
+			return
+		}
+	}
+
+	records, err := h.controller.GetReconfigurations(appId, from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(records)
+}
+
+// listVersions returns the recorded versions of the app, oldest first
+func (h *HandlerApps) listVersions(w http.ResponseWriter, r *http.Request) {
+	appId := r.PathValue("id")
+	app, err := h.composer.GetFunctionApp(appId)
+	if err != nil || app == nil {
+		http.Error(w, "App not found", http.StatusNotFound)
+		return
+	}
+
+	versions, err := h.controller.GetVersions(appId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versions)
+}
+
+// rollbackVersion redeploys the images of the version without rebuilding them,
+// the returned reconfiguration record tracks the switch to the new deployments
+func (h *HandlerApps) rollbackVersion(w http.ResponseWriter, r *http.Request) {
+	appId := r.PathValue("id")
+	version, err := strconv.Atoi(r.PathValue("v"))
+	if err != nil || version < 1 {
+		http.Error(w, "Invalid version", http.StatusBadRequest)
+		return
+	}
+
+	app, err := h.composer.GetFunctionApp(appId)
+	if err != nil || app == nil {
+		http.Error(w, "App not found", http.StatusNotFound)
+		return
+	}
+
+	record, err := h.controller.RollbackToVersion(appId, version)
+	switch {
+	case errors.Is(err, core.ErrVersionNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, core.ErrReconfigurationInProgress):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(record)
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"encoding/json"
+	"lsf-configurator/pkg/core"
+	"net/http"
+)
+
+const (
+	DriftPath = "/drift"
+)
+
+type HandlerDrift struct {
+	reconciler core.Reconciler
+	mux        *http.ServeMux
+}
+
+func NewHandlerDrift(reconciler core.Reconciler) *HandlerDrift {
+	h := &HandlerDrift{
+		reconciler: reconciler,
+		mux:        http.NewServeMux(),
+	}
+
+	h.mux.HandleFunc("GET /", h.get)
+	h.mux.HandleFunc("POST /reconcile", h.reconcile)
+
+	return h
+}
+
+func (h *HandlerDrift) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	LoggingMiddleware(h.mux).ServeHTTP(w, r)
+}
+
+// get returns the report of the latest reconciliation round, restricted to an app if app_id is given
+func (h *HandlerDrift) get(w http.ResponseWriter, r *http.Request) {
+	writeDriftReport(w, r, h.reconciler.LastReport())
+}
+
+// reconcile runs a round right away and returns its report
+func (h *HandlerDrift) reconcile(w http.ResponseWriter, r *http.Request) {
+	writeDriftReport(w, r, h.reconciler.Reconcile(r.Context()))
+}
+
+func writeDriftReport(w http.ResponseWriter, r *http.Request, report core.DriftReport) {
+	if appId := r.URL.Query().Get("app_id"); appId != "" {
+		report = report.ForApp(appId)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -3,12 +3,18 @@ package api
 import "lsf-configurator/pkg/core"
 
 type FunctionAppCreateDto struct {
-	Name            string               `json:"name"`
-	Runtime         string               `json:"runtime"`
-	Components      []core.Component     `json:"components"`
-	Links           []core.ComponentLink `json:"links"`
-	LatencyLimit    int                  `json:"latency_limit"`
-	PlatformManaged bool                 `json:"platform_managed"`
+	Name               string                   `json:"name"`
+	Runtime            string                   `json:"runtime"`
+	Components         []core.Component         `json:"components"`
+	Links              []core.ComponentLink     `json:"links"`
+	LatencyLimit       int                      `json:"latency_limit"`
+	PlatformManaged    bool                     `json:"platform_managed"`
+	ControllerPolicy   string                   `json:"controller_policy"`
+	RateLevels         []float64                `json:"rate_levels"` // fractions between the min and max invocation rates, one layout is calculated per level
+	ControllerSettings *core.ControllerSettings `json:"controller_settings"`
+	Priority           int                      `json:"priority"` // apps with a higher priority are upgraded first when transitions have to wait
+	// latency limits in ms of the paths to individual end components, on top of the app-wide latency limit
+	PathLatencyLimits map[string]int `json:"path_latency_limits"`
 }
 
 type FunctionCompositionCreateDto struct {
@@ -30,6 +36,14 @@ type BulkCreateRequest struct {
 	Deployments          []DeploymentBulkCreateDto          `json:"deployments"`
 }
 
+// WebhookCreateDto registers a webhook subscriber, empty filters match every event type and app
+type WebhookCreateDto struct {
+	URL        string           `json:"url"`
+	Secret     string           `json:"secret"`
+	EventTypes []core.EventType `json:"event_types"`
+	AppIds     []string         `json:"app_ids"`
+}
+
 type FunctionCompositionBulkCreateDto struct {
 	TempId     string   `json:"id"`
 	Components []string `json:"components"`
@@ -48,3 +62,35 @@ type DeploymentBulkCreateDto struct {
 type UpdateLatencyLimitRequest struct {
 	LatencyLimit int `json:"latency_limit"`
 }
+
+// UpdatePathLatencyLimitsRequest replaces the latency limits of the paths to the app's end components
+type UpdatePathLatencyLimitsRequest struct {
+	PathLatencyLimits map[string]int `json:"path_latency_limits"`
+}
+
+type UpdatePriorityRequest struct {
+	Priority int `json:"priority"`
+}
+
+// UpdateScheduleRequest replaces the app's schedule, an empty schedule leaves the app to its policy
+type UpdateScheduleRequest struct {
+	Schedule []core.ScheduleEntry `json:"schedule"`
+}
+
+type UpdateControllerPolicyRequest struct {
+	Policy string `json:"policy"`
+}
+
+type SetActiveLayoutRequest struct {
+	LayoutKey       string `json:"layout_key"`
+	PauseController bool   `json:"pause_controller"` // keeps the controller from moving the app away from the layout
+}
+
+// UpdateDryRunRequest toggles the controller's dry-run mode for a single app, null reverts to the global setting
+type UpdateDryRunRequest struct {
+	DryRun *bool `json:"dry_run"`
+}
+
+// UpdateControllerSettingsRequest replaces the app's controller settings, unset fields fall back to the global defaults.
+// The dry-run mode is left as it is, it is changed through PATCH /{id}/controller/dry_run.
+type UpdateControllerSettingsRequest = core.ControllerSettings
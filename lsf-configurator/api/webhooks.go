@@ -0,0 +1,128 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"lsf-configurator/pkg/core"
+	"lsf-configurator/pkg/uuid"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	WebhooksPath = "/webhooks"
+
+	defaultDeliveryCount = 50
+)
+
+type HandlerWebhooks struct {
+	repo core.WebhookRepository
+	mux  *http.ServeMux
+}
+
+func NewHandlerWebhooks(repo core.WebhookRepository) *HandlerWebhooks {
+	h := &HandlerWebhooks{
+		repo: repo,
+		mux:  http.NewServeMux(),
+	}
+
+	h.mux.HandleFunc("GET /", h.list)
+	h.mux.HandleFunc("POST /", h.create)
+	h.mux.HandleFunc("DELETE /{id}", h.delete)
+	h.mux.HandleFunc("GET /{id}/deliveries", h.listDeliveries)
+
+	return h
+}
+
+func (h *HandlerWebhooks) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	LoggingMiddleware(h.mux).ServeHTTP(w, r)
+}
+
+func (h *HandlerWebhooks) list(w http.ResponseWriter, r *http.Request) {
+	subs, err := h.repo.GetSubscriptions()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(subs)
+}
+
+func (h *HandlerWebhooks) create(w http.ResponseWriter, r *http.Request) {
+	var req WebhookCreateDto
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if err := validateWebhook(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sub := &core.WebhookSubscription{
+		Id:         uuid.New(),
+		URL:        req.URL,
+		Secret:     req.Secret,
+		EventTypes: req.EventTypes,
+		AppIds:     req.AppIds,
+		CreatedAt:  time.Now(),
+	}
+	if err := h.repo.SaveSubscription(sub); err != nil {
+		http.Error(w, "Failed to save webhook", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(sub)
+}
+
+func validateWebhook(req WebhookCreateDto) error {
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url must be an absolute http or https URL")
+	}
+	if req.Secret == "" {
+		return fmt.Errorf("secret must not be empty")
+	}
+	for _, t := range req.EventTypes {
+		if !slices.Contains(core.EventTypes, t) {
+			return fmt.Errorf("unknown event type %s, supported types are %v", t, core.EventTypes)
+		}
+	}
+	return nil
+}
+
+func (h *HandlerWebhooks) delete(w http.ResponseWriter, r *http.Request) {
+	if err := h.repo.DeleteSubscription(r.PathValue("id")); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *HandlerWebhooks) listDeliveries(w http.ResponseWriter, r *http.Request) {
+	count := defaultDeliveryCount
+	if v := r.URL.Query().Get("count"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid count", http.StatusBadRequest)
+			return
+		}
+		count = n
+	}
+
+	deliveries, err := h.repo.GetDeliveries(r.PathValue("id"), count)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
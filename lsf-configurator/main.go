@@ -18,6 +18,7 @@ import (
 	"lsf-configurator/pkg/metrics"
 	"lsf-configurator/pkg/results"
 	"lsf-configurator/pkg/routing"
+	"lsf-configurator/pkg/webhooks"
 	"net/http"
 	"os"
 	"os/signal"
@@ -30,6 +31,8 @@ var composer *core.Composer
 var conf config.Configuration
 var metricsReader core.MetricsReader
 var resultsClient core.ResultsClient
+var webhookRepo core.WebhookRepository
+var reconciler core.Reconciler
 
 func main() {
 	logFile := configureLogging()
@@ -59,12 +62,16 @@ func main() {
 	functionAppRepo := repos.NewFunctionAppRepository(db)
 	fcRepo := repos.NewFunctionCompositionRepository(db)
 	deploymentRepo := repos.NewDeploymentRepository(db)
+	reconfigRepo := repos.NewReconfigurationRepository(db)
+	appVersionRepo := repos.NewAppVersionRepository(db)
+	controllerStateRepo := repos.NewControllerStateRepository(db)
+	webhookRepo = repos.NewWebhookRepository(db)
 
 	if err != nil {
 		log.Fatalf("failed to initialize database: %v", err)
 	}
 
-	metricsReader, err = metrics.NewMetricsReader(conf.MetricsBackendAddress)
+	metricsReader, err = metrics.NewMetricsReader(conf.MetricsBackendAddress, time.Duration(conf.RequestTimeoutSecs)*time.Second)
 	if err != nil {
 		log.Fatalf("failed to create metrics reader: %v", err)
 	}
@@ -87,10 +94,40 @@ func main() {
 		conf.InvocationSharedMemoryRatio, conf.ComponentMCPUAllocation, conf.OverheadMCPUAllocation, conf.TargetUtilization, conf.MemorySafetyBufferRatio)
 
 	controllerCtx, controllerCancel := context.WithCancel(context.Background())
-	controller = core.NewController(composer, metricsReader, scenarioManager,
+	webhookDispatcher := webhooks.NewDispatcher(webhookRepo)
+	composer.SetEventNotifier(webhookDispatcher)
+	composer.SetReadinessProber(dnsClient, time.Duration(conf.DeploymentReadinessTimeoutSecs)*time.Second)
+	if conf.BuildCacheEnabled {
+		composer.SetBuildCache(repos.NewBuildCacheRepository(db))
+	}
+	go webhookDispatcher.Run(controllerCtx)
+
+	controller = core.NewController(composer, metricsReader, scenarioManager, reconfigRepo, controllerStateRepo, appVersionRepo,
 		time.Duration(conf.ControllerTickDelaySeconds)*time.Second, conf.DeployNamespace,
-		conf.AvailableNodeMemoryGb, core.MetricType(conf.ControllerMetricType),
-		conf.ControllerMetricQueryTimeRange, conf.LatencyDowngradeFactor)
+		conf.AvailableNodeMemoryGb, core.ControllerSettings{
+			CooldownSeconds:        &conf.ControllerCooldownSeconds,
+			DowngradeFactor:        &conf.LatencyDowngradeFactor,
+			MetricType:             core.MetricType(conf.ControllerMetricType),
+			MetricQueryTimeRange:   conf.ControllerMetricQueryTimeRange,
+			MinimalTraceCount:      &conf.ControllerMinimalTraceCount,
+			DryRun:                 &conf.ControllerDryRun,
+			SLOTarget:              conf.ControllerSLOTarget,
+			SLOPeriodDays:          conf.ControllerSLOPeriodDays,
+			SLODowngradeBurnRate:   &conf.ControllerSLODowngradeBurnRate,
+			ForecastHorizonSeconds: &conf.ControllerForecastHorizonSecs,
+			ErrorRateThreshold:     &conf.ControllerErrorRateThreshold,
+		}, core.ProfilerSettings{
+			Interval:       time.Duration(conf.ProfilerIntervalSeconds) * time.Second,
+			TimeRange:      conf.ProfilerTimeRange,
+			DriftThreshold: conf.ProfilerDriftThreshold,
+			MinSamples:     conf.ProfilerMinSamples,
+		}, core.PlacementSettings{
+			Enabled:             conf.NodePressurePlacement,
+			Nodes:               conf.PlatformNodes,
+			CPUUtilizationLimit: conf.NodeCPUUtilizationLimit,
+			NodeMCPU:            conf.NodeMCPUCapacity,
+		}, core.WithMaxConcurrentTransitions(conf.ControllerMaxTransitions))
+	reconciler = core.NewReconciler(composer, dnsClient, time.Duration(conf.ReconcilerIntervalSeconds)*time.Second, conf.ReconcilerRepair)
 
 	if !conf.LocalMode {
 		go func() {
@@ -100,6 +137,13 @@ func main() {
 				log.Println("Latency controller stopped gracefully")
 			}
 		}()
+		if conf.ReconcilerIntervalSeconds > 0 {
+			go func() {
+				if err := reconciler.Start(controllerCtx); err != nil {
+					log.Printf("Reconciler stopped with error: %v", err)
+				}
+			}()
+		}
 	}
 
 	resultsClient = results.NewRedisResultsClient(conf.RedisUrl)
@@ -139,6 +183,8 @@ func registerHandlers(mux *http.ServeMux) {
 	mux.Handle(api.DeploymentsPath+"/", http.StripPrefix(api.DeploymentsPath, api.NewHandlerDeployments(composer, conf)))
 	mux.Handle(api.FunctionCompositionsPath+"/", http.StripPrefix(api.FunctionCompositionsPath, api.NewHandlerFunctionCompositions(composer, conf)))
 	mux.Handle(api.MetricsPath+"/", http.StripPrefix(api.MetricsPath, api.NewHandlerMetrics(metricsReader, conf)))
+	mux.Handle(api.DriftPath+"/", http.StripPrefix(api.DriftPath, api.NewHandlerDrift(reconciler)))
+	mux.Handle(api.WebhooksPath+"/", http.StripPrefix(api.WebhooksPath, api.NewHandlerWebhooks(webhookRepo)))
 	mux.Handle(api.ResultsPath+"/", http.StripPrefix(api.ResultsPath, api.NewHandlerResults(resultsClient)))
 	mux.Handle("/", api.SpaHandler("./public"))
 }
@@ -79,6 +79,10 @@ func (b *TektonBuilder) Build(ctx context.Context, fc core.FunctionComposition,
 	}
 
 	image := fmt.Sprintf("%s/%s/%s", b.ImageRegistry, b.ImageRepo, fc.Id)
+	if fc.Build.SourceRevision > 0 {
+		// every source revision gets its own tag, deployments of the previous revision keep running their image
+		image = fmt.Sprintf("%s:r%d", image, fc.Build.SourceRevision)
+	}
 	prName := fmt.Sprintf("build-%s", uuid.New())
 
 	uploadsFolder := getEnv("UPLOAD_DIR", "/uploads")
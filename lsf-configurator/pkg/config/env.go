@@ -35,6 +35,27 @@ type Configuration struct {
 	ControllerTickDelaySeconds     int      `env:"CONTROLLER_TICK_DELAY_SECONDS" default:"1"`
 	ControllerMetricType           string   `env:"CONTROLLER_METRIC_TYPE" default:"AVG"`
 	ControllerMetricQueryTimeRange string   `env:"CONTROLLER_METRIC_QUERY_TIME_RANGE" default:"now-5m"`
+	ControllerCooldownSeconds      int      `env:"CONTROLLER_COOLDOWN_SECONDS" default:"120"`
+	ControllerMinimalTraceCount    int      `env:"CONTROLLER_MINIMAL_TRACE_COUNT" default:"10"`
+	ControllerDryRun               bool     `env:"CONTROLLER_DRY_RUN" default:"false"`
+	ControllerSLOTarget            float64  `env:"CONTROLLER_SLO_TARGET" default:"0.99"`
+	ControllerSLOPeriodDays        int      `env:"CONTROLLER_SLO_PERIOD_DAYS" default:"30"`
+	ControllerSLODowngradeBurnRate float64  `env:"CONTROLLER_SLO_DOWNGRADE_BURN_RATE" default:"0.5"`
+	ControllerForecastHorizonSecs  int      `env:"CONTROLLER_FORECAST_HORIZON_SECONDS" default:"0"` // 0 disables forecast-based upgrades
+	ControllerErrorRateThreshold   float64  `env:"CONTROLLER_ERROR_RATE_THRESHOLD" default:"0.2"`
+	RequestTimeoutSecs             int      `env:"REQUEST_TIMEOUT_SECONDS" default:"10"`               // traces younger than this are still running, not incomplete
+	DeploymentReadinessTimeoutSecs int      `env:"DEPLOYMENT_READINESS_TIMEOUT_SECONDS" default:"120"` // deployments not ready by then are in error, a layout transition is rolled back
+	ControllerMaxTransitions       int      `env:"CONTROLLER_MAX_CONCURRENT_TRANSITIONS" default:"2"`  // 0 removes the limit
+	ProfilerIntervalSeconds        int      `env:"PROFILER_INTERVAL_SECONDS" default:"0"`              // 0 disables re-profiling
+	ProfilerTimeRange              string   `env:"PROFILER_TIME_RANGE" default:"now-15m"`
+	ProfilerDriftThreshold         float64  `env:"PROFILER_DRIFT_THRESHOLD" default:"0.3"`
+	ProfilerMinSamples             int      `env:"PROFILER_MIN_SAMPLES" default:"50"`
+	ReconcilerIntervalSeconds      int      `env:"RECONCILER_INTERVAL_SECONDS" default:"60"` // 0 disables the periodic reconciliation
+	ReconcilerRepair               bool     `env:"RECONCILER_REPAIR" default:"true"`         // false only reports the drift
+	BuildCacheEnabled              bool     `env:"BUILD_CACHE_ENABLED" default:"true"`       // reuse images of compositions built from identical sources
+	NodePressurePlacement          bool     `env:"NODE_PRESSURE_PLACEMENT" default:"true"`
+	NodeCPUUtilizationLimit        float64  `env:"NODE_CPU_UTILIZATION_LIMIT" default:"0.8"`
+	NodeMCPUCapacity               int      `env:"NODE_MCPU_CAPACITY" default:"0"` // 0 leaves the CPU promised to deployments unchecked
 	PlatformNodes                  []string `env:"PLATFORM_NODES"`
 	PlatformDelayMs                int      `env:"PLATFORM_DELAY_MS"`
 	AvailableNodeMemoryGb          int      `env:"AVAILABLE_NODE_MEMORY_GB"`
@@ -9,6 +9,8 @@ package core
 
 import (
 	"context"
+	"mime/multipart"
+	"time"
 )
 
 type KnClient interface {
@@ -36,12 +38,38 @@ type MetricsReader interface {
 	QueryNodeMetrics() ([]NodeMetrics, error)
 	Query95thPercentileAppRuntimes(timeRangeGte string) (map[string]float64, map[string]int, error)
 	QueryAverageAppRuntimes(timeRangeGte string) (map[string]float64, map[string]int, error)
+	// QueryLatencyViolations returns the fraction of traces per app exceeding the app's latency limit and the number of traces
+	QueryLatencyViolations(timeRangeGte string, latencyLimits map[string]int) (map[string]float64, map[string]int, error)
+	// QueryComponentSpanStats returns the span statistics per app and span name
+	QueryComponentSpanStats(timeRangeGte string) (map[string]map[string]SpanStats, error)
+	// QueryPathRuntimes returns the runtime of the path to each end component per app and the number of traces it was measured on
+	QueryPathRuntimes(timeRangeGte string, metricType MetricType) (map[string]map[string]float64, map[string]map[string]int, error)
+	// QueryAppHealth returns the number of failed and unfinished traces per app
+	QueryAppHealth(timeRangeGte string) (map[string]AppHealth, error)
+	// QueryServiceMemoryUsage returns the average pod memory usage in MB per knative service (deployment id)
+	QueryServiceMemoryUsage(timeRangeGte string) (map[string]float64, error)
 	EnsureIndex(ctx context.Context, indexName string) error
 }
 
 type Controller interface {
 	Start(ctx context.Context) error
+	// Tick runs a single evaluation round, Start calls it periodically
+	Tick()
 	RegisterFunctionApp(creationData FunctionAppCreationData) (*FunctionApp, error)
+	GetReconfigurations(appId string, from, to time.Time) ([]*ReconfigurationRecord, error)
+	SetActiveLayout(appId, layoutKey string) error
+	SetPaused(appId string, paused bool) error
+	UpdateSource(appId string, files []*multipart.FileHeader) (*ReconfigurationRecord, error)
+	GetVersions(appId string) ([]*AppVersion, error)
+	RollbackToVersion(appId string, version int) (*ReconfigurationRecord, error)
+}
+
+type Reconciler interface {
+	Start(ctx context.Context) error
+	// Reconcile runs a single round, converging the drift confirmed by the previous round, and returns its report
+	Reconcile(ctx context.Context) DriftReport
+	// LastReport returns the report of the latest round
+	LastReport() DriftReport
 }
 
 type LayoutCalculator interface {
@@ -49,11 +77,17 @@ type LayoutCalculator interface {
 }
 
 type ScenarioManager interface {
+	// GenerateLayoutCandidates calculates a layout per rate level. pathLatencyReqs are the latency requirements of the paths
+	// to individual end components in addition to appLatencyReq. memoryAvailable is the memory of a node in MB,
+	// freeMemory the memory in MB per platform node which is not promised to other apps, nil if it is unknown.
 	GenerateLayoutCandidates(
 		components []Component,
 		links []ComponentLink,
+		rateLevels []float64,
 		appLatencyReq int,
-		memoryAvailable int) (map[string]Layout, error)
+		pathLatencyReqs map[string]int,
+		memoryAvailable int,
+		freeMemory map[string]int) (map[string]Layout, []LayoutLevel, error)
 }
 
 type ResultsClient interface {
@@ -64,3 +98,18 @@ type DNSClient interface {
 	EnsureDNSRecord(ctx context.Context, namespace, appName, targetServiceName string) error
 	DeleteDNSRecord(ctx context.Context, namespace, appName string) error
 }
+
+// EventNotifier receives the events of the composer and the controller, Notify must not block
+type EventNotifier interface {
+	Notify(event Event)
+}
+
+type ServiceLister interface {
+	// ListServices returns the knative services labelled with AppLabel in every namespace
+	ListServices(ctx context.Context) ([]KnativeService, error)
+}
+
+type ReadinessProber interface {
+	// WaitForServiceReady blocks until the knative service is ready to serve requests, or returns an error once ctx is done
+	WaitForServiceReady(ctx context.Context, namespace, serviceName string) error
+}
@@ -0,0 +1,167 @@
+package core
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// pendingTransitionTimeout is how long a transition may wait for a slot before the decision is considered outdated
+const pendingTransitionTimeout = 2 * time.Minute
+
+// WithMaxConcurrentTransitions caps the number of layout transitions in progress over all apps, 0 means no limit
+func WithMaxConcurrentTransitions(n int) ControllerOption {
+	return func(c *latencyController) {
+		c.transitions.maxConcurrent = n
+	}
+}
+
+// pendingTransition is a reconfiguration decided by the policy of an app, waiting to be started by the arbiter
+type pendingTransition struct {
+	app        *FunctionApp
+	decision   PolicyDecision
+	policyName string
+	record     *ReconfigurationRecord
+	handler    func(*FunctionApp, string, *ReconfigurationRecord) (string, error)
+	dryRun     bool
+	severity   float64 // how far the app misses its latency objective, 1 means right at the limit
+	queuedAt   time.Time
+}
+
+// urgent reports whether the transition reacts to a violation, everything but a downgrade does
+func (t *pendingTransition) urgent() bool {
+	return t.decision.Action != ActionDowngrade
+}
+
+// transitionSeverity rates how badly the app misses its latency objective, as a multiple of what the objective allows
+func transitionSeverity(app *FunctionApp, signals appSignals) float64 {
+	var severity float64
+	if signals.hasMetric && app.LatencyLimit > 0 {
+		severity = signals.metric / float64(app.LatencyLimit)
+	}
+	if signals.burnRates != nil {
+		// a burn rate of 1 spends the error budget exactly over the SLO period
+		severity = max(severity, signals.burnRates.FastShort)
+	}
+	return severity
+}
+
+// transitionArbiter decides when the transitions requested by the apps may start. It caps the number of transitions
+// in progress over all apps, so a load spike hitting several apps does not start all their deployments at once.
+// Upgrades start in the order of app priority and severity, downgrades only start while no upgrade is waiting.
+type transitionArbiter struct {
+	mu            sync.Mutex
+	maxConcurrent int                           // 0 means no limit
+	pending       map[string]*pendingTransition // appId -> latest transition requested for the app
+	running       map[string]string             // reconfiguration record id -> appId
+}
+
+func newTransitionArbiter() *transitionArbiter {
+	return &transitionArbiter{
+		pending: make(map[string]*pendingTransition),
+		running: make(map[string]string),
+	}
+}
+
+// request queues the transition, replacing the one the app requested before. A repeated request for the same action
+// keeps its place in the queue.
+func (a *transitionArbiter) request(t *pendingTransition) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if prev, ok := a.pending[t.app.Id]; ok && prev.decision.Action == t.decision.Action {
+		t.queuedAt = prev.queuedAt
+	}
+	a.pending[t.app.Id] = t
+}
+
+// next removes the transitions which may start now from the queue and counts them as running.
+// Transitions of apps which were removed, paused or moved to another layout since the request are dropped.
+func (a *transitionArbiter) next(now time.Time, apps []*FunctionApp) []*pendingTransition {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	current := make(map[string]*FunctionApp, len(apps))
+	for _, app := range apps {
+		current[app.Id] = app
+	}
+	busy := make(map[string]bool, len(a.running))
+	for _, appId := range a.running {
+		busy[appId] = true
+	}
+
+	var queue []*pendingTransition
+	for appId, t := range a.pending {
+		app, ok := current[appId]
+		if !ok || app.ControllerPaused || app.ActiveLayoutKey != t.app.ActiveLayoutKey {
+			delete(a.pending, appId)
+			continue
+		}
+		if now.Sub(t.queuedAt) > pendingTransitionTimeout {
+			log.Printf("Dropping %s of app %s, it waited for a transition slot for more than %s", t.decision.Action, appId, pendingTransitionTimeout)
+			delete(a.pending, appId)
+			continue
+		}
+		// the priority may have changed while the transition was waiting
+		t.app.Priority = app.Priority
+		queue = append(queue, t)
+	}
+	sort.Slice(queue, func(i, j int) bool {
+		ti, tj := queue[i], queue[j]
+		if ti.urgent() != tj.urgent() {
+			return ti.urgent()
+		}
+		if ti.app.Priority != tj.app.Priority {
+			return ti.app.Priority > tj.app.Priority
+		}
+		if ti.severity != tj.severity {
+			return ti.severity > tj.severity
+		}
+		if !ti.queuedAt.Equal(tj.queuedAt) {
+			return ti.queuedAt.Before(tj.queuedAt)
+		}
+		return ti.app.Id < tj.app.Id
+	})
+
+	var started []*pendingTransition
+	upgradeWaiting := false
+	for _, t := range queue {
+		if !t.urgent() && upgradeWaiting {
+			break
+		}
+		if busy[t.app.Id] {
+			// the previous transition of the app is still being deployed
+			upgradeWaiting = upgradeWaiting || t.urgent()
+			continue
+		}
+		if a.maxConcurrent > 0 && len(a.running) >= a.maxConcurrent {
+			break
+		}
+		delete(a.pending, t.app.Id)
+		a.running[t.record.Id] = t.app.Id
+		busy[t.app.Id] = true
+		started = append(started, t)
+	}
+	return started
+}
+
+// occupy counts a reconfiguration which does not go through the queue as running, so the app gets no transition
+// until it finishes. False if a transition of the app is already in progress.
+func (a *transitionArbiter) occupy(recordId, appId string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, running := range a.running {
+		if running == appId {
+			return false
+		}
+	}
+	a.running[recordId] = appId
+	return true
+}
+
+// finish frees the slot of the transition carried out by the reconfiguration, other records are ignored
+func (a *transitionArbiter) finish(recordId string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.running, recordId)
+}
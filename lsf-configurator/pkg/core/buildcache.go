@@ -0,0 +1,134 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// CompositionTemplateVersion identifies the composition templates the images are built from. It is part of the
+// build cache key, so it has to be increased whenever a template changes, otherwise images of the old one are reused.
+const CompositionTemplateVersion = "1"
+
+// requirementsFile holds the dependencies of the app, merged into the requirements of the template on every build
+const requirementsFile = "requirements.txt"
+
+// BuildCacheEntry is an image built for a composition, reused by compositions built from the same sources
+type BuildCacheEntry struct {
+	Key                   string    `json:"key"`
+	Image                 string    `json:"image"`
+	FunctionCompositionId string    `json:"function_composition_id"` // the composition the image was built for
+	CreatedAt             time.Time `json:"created_at"`
+}
+
+// SetBuildCache makes the composer reuse the images of compositions built from the same sources, nil builds every composition
+func (c *Composer) SetBuildCache(cache BuildCacheRepository) {
+	c.buildCache = cache
+}
+
+// cachedBuild looks up an image built from the same sources as the composition. The key is also returned on a miss,
+// so the image can be cached once it is built, an empty key means the composition is not cached.
+func (c *Composer) cachedBuild(fc *FunctionComposition, runtime, sourceDir string) (string, *BuildCacheEntry) {
+	if c.buildCache == nil {
+		return "", nil
+	}
+	key, err := compositionBuildKey(fc, runtime, sourceDir)
+	if err != nil {
+		log.Printf("Could not compute the build cache key of function composition %s: %v", fc.Id, err)
+		return "", nil
+	}
+	entry, err := c.buildCache.Get(key)
+	if err != nil {
+		log.Printf("Error reading the build cache for function composition %s: %v", fc.Id, err)
+		return key, nil
+	}
+	return key, entry
+}
+
+// cacheBuild stores the image of a built composition under the cache key it was built with
+func (c *Composer) cacheBuild(fc *FunctionComposition) {
+	if c.buildCache == nil || fc.Build.CacheKey == "" || fc.Build.Image == "" {
+		return
+	}
+	entry := &BuildCacheEntry{
+		Key:                   fc.Build.CacheKey,
+		Image:                 fc.Build.Image,
+		FunctionCompositionId: fc.Id,
+		CreatedAt:             time.Now(),
+	}
+	if err := c.buildCache.Save(entry); err != nil {
+		log.Printf("Error caching the image of function composition %s: %v", fc.Id, err)
+	}
+}
+
+// compositionBuildKey hashes everything a build of the composition depends on: the template version, the runtime,
+// the component sources, the non-source files of the components and the dependencies of the app. Compositions of
+// different apps built from identical sources share a key.
+func compositionBuildKey(fc *FunctionComposition, runtime, sourceDir string) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "template %s\nruntime %s\n", CompositionTemplateVersion, runtime)
+
+	ext := runtimeExtensions[runtime]
+	components := slices.Clone(fc.Components)
+	slices.Sort(components)
+	for _, comp := range components {
+		if err := hashFile(h, sourceDir, comp+ext); err != nil {
+			return "", err
+		}
+	}
+	files := slices.Clone(fc.Files)
+	slices.Sort(files)
+	for _, f := range slices.Compact(files) {
+		if err := hashFile(h, sourceDir, f); err != nil {
+			return "", err
+		}
+	}
+
+	requirements, err := readRequirements(filepath.Join(sourceDir, requirementsFile))
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(h, "requirements %s\n", strings.Join(requirements, "\n"))
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func hashFile(h hash.Hash, dir, name string) error {
+	content, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return fmt.Errorf("could not read %s: %w", name, err)
+	}
+	fmt.Fprintf(h, "file %s %d\n", name, len(content))
+	h.Write(content)
+	return nil
+}
+
+// readRequirements returns the sorted, distinct requirements of the file, the way they are merged into the template.
+// A missing file has no requirements.
+func readRequirements(path string) ([]string, error) {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var requirements []string
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			requirements = append(requirements, line)
+		}
+	}
+	slices.Sort(requirements)
+	return slices.Compact(requirements), scanner.Err()
+}
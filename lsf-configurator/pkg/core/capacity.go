@@ -0,0 +1,135 @@
+package core
+
+import (
+	"fmt"
+	"log"
+)
+
+// NodeCapacity is an amount of node resources
+type NodeCapacity struct {
+	Memory int // in MB
+	MCPU   int
+}
+
+// capacityLedger accounts for the resources promised to the deployments of all apps on each node. A deployment holds
+// its resource requests for every replica it may scale to, so the scheduler can place it whether its replicas run or not.
+type capacityLedger struct {
+	apps        FunctionAppRepository
+	deployments DeploymentRepository
+	nodes       []string     // the platform nodes layouts are calculated for
+	perNode     NodeCapacity // resources of a single node, a zero field is not accounted for
+}
+
+// promised returns the resources promised to the deployments on each node, the deployments of excludeAppId are left out
+func (l *capacityLedger) promised(excludeAppId string) (map[string]NodeCapacity, error) {
+	apps, err := l.apps.GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list function apps: %w", err)
+	}
+
+	promised := make(map[string]NodeCapacity)
+	for _, app := range apps {
+		if app.Id == excludeAppId {
+			continue
+		}
+		deployments, err := l.deployments.GetByFunctionAppID(app.Id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list deployments of app %s: %w", app.Id, err)
+		}
+		for _, d := range deployments {
+			if d.Status == DeploymentStatusError {
+				continue
+			}
+			p := promised[d.Node]
+			p.Memory += d.Resources.Memory * d.Scale.MaxReplicas
+			p.MCPU += d.Resources.CPU * d.Scale.MaxReplicas
+			promised[d.Node] = p
+		}
+	}
+	return promised, nil
+}
+
+// freeMemory returns the memory in MB of each platform node which is not promised to the deployments of other apps,
+// nil if the platform nodes or their memory are unknown
+func (l *capacityLedger) freeMemory(excludeAppId string) map[string]int {
+	if len(l.nodes) == 0 || l.perNode.Memory <= 0 {
+		return nil
+	}
+	promised, err := l.promised(excludeAppId)
+	if err != nil {
+		log.Printf("Error accounting node capacity, planning with the full node memory: %v", err)
+		return nil
+	}
+	free := make(map[string]int, len(l.nodes))
+	for _, node := range l.nodes {
+		free[node] = max(0, l.perNode.Memory-promised[node].Memory)
+	}
+	return free
+}
+
+// checkCapacity returns errNodeOvercommit if the deployments the layout adds would promise more than a node has.
+// The app is accounted for with the deployments it has once the transition finished: deployments it reuses keep their
+// resources and the ones the transition deletes are left out. The compositions in replaced get new deployments next to
+// their current ones, both are counted since they run side by side until traffic is switched.
+func (c *latencyController) checkCapacity(app *FunctionApp, layout Layout, replaced map[string]bool) error {
+	perNode := c.capacity.perNode
+	if perNode.Memory <= 0 && perNode.MCPU <= 0 {
+		return nil
+	}
+	promised, err := c.capacity.promised(app.Id)
+	if err != nil {
+		log.Printf("Error accounting node capacity for app %s, skipping the capacity check: %v", app.Id, err)
+		return nil
+	}
+
+	compositions := app.Compositions
+	if len(compositions) == 0 {
+		if full, err := c.composer.GetFunctionApp(app.Id); err == nil && full != nil {
+			compositions = full.Compositions
+		}
+	}
+	deployed := make(map[string]*Deployment) // componentsKey@node -> deployment of the app
+	replacedKeys := make(map[string]bool)    // componentsKey of the compositions in replaced
+	for _, fc := range compositions {
+		if replaced[fc.Id] {
+			replacedKeys[componentsKey(fc.Components)] = true
+		}
+		for _, d := range fc.Deployments {
+			if d.Status != DeploymentStatusError {
+				deployed[componentsKey(fc.Components)+"@"+d.Node] = d
+			}
+		}
+	}
+
+	added := make(map[string]bool)
+	for node, info := range layout {
+		names := make([]string, len(info.ComponentProfiles))
+		for i, cp := range info.ComponentProfiles {
+			names[i] = cp.Name
+		}
+		key := componentsKey(names)
+		p := promised[node]
+		d, ok := deployed[key+"@"+node]
+		if ok {
+			p.Memory += d.Resources.Memory * d.Scale.MaxReplicas
+			p.MCPU += d.Resources.CPU * d.Scale.MaxReplicas
+		}
+		if !ok || replacedKeys[key] {
+			p.Memory += info.TotalMemory()
+			p.MCPU += info.MCPU * info.RequiredReplicas
+			added[node] = true
+		}
+		promised[node] = p
+	}
+
+	for node := range added {
+		p := promised[node]
+		if perNode.Memory > 0 && p.Memory > perNode.Memory {
+			return fmt.Errorf("%w: node %s would have %dMB of its %dMB memory promised", errNodeOvercommit, node, p.Memory, perNode.Memory)
+		}
+		if perNode.MCPU > 0 && p.MCPU > perNode.MCPU {
+			return fmt.Errorf("%w: node %s would have %dm of its %dm CPU promised", errNodeOvercommit, node, p.MCPU, perNode.MCPU)
+		}
+	}
+	return nil
+}
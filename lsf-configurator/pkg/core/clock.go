@@ -0,0 +1,51 @@
+package core
+
+import "time"
+
+// Clock is the time source of the controller, it is replaced by a virtual clock in simulations
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// ControllerOption customizes a controller created by NewController
+type ControllerOption func(c *latencyController)
+
+// WithClock makes the controller read the time from clock instead of the system clock
+func WithClock(clock Clock) ControllerOption {
+	return func(c *latencyController) {
+		c.clock = clock
+	}
+}
+
+// WithSynchronousDeployments makes the controller finish deployments and the cleanup of unused deployments
+// before returning, so the effects of a tick are visible as soon as Tick returns
+func WithSynchronousDeployments() ControllerOption {
+	return func(c *latencyController) {
+		c.synchronous = true
+	}
+}
+
+// async runs fn in the background, or inline for synchronous controllers
+func (c *latencyController) async(fn func()) {
+	if c.synchronous {
+		fn()
+		return
+	}
+	go fn()
+}
+
+// after runs fn in the background once delay has passed, synchronous controllers run it inline right away
+func (c *latencyController) after(delay time.Duration, fn func()) {
+	if c.synchronous {
+		fn()
+		return
+	}
+	go func() {
+		time.Sleep(delay)
+		fn()
+	}()
+}
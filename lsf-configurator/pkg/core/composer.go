@@ -35,7 +35,15 @@ type Composer struct {
 	deploymentRepo     DeploymentRepository
 	metricsReader      MetricsReader
 	pendingDeployments map[string]chan Result // key = deploymentId
+	events             EventNotifier          // nil drops the events
 	mu                 sync.Mutex
+	deploying          map[string]bool // deployments with a deploy task in flight
+	deployingMu        sync.Mutex
+	rebuilds           map[string]*rebuild  // key = fcId, guarded by mu
+	buildCache         BuildCacheRepository // nil builds every composition
+	readiness          ReadinessProber      // nil reports deployments deployed once the deploy call returns
+	readinessTimeout   time.Duration
+	recordBuiltVersion func(appId string) // called after a composition of the app was built, records the first version of the app once all of them are
 }
 
 func NewComposer(
@@ -73,17 +81,33 @@ func (c *Composer) ListFunctionApps() ([]*FunctionApp, error) {
 }
 
 func (c *Composer) CreateFunctionApp(creationData FunctionAppCreationData) (*FunctionApp, error) {
+	if _, err := GetReconfigurationPolicy(creationData.ControllerPolicy); err != nil {
+		return nil, err
+	}
+	if creationData.ControllerSettings != nil {
+		if err := creationData.ControllerSettings.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	if err := ValidatePathLatencyLimits(creationData.Components, creationData.Links, creationData.PathLatencyLimits); err != nil {
+		return nil, err
+	}
+
 	id := uuid.New()
 	fcApp := FunctionApp{
-		Id:           id,
-		Name:         creationData.AppName,
-		Compositions: make([]*FunctionComposition, 0),
-		Files:        make([]string, 0),
-		SourcePath:   "",
-		Components:   creationData.Components,
-		Links:        creationData.Links,
-		Runtime:      strings.ToLower(creationData.Runtime),
-		LatencyLimit: creationData.LatencyLimit,
+		Id:                 id,
+		Name:               creationData.AppName,
+		Compositions:       make([]*FunctionComposition, 0),
+		Files:              make([]string, 0),
+		SourcePath:         "",
+		Components:         creationData.Components,
+		Links:              creationData.Links,
+		Runtime:            strings.ToLower(creationData.Runtime),
+		LatencyLimit:       creationData.LatencyLimit,
+		ControllerPolicy:   creationData.ControllerPolicy,
+		ControllerSettings: creationData.ControllerSettings,
+		Priority:           creationData.Priority,
+		PathLatencyLimits:  creationData.PathLatencyLimits,
 	}
 
 	appDir := filepath.Join(creationData.UploadDir, fcApp.Id)
@@ -235,15 +259,23 @@ func (c *Composer) AddFunctionComposition(appId string, components []string, ima
 		Components:    components,
 		Files:         files,
 		Status:        BuildStatusPending,
+		Build:         Build{SourceRevision: fcApp.SourceRevision},
 	}
 
 	if image != "" {
 		fc.Build.Image = image
 		fc.Build.Timestamp = createBuildTimestamp()
 		fc.Status = BuildStatusBuilt
+	} else if key, cached := c.cachedBuild(fc, fcApp.Runtime, fcApp.SourceDir()); cached != nil {
+		fc.Build.Image = cached.Image
+		fc.Build.Timestamp = createBuildTimestamp()
+		fc.Build.CacheKey = key
+		fc.Status = BuildStatusBuilt
+		log.Infof("Reusing cached image %v for function composition with id %v", cached.Image, fc.Id)
 	} else {
+		fc.Build.CacheKey = key
 		go func() {
-			resultChan := c.scheduler.AddTask(c.buildTask(*fc, fcApp.Runtime, fcApp.SourcePath), MaxRetries)
+			resultChan := c.scheduler.AddTask(c.buildTask(*fc, fcApp.Runtime, fcApp.SourceDir()), MaxRetries)
 			r := <-resultChan
 			if r.Err != nil {
 				log.Errorf("Building of function composition with id %v failed: %v", fc.Id, r.Err)
@@ -317,9 +349,10 @@ func (c *Composer) CreateFcDeployment(fcId, namespace, node string, routingTable
 	}
 
 	var resultChan <-chan Result
-	if fc.Status == BuildStatusBuilt {
+	built := fc.Status == BuildStatusBuilt
+	if built {
 		deployment.Status = DeploymentStatusPending
-		resultChan = c.startDeployment(&deployment, fc)
+		deployment.Image = fc.Build.Image
 	} else {
 		deployment.Status = DeploymentStatusWaitingForBuild
 		log.Infof("Function composition with id %s is not built yet, deployment will be started after build is ready", fcId)
@@ -345,6 +378,10 @@ func (c *Composer) CreateFcDeployment(fcId, namespace, node string, routingTable
 		return nil, nil, fmt.Errorf("failed to save deployment: %w", err)
 	}
 
+	// started only once saved, so the pending deployment never overwrites the status the deployment finished with
+	if built {
+		resultChan = c.startDeployment(&deployment, fc)
+	}
 	return &deployment, resultChan, nil
 }
 
@@ -396,9 +433,19 @@ func (c *Composer) NotifyBuildReady(fcId, imageURL string, status string) {
 		return
 	}
 
+	if c.finishRebuild(fc, imageURL, status) {
+		return
+	}
+	if fc.Status == BuildStatusBuilt {
+		// only rebuilds finish for built compositions, this one was cancelled and the composition keeps its image
+		log.Warnf("Ignoring build notification of cancelled rebuild of function composition %s", fcId)
+		return
+	}
+
 	// If the build failed, set status to failed
 	if strings.ToLower(status) == "failed" {
 		log.Errorf("Build for function composition %s failed", fcId)
+		c.notify(Event{Type: EventBuildFailed, AppId: fc.FunctionAppId, FunctionCompositionId: fc.Id, Error: "build failed"})
 		fc.Status = BuildStatusError
 		if err := c.fcRepo.Save(fc); err != nil {
 			log.Errorf("Failed to save function composition with id %s: %v", fc.Id, err)
@@ -414,6 +461,10 @@ func (c *Composer) NotifyBuildReady(fcId, imageURL string, status string) {
 		return
 	}
 	log.Infof("Successfully built function composition with id %v. Image: %v", fc.Id, fc.Build.Image)
+	c.cacheBuild(fc)
+	if c.recordBuiltVersion != nil {
+		c.recordBuiltVersion(fc.FunctionAppId)
+	}
 
 	deployments, err := c.deploymentRepo.GetByFunctionCompositionID(fc.Id)
 	if err != nil {
@@ -425,11 +476,10 @@ func (c *Composer) NotifyBuildReady(fcId, imageURL string, status string) {
 	for _, deployment := range deployments {
 		if deployment.Status == DeploymentStatusWaitingForBuild {
 			c.mu.Lock()
-			defer c.mu.Unlock()
 			ch, ok := c.pendingDeployments[deployment.Id]
+			delete(c.pendingDeployments, deployment.Id)
+			c.mu.Unlock()
 			if ok {
-				delete(c.pendingDeployments, deployment.Id)
-
 				deployment.Status = DeploymentStatusPending
 				depChan := c.startDeployment(deployment, fc)
 
@@ -460,13 +510,37 @@ func (c *Composer) setRoutingTable(deployment *Deployment, table RoutingTable) e
 	return nil
 }
 
-func (c *Composer) startDeployment(deployment *Deployment, fc *FunctionComposition) <-chan Result {
-	resultChan := c.scheduler.AddTask(c.deployTask(*deployment, fc.Build.Image, fc.FunctionAppId), MaxRetries)
-	go func(dep *Deployment, fc *FunctionComposition) {
+// startDeployment deploys the function composition, the result is only sent once the deployment status is saved,
+// so callers never observe a finished deployment which is still pending. A deployment keeps the image it was first
+// deployed with, so redeploying it never rolls out code the composition was rebuilt with in the meantime.
+// The status is saved from a copy of the deployment, the caller keeps its own and gets the saved one as the Value of the result.
+func (c *Composer) startDeployment(dep *Deployment, fc *FunctionComposition) <-chan Result {
+	if dep.Image == "" {
+		dep.Image = fc.Build.Image
+	}
+	deployment := *dep
+	c.setDeploying(deployment.Id, true)
+	resultChan := c.scheduler.AddTask(c.deployTask(deployment, deployment.Image, fc.FunctionAppId), MaxRetries)
+	statusChan := make(chan Result, 1)
+	go func(deployment *Deployment, fc *FunctionComposition) {
 		r := <-resultChan
+		defer func() {
+			c.setDeploying(deployment.Id, false)
+			r.Value = deployment
+			statusChan <- r
+			close(statusChan)
+		}()
+		if r.Err == nil {
+			// the deploy call returns before knative has started the revision
+			if err := c.waitServiceReady(deployment); err != nil {
+				r = Result{Err: err}
+			}
+		}
 		if r.Err != nil {
 			log.Errorf("Deploying of function composition with id %v and deploymentId %v failed: %v, ", fc.Id, deployment.Id, r.Err)
+			c.notify(Event{Type: EventDeploymentFailed, AppId: fc.FunctionAppId, FunctionCompositionId: fc.Id, DeploymentId: deployment.Id, Error: r.Err.Error()})
 			deployment.Status = DeploymentStatusError
+			deployment.StatusReason = r.Err.Error()
 			if err := c.deploymentRepo.Save(deployment); err != nil {
 				log.Errorf("Failed to save deployment with id %s: %v", deployment.Id, err)
 			}
@@ -474,11 +548,39 @@ func (c *Composer) startDeployment(deployment *Deployment, fc *FunctionCompositi
 		}
 		log.Infof("Successfully deployed function composition with id %v, deploymentId %v", fc.Id, deployment.Id)
 		deployment.Status = DeploymentStatusDeployed
+		deployment.StatusReason = ""
 		if err := c.deploymentRepo.Save(deployment); err != nil {
 			log.Errorf("Failed to save deployment with id %s: %v", deployment.Id, err)
 		}
-	}(deployment, fc)
-	return resultChan
+	}(&deployment, fc)
+	return statusChan
+}
+
+func (c *Composer) setDeploying(deploymentId string, deploying bool) {
+	c.deployingMu.Lock()
+	defer c.deployingMu.Unlock()
+	if c.deploying == nil {
+		c.deploying = make(map[string]bool)
+	}
+	if deploying {
+		c.deploying[deploymentId] = true
+	} else {
+		delete(c.deploying, deploymentId)
+	}
+}
+
+// deploymentInFlight reports whether the deployment is being deployed or waits for its build in this process
+func (c *Composer) deploymentInFlight(deploymentId string) bool {
+	c.deployingMu.Lock()
+	deploying := c.deploying[deploymentId]
+	c.deployingMu.Unlock()
+	if deploying {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, waiting := c.pendingDeployments[deploymentId]
+	return waiting
 }
 
 func (c *Composer) buildTask(fc FunctionComposition, runtime, sourcePath string) func() (interface{}, error) {
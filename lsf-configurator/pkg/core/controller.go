@@ -3,8 +3,11 @@ package core
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"lsf-configurator/pkg/uuid"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
@@ -14,10 +17,9 @@ import (
 // set target concurrency globally to 1 for now, but this can be different per function composition depending on how CPU-bound they are
 // this should be measured and set accordingly for each function composition)
 const (
-	minimalTraceCount       = 10
-	logInterval             = 1 * time.Minute
-	minConsecutiveDowngrade = 300
-	reuseDeployments        = true
+	logInterval            = 1 * time.Minute
+	reuseDeployments       = true
+	deploymentCleanupDelay = 10 * time.Second // time given to the routing to settle before unused deployments are deleted
 )
 
 type MetricType string
@@ -37,63 +39,105 @@ type ReconfigEvent struct {
 }
 
 type latencyController struct {
-	composer                     *Composer
-	metrics                      MetricsReader
-	scenarioManager              ScenarioManager
-	delay                        time.Duration
-	deployNamespace              string
-	lastReconfigs                map[string]time.Time
-	cooldownPeriod               time.Duration
-	availableNodeMemoryGb        int // same for all nodes for now, in GB
-	lastReconfigsMu              sync.Mutex
-	latencyDowngradeFactor       float64
-	aggMetricType                MetricType
-	metricQueryFunc              MetricQueryFunc
-	metricQueryTimeRange         string
-	reconfigStartTimes           map[string]time.Time
-	lastLogTime                  time.Time
-	consecutiveDowngradeEligible map[string]int // appId -> count of consecutive eligible downgrade intervals
+	composer              *Composer
+	metrics               MetricsReader
+	scenarioManager       ScenarioManager
+	delay                 time.Duration
+	deployNamespace       string
+	availableNodeMemoryGb int // same for all nodes for now, in GB
+	historyMu             sync.Mutex
+	history               map[string]*PolicyHistory // appId -> state kept for the reconfiguration policies
+	defaults              ControllerSettings        // used for every setting an app does not override
+	reconfigRepo          ReconfigurationRepository
+	stateRepo             ControllerStateRepository
+	versionRepo           AppVersionRepository
+	versionMu             sync.Mutex // serializes the numbering of recorded versions
+	profiler              ProfilerSettings
+	placement             PlacementSettings
+	lastProfileTime       time.Time
+	shadowLayoutKeys      map[string]string           // appId -> layout the app would be on if dry-run decisions had been applied
+	persistedAt           map[string]time.Time        // appId -> when the controller state of the app was last saved
+	violations            map[string]*violationRatios // time range -> latency violation ratios of the slower burn rate windows
+	lastLogTime           time.Time
+	clock                 Clock
+	synchronous           bool // deployments and cleanups run inline instead of in the background
+	transitions           *transitionArbiter
+	capacity              capacityLedger
 }
 
-func NewController(composer *Composer, metrics MetricsReader, scenarioManager ScenarioManager,
-	delay time.Duration, deployNamespace string, availableNodeMemoryGb int, aggMetricType MetricType,
-	metricQueryTimeRange string, latencyDowngradeFactor float64) Controller {
+type metricQuery struct {
+	metricType MetricType
+	timeRange  string
+}
+
+type metricQueryResult struct {
+	runtimes    map[string]float64
+	traceCounts map[string]int
+	err         error
+}
+
+// appSignals are the observations an app is evaluated on in a tick
+type appSignals struct {
+	metric     float64
+	hasMetric  bool // false if no traces were reported for the app in the current interval
+	traceCount int
+	burnRates  *BurnRates
+	coverage   *RateCoverage
+	health     *AppHealth
+	// requests per second over the metric query time range, estimated from the trace count
+	arrivalRate    float64
+	hasArrivalRate bool
+	path           string // end component of the path the metric was measured on, empty for the whole app
+}
 
-	if aggMetricType != MetricTypeP95 && aggMetricType != MetricTypeAverage {
-		log.Printf("Warning: Invalid metric type '%s' provided. Defaulting to P95.", aggMetricType)
-		aggMetricType = MetricTypeP95
+func NewController(composer *Composer, metrics MetricsReader, scenarioManager ScenarioManager, reconfigRepo ReconfigurationRepository,
+	stateRepo ControllerStateRepository, versionRepo AppVersionRepository, delay time.Duration, deployNamespace string, availableNodeMemoryGb int, defaults ControllerSettings,
+	profiler ProfilerSettings, placement PlacementSettings, opts ...ControllerOption) Controller {
+
+	if defaults.MetricType != MetricTypeP95 && defaults.MetricType != MetricTypeAverage {
+		log.Printf("Warning: Invalid metric type '%s' provided. Defaulting to P95.", defaults.MetricType)
+		defaults.MetricType = MetricTypeP95
 	}
 
-	var queryFunc MetricQueryFunc
-	switch aggMetricType {
-	case MetricTypeP95:
-		queryFunc = metrics.Query95thPercentileAppRuntimes
-	case MetricTypeAverage:
-		queryFunc = metrics.QueryAverageAppRuntimes
-	}
-
-	return &latencyController{
-		composer:                     composer,
-		metrics:                      metrics,
-		scenarioManager:              scenarioManager,
-		delay:                        delay,
-		deployNamespace:              deployNamespace,
-		lastReconfigs:                make(map[string]time.Time),
-		cooldownPeriod:               120 * time.Second,
-		availableNodeMemoryGb:        availableNodeMemoryGb,
-		lastReconfigsMu:              sync.Mutex{},
-		latencyDowngradeFactor:       latencyDowngradeFactor,
-		aggMetricType:                aggMetricType,
-		metricQueryFunc:              queryFunc,
-		metricQueryTimeRange:         metricQueryTimeRange,
-		reconfigStartTimes:           make(map[string]time.Time),
-		lastLogTime:                  time.Now(),
-		consecutiveDowngradeEligible: make(map[string]int),
+	c := &latencyController{
+		composer:              composer,
+		metrics:               metrics,
+		scenarioManager:       scenarioManager,
+		delay:                 delay,
+		deployNamespace:       deployNamespace,
+		availableNodeMemoryGb: availableNodeMemoryGb,
+		historyMu:             sync.Mutex{},
+		history:               make(map[string]*PolicyHistory),
+		defaults:              defaults,
+		reconfigRepo:          reconfigRepo,
+		stateRepo:             stateRepo,
+		versionRepo:           versionRepo,
+		profiler:              profiler,
+		placement:             placement,
+		shadowLayoutKeys:      make(map[string]string),
+		persistedAt:           make(map[string]time.Time),
+		violations:            make(map[string]*violationRatios),
+		clock:                 systemClock{},
+		transitions:           newTransitionArbiter(),
+		capacity: capacityLedger{
+			apps:        composer.functionAppRepo,
+			deployments: composer.deploymentRepo,
+			nodes:       placement.Nodes,
+			perNode:     NodeCapacity{Memory: availableNodeMemoryGb * 1024, MCPU: placement.NodeMCPU},
+		},
+	}
+	for _, opt := range opts {
+		opt(c)
 	}
+	c.lastLogTime = c.clock.Now()
+	c.restoreState()
+	composer.recordBuiltVersion = c.ensureVersion
+	return c
 }
 
 func (c *latencyController) Start(ctx context.Context) error {
 	log.Println("Latency Controller started")
+	c.recoverReconfigurations()
 	ticker := time.NewTicker(c.delay)
 	defer ticker.Stop()
 
@@ -103,196 +147,608 @@ func (c *latencyController) Start(ctx context.Context) error {
 			log.Println("Latency Controller received cancellation signal")
 			return nil
 		case <-ticker.C:
-			runtimes, traceCounts, err := c.metricQueryFunc(c.metricQueryTimeRange)
-			if err != nil {
-				log.Printf("Error querying app runtime metrics: %v", err)
-				continue
-			}
-			// Log runtimes at defined intervals
-			now := time.Now()
-			if now.Sub(c.lastLogTime) >= logInterval {
-				if len(runtimes) > 0 {
-					var runtimeStrings []string
-					for appID, rt := range runtimes {
-						runtimeStrings = append(runtimeStrings, fmt.Sprintf("%s: %.0fms", appID, rt))
-					}
-					sort.Strings(runtimeStrings)
-					log.Printf("Current app runtimes (%s): [%s]", c.aggMetricType, strings.Join(runtimeStrings, ", "))
-				} else {
-					log.Printf("No app runtimes reported in this interval.")
-				}
-				c.lastLogTime = now
-			}
+			c.Tick()
+		}
+	}
+}
 
-			handledApps := make(map[string]bool)
-			for appId, runtime := range runtimes {
-				handledApps[appId] = true
-				// log.Printf("App %s metrics with runtime agg func %s: %.0f ms", appId, c.aggMetricType, runtime)
-				app, err := c.composer.GetFunctionApp(appId)
-				if err != nil {
-					log.Printf("Error retrieving function app %s: %v", appId, err)
-					continue
-				}
-				if app == nil {
-					log.Printf("Found traces for app %s, but app is not registered in the database. Skipping", appId)
-					continue
-				}
+// Tick runs a single evaluation round over all registered apps
+func (c *latencyController) Tick() {
+	// Apps may use different metric types and time ranges, each distinct query is only executed once per tick
+	results := make(map[metricQuery]metricQueryResult)
+	defaultQuery := metricQuery{metricType: c.defaults.MetricType, timeRange: c.defaults.MetricQueryTimeRange}
+	results[defaultQuery] = c.queryMetrics(defaultQuery)
+	defaultResult := results[defaultQuery]
+	if defaultResult.err != nil {
+		log.Printf("Error querying app runtime metrics: %v", defaultResult.err)
+		return
+	}
 
-				if app.LatencyLimit <= 0 {
-					continue
-				}
+	apps, err := c.composer.functionAppRepo.GetAll()
+	if err != nil {
+		log.Printf("Error retrieving all function apps: %v", err)
+		return
+	}
+	paused := make(map[string]bool)
+	for _, app := range apps {
+		if app.ControllerPaused {
+			paused[app.Id] = true
+		}
+	}
 
-				c.lastReconfigsMu.Lock()
-				last, ok := c.lastReconfigs[app.Id]
-				if ok && time.Since(last) < c.cooldownPeriod {
-					// log.Printf("Skipping reconfiguration for app %s due to cooldown (last at %v)", app.Id, last)
-					c.lastReconfigsMu.Unlock()
-					continue
-				}
-				c.lastReconfigsMu.Unlock()
-
-				// Determine action based on runtime
-				var handler func(*FunctionApp) (string, error)
-				if runtime > float64(app.LatencyLimit) {
-					// Check trace count for upgrades to avoid reconfigurations based on insufficient data
-					count, ok := traceCounts[appId]
-					if !ok || count < minimalTraceCount {
-						// log.Printf("Skipping app %s reconfiguration due to insufficient trace count (%d < %d)", appId, count, minimalTraceCount)
-						continue
-					}
-					handler = c.handleLatencyViolation
-					// Reset downgrade counter on upgrade
-					c.lastReconfigsMu.Lock()
-					c.consecutiveDowngradeEligible[app.Id] = 0
-					c.lastReconfigsMu.Unlock()
-				} else if runtime < float64(app.LatencyLimit)*c.latencyDowngradeFactor {
-					// Oscillation defense for downgrade: track consecutive intervals
-					c.lastReconfigsMu.Lock()
-					c.consecutiveDowngradeEligible[app.Id]++
-					count := c.consecutiveDowngradeEligible[app.Id]
-					c.lastReconfigsMu.Unlock()
-					if count < minConsecutiveDowngrade {
-						// log.Printf("App %s eligible for downgrade (%d/%d consecutive intervals)", app.Id, count, minConsecutiveDowngrade)
-						continue
-					}
-					handler = c.handleLayoutDowngrade
-				} else {
-					// Reset counter if not eligible for downgrade
-					c.lastReconfigsMu.Lock()
-					c.consecutiveDowngradeEligible[app.Id] = 0
-					c.lastReconfigsMu.Unlock()
+	// Log runtimes at defined intervals, paused apps are reported as well
+	now := c.clock.Now()
+	if now.Sub(c.lastLogTime) >= logInterval {
+		if len(defaultResult.runtimes) > 0 {
+			var runtimeStrings []string
+			for appID, rt := range defaultResult.runtimes {
+				if paused[appID] {
+					runtimeStrings = append(runtimeStrings, fmt.Sprintf("%s: %.0fms (paused)", appID, rt))
 					continue
 				}
+				runtimeStrings = append(runtimeStrings, fmt.Sprintf("%s: %.0fms", appID, rt))
+			}
+			sort.Strings(runtimeStrings)
+			log.Printf("Current app runtimes (%s): [%s]", c.defaults.MetricType, strings.Join(runtimeStrings, ", "))
+		} else {
+			log.Printf("No app runtimes reported in this interval.")
+		}
+		c.lastLogTime = now
+	}
 
-				// Only for testing: measure reconfiguration duration
-				c.lastReconfigsMu.Lock()
-				c.reconfigStartTimes[app.Id] = time.Now()
-				c.lastReconfigsMu.Unlock()
+	if c.profiler.Interval > 0 && now.Sub(c.lastProfileTime) >= c.profiler.Interval {
+		c.profileApps(apps)
+		c.lastProfileTime = now
+	}
+	burnRates := c.queryBurnRates(apps)
+	spanStats := make(map[string]spanStatsResult)
+	pathResults := make(map[metricQuery]pathQueryResult)
+	health, err := c.metrics.QueryAppHealth(healthQueryTimeRange)
+	if err != nil {
+		log.Printf("Error querying app health: %v", err)
+	}
 
-				nextLayoutKey, err := handler(app)
-				if err != nil {
-					log.Printf("Error handling reconfiguration for app %s: %v", app.Id, err)
-					continue
-				}
-				if nextLayoutKey == "" {
-					//log.Printf("No further layout candidates available for app %s. Skipping reconfiguration.", app.Id)
-					continue
-				} else {
-					log.Printf("App %s transitioned to layout %s. Deploying...", app.Id, nextLayoutKey)
-				}
-				c.lastReconfigsMu.Lock()
-				c.lastReconfigs[app.Id] = time.Now()
-				c.lastReconfigsMu.Unlock()
+	registered := make(map[string]bool, len(apps))
+	for _, app := range apps {
+		registered[app.Id] = true
+		if app.ControllerPaused {
+			continue
+		}
+		settings := app.ControllerSettings.withDefaults(c.defaults)
+		if settings.dryRun() {
+			app = c.shadowView(app)
+		} else {
+			c.clearShadowLayout(app.Id)
+		}
+		query := metricQuery{metricType: settings.MetricType, timeRange: settings.MetricQueryTimeRange}
+		result, ok := results[query]
+		if !ok {
+			result = c.queryMetrics(query)
+			results[query] = result
+		}
+		if result.err != nil {
+			log.Printf("Error querying app runtime metrics for app %s: %v", app.Id, result.err)
+			continue
+		}
 
-				log.Printf("Reconfiguration in progress for app %s, applying cooldown period", app.Id)
-			}
+		signals := appSignals{burnRates: burnRates[app.Id]}
+		if window, err := dateMathWindow(settings.MetricQueryTimeRange); err == nil {
+			signals.arrivalRate = float64(result.traceCounts[app.Id]) / window.Seconds()
+			signals.hasArrivalRate = true
+		}
+		signals.coverage = c.rateCoverage(app, settings, signals, spanStats)
+		if h, ok := health[app.Id]; ok {
+			signals.health = &h
+		}
+		if runtime, ok := result.runtimes[app.Id]; ok {
+			signals.metric, signals.hasMetric, signals.traceCount = runtime, true, result.traceCounts[app.Id]
+		} else if app.isLowestLayout() && len(app.Schedule) == 0 && signals.burnRates == nil && signals.coverage == nil && !signals.health.failing(settings) {
+			// No reported runtimes (possible downgrade), burn rates, rates, failures and schedules are still evaluated as they can call for an upgrade
+			continue
+		}
+		c.pathSignals(app, query, pathResults, &signals)
+		c.evaluateApp(app, settings, signals)
+	}
+	c.dispatchTransitions(apps)
 
-			// Handle apps with no reported runtimes (possible downgrade)
-			apps, err := c.composer.functionAppRepo.GetAll()
-			if err != nil {
-				log.Printf("Error retrieving all function apps: %v", err)
-				continue
-			}
-			for _, app := range apps {
-				if handledApps[app.Id] {
-					continue
-				}
-				if app.LatencyLimit <= 0 {
-					continue
-				}
-				if app.ActiveLayoutKey == LayoutKeyMin {
-					continue
-				}
-				c.lastReconfigsMu.Lock()
-				last, ok := c.lastReconfigs[app.Id]
-				if ok && time.Since(last) < c.cooldownPeriod {
-					c.lastReconfigsMu.Unlock()
-					continue
-				}
-				c.lastReconfigsMu.Unlock()
+	for appId := range defaultResult.runtimes {
+		if !registered[appId] {
+			log.Printf("Found traces for app %s, but app is not registered in the database. Skipping", appId)
+		}
+	}
+}
 
-				// Increment downgrade counter for apps with no runtime, use a higher increment to trigger faster downgrade
-				c.lastReconfigsMu.Lock()
-				c.consecutiveDowngradeEligible[app.Id] += 20
-				count := c.consecutiveDowngradeEligible[app.Id]
-				c.lastReconfigsMu.Unlock()
+func (c *latencyController) queryMetrics(query metricQuery) metricQueryResult {
+	var queryFunc MetricQueryFunc
+	switch query.metricType {
+	case MetricTypeAverage:
+		queryFunc = c.metrics.QueryAverageAppRuntimes
+	default:
+		queryFunc = c.metrics.Query95thPercentileAppRuntimes
+	}
+	runtimes, traceCounts, err := queryFunc(query.timeRange)
+	return metricQueryResult{runtimes: runtimes, traceCounts: traceCounts, err: err}
+}
 
-				if count < minConsecutiveDowngrade {
-					// log.Printf("App %s has no runtime (%d/%d consecutive intervals)", app.Id, count, minConsecutiveDowngrade)
-					continue
-				}
+// evaluateApp asks the app's reconfiguration policy for a decision and requests the resulting transition from the arbiter
+func (c *latencyController) evaluateApp(app *FunctionApp, settings ControllerSettings, signals appSignals) {
+	if app.LatencyLimit <= 0 {
+		return
+	}
 
-				c.lastReconfigsMu.Lock()
-				c.reconfigStartTimes[app.Id] = time.Now()
-				c.consecutiveDowngradeEligible[app.Id] = 0
-				c.lastReconfigsMu.Unlock()
+	policy, err := GetReconfigurationPolicy(app.ControllerPolicy)
+	if err != nil {
+		log.Printf("Error selecting reconfiguration policy for app %s: %v", app.Id, err)
+		return
+	}
 
-				log.Printf("No runtime reported for app %s for %d consecutive intervals, downgrading to minimal layout.", app.Id, count)
-				nextLayoutKey, err := c.handleLayoutChange(app, layoutDowngradePath, false)
-				if err != nil {
-					log.Printf("Error downgrading app %s to minimal layout: %v", app.Id, err)
-					continue
-				}
-				if nextLayoutKey != "" {
-					log.Printf("App %s transitioned to layout %s due to missing runtimes. Deploying...", app.Id, nextLayoutKey)
-					c.lastReconfigsMu.Lock()
-					c.lastReconfigs[app.Id] = time.Now()
-					c.lastReconfigsMu.Unlock()
-				}
-			}
+	now := c.clock.Now()
+	c.historyMu.Lock()
+	history := c.historyFor(app.Id)
+	defer c.persistStateOnChange(app.Id, history.counters())
+	if signals.hasMetric {
+		history.addSample(now, signals.metric)
+	}
+	if signals.hasArrivalRate && settings.forecastHorizon() > 0 {
+		if history.Forecast == nil {
+			history.Forecast = &RateForecast{}
+		}
+		history.Forecast.observe(now, signals.arrivalRate)
+	}
+	if signals.health.failing(settings) {
+		if history.ErrorSpikeStart.IsZero() {
+			history.ErrorSpikeStart = now
+		}
+		history.ConsecutiveErrorIntervals++
+	} else {
+		history.ErrorSpikeStart = time.Time{}
+		history.ConsecutiveErrorIntervals = 0
+	}
+	if !history.LastReconfig.IsZero() && now.Sub(history.LastReconfig) < settings.cooldown() {
+		c.historyMu.Unlock()
+		return
+	}
+	if now.Before(history.PostponedUntil) {
+		c.historyMu.Unlock()
+		return
+	}
+	decision := policy.Decide(PolicyInput{
+		AppId:                app.Id,
+		Metric:               signals.metric,
+		HasMetric:            signals.hasMetric,
+		TraceCount:           signals.traceCount,
+		LatencyLimit:         app.LatencyLimit,
+		ActiveLayoutKey:      app.ActiveLayoutKey,
+		History:              history,
+		DowngradeFactor:      value(settings.DowngradeFactor),
+		MinTraceCount:        value(settings.MinimalTraceCount),
+		Now:                  now,
+		BurnRates:            signals.burnRates,
+		SLOPeriod:            settings.sloPeriod(),
+		SLODowngradeBurnRate: value(settings.SLODowngradeBurnRate),
+		RateCoverage:         signals.coverage,
+	})
+	policyName := policy.Name()
+	if settings.forecastHorizon() > 0 {
+		var forecasted bool
+		decision, forecasted = app.forecastDecision(decision, history.Forecast, settings.forecastHorizon())
+		if forecasted {
+			policyName = ForecastPolicy
 		}
 	}
+	decision, scheduled := app.scheduleDecision(decision, history, now)
+	if scheduled {
+		policyName = SchedulePolicy
+	}
+	decision, failing := app.healthDecision(decision, signals.health, history, settings, now)
+	if failing {
+		policyName = HealthPolicy
+	}
+	c.historyMu.Unlock()
+
+	var handler func(*FunctionApp, string, *ReconfigurationRecord) (string, error)
+	var step int
+	switch decision.Action {
+	case ActionUpgrade:
+		handler = c.handleLatencyViolation
+		step = 1
+	case ActionDowngrade:
+		handler = c.handleLayoutDowngrade
+		step = -1
+	case ActionRollback:
+		step = -1
+		if app.layoutLevel(decision.TargetLayoutKey) > app.layoutLevel(app.ActiveLayoutKey) {
+			step = 1
+		}
+		handler = func(app *FunctionApp, targetKey string, record *ReconfigurationRecord) (string, error) {
+			return c.handleLayoutChange(app, step, targetKey, step > 0, record)
+		}
+	default:
+		return
+	}
+	dryRun := settings.dryRun()
+	if dryRun {
+		handler = func(app *FunctionApp, targetKey string, record *ReconfigurationRecord) (string, error) {
+			return c.simulateLayoutChange(app, step, targetKey, record)
+		}
+	}
+
+	record := &ReconfigurationRecord{
+		Id:            uuid.New(),
+		FunctionAppId: app.Id,
+		Action:        decision.Action,
+		Policy:        policyName,
+		Reason:        decision.Reason,
+		TriggerMetric: signals.metric,
+		MetricType:    settings.MetricType,
+		TraceCount:    signals.traceCount,
+		FromLayoutKey: app.ActiveLayoutKey,
+		Outcome:       ReconfigurationInProgress,
+	}
+	if signals.path != "" {
+		record.Reason = fmt.Sprintf("%s (path to %s, scaled to the app's latency limit)", record.Reason, signals.path)
+	}
+
+	t := &pendingTransition{
+		app:        app,
+		decision:   decision,
+		policyName: policyName,
+		record:     record,
+		handler:    handler,
+		dryRun:     dryRun,
+		severity:   transitionSeverity(app, signals),
+		queuedAt:   now,
+	}
+	if dryRun {
+		// nothing is deployed, so simulated transitions do not compete for the transition slots
+		c.executeTransition(t)
+		return
+	}
+	c.transitions.request(t)
+}
+
+// dispatchTransitions starts the pending transitions the arbiter lets through, apps are the currently registered apps
+func (c *latencyController) dispatchTransitions(apps []*FunctionApp) {
+	for _, t := range c.transitions.next(c.clock.Now(), apps) {
+		c.executeTransition(t)
+	}
+}
+
+// executeTransition carries out a transition decided by evaluateApp
+func (c *latencyController) executeTransition(t *pendingTransition) {
+	app, decision, record, policyName := t.app, t.decision, t.record, t.policyName
+	defer c.persistState(app.Id)
+
+	if !t.dryRun {
+		// the app may have been edited while the transition waited for a slot, it is decided and saved against the current one
+		current, err := c.composer.GetFunctionApp(app.Id)
+		if err != nil || current == nil || current.ActiveLayoutKey != record.FromLayoutKey {
+			log.Printf("Dropping %s of app %s, the app changed while the transition was waiting: %v", decision.Action, app.Id, err)
+			c.transitions.finish(record.Id)
+			return
+		}
+		app = current
+	}
+
+	now := c.clock.Now()
+	record.StartTime = now
+	nextLayoutKey, err := t.handler(app, decision.TargetLayoutKey, record)
+	if errors.Is(err, errNodeOvercommit) {
+		log.Printf("Postponing reconfiguration of app %s: %v", app.Id, err)
+		record.Outcome = ReconfigurationPostponed
+		record.Error = err.Error()
+		record.EndTime = c.clock.Now()
+		c.saveReconfiguration(record)
+		c.transitions.finish(record.Id)
+		c.historyMu.Lock()
+		c.historyFor(app.Id).PostponedUntil = now.Add(postponeRetryInterval)
+		c.historyMu.Unlock()
+		return
+	}
+	if err != nil {
+		log.Printf("Error handling reconfiguration for app %s: %v", app.Id, err)
+		c.finishReconfiguration(record, err)
+		return
+	}
+	if nextLayoutKey == "" {
+		record.Outcome = ReconfigurationSkipped
+		record.EndTime = c.clock.Now()
+		c.saveReconfiguration(record)
+		c.transitions.finish(record.Id)
+		return
+	}
+	if t.dryRun {
+		log.Printf("[DRY RUN] App %s would transition to layout %s (%s policy: %s)", app.Id, nextLayoutKey, policyName, decision.Reason)
+	} else {
+		log.Printf("App %s transitioned to layout %s (%s policy: %s). Deploying...", app.Id, nextLayoutKey, policyName, decision.Reason)
+	}
+
+	c.historyMu.Lock()
+	history := c.historyFor(app.Id)
+	history.LastReconfig = c.clock.Now()
+	// samples observed on the previous layout say nothing about the new one
+	history.Samples = nil
+	history.PreviousLayoutKey = record.FromLayoutKey
+	if decision.Action == ActionRollback || record.Outcome == ReconfigurationRolledBack {
+		// the layout rolled back from is known to fail, it is not a rollback target. A synchronous transition
+		// which did not become ready has already reverted the app, so there is nothing to roll back to either
+		history.PreviousLayoutKey = ""
+	}
+	c.historyMu.Unlock()
+
+	log.Printf("Reconfiguration in progress for app %s, applying cooldown period", app.Id)
+}
+
+// simulateLayoutChange records the layout change handleLayoutChange would make without touching the app or its deployments.
+// The target becomes the app's shadow layout, so the following decisions continue from where the controller would be.
+func (c *latencyController) simulateLayoutChange(app *FunctionApp, step int, targetKey string, record *ReconfigurationRecord) (string, error) {
+	nextLayoutKey := targetKey
+	if nextLayoutKey == "" {
+		nextLayoutKey = app.adjacentLayoutKey(step)
+	}
+	if nextLayoutKey == "" {
+		return "", nil
+	}
+	nextLayout, ok := app.LayoutCandidates[nextLayoutKey]
+	if !ok {
+		return "", fmt.Errorf("no layout candidate found for key %s in app %s", nextLayoutKey, app.Id)
+	}
+	nextLayout, err := c.placeLayout(app, nextLayout, step > 0)
+	if err != nil {
+		return "", err
+	}
+
+	record.ToLayoutKey = nextLayoutKey
+	record.Layout = nextLayout
+	record.Outcome = ReconfigurationDryRun
+	record.EndTime = c.clock.Now()
+	c.saveReconfiguration(record)
+
+	c.historyMu.Lock()
+	c.shadowLayoutKeys[app.Id] = nextLayoutKey
+	c.historyMu.Unlock()
+	return nextLayoutKey, nil
+}
+
+// shadowView returns the app as the controller sees it in dry-run mode, with the shadow layout as the active one
+func (c *latencyController) shadowView(app *FunctionApp) *FunctionApp {
+	c.historyMu.Lock()
+	defer c.historyMu.Unlock()
+	key, ok := c.shadowLayoutKeys[app.Id]
+	if !ok {
+		return app
+	}
+	if _, exists := app.LayoutCandidates[key]; !exists {
+		// candidates were regenerated since the decision was simulated
+		delete(c.shadowLayoutKeys, app.Id)
+		return app
+	}
+	shadow := *app
+	shadow.ActiveLayoutKey = key
+	return &shadow
+}
+
+func (c *latencyController) clearShadowLayout(appId string) {
+	c.historyMu.Lock()
+	_, ok := c.shadowLayoutKeys[appId]
+	delete(c.shadowLayoutKeys, appId)
+	c.historyMu.Unlock()
+	if ok {
+		c.persistState(appId)
+	}
+}
+
+// finishReconfiguration stores the final state of a reconfiguration, err is the error which interrupted it, if any
+func (c *latencyController) finishReconfiguration(record *ReconfigurationRecord, err error) {
+	if record.EndTime.IsZero() {
+		record.EndTime = c.clock.Now()
+	}
+	c.transitions.finish(record.Id)
+	record.Outcome = ReconfigurationSucceeded
+	if err != nil {
+		record.Outcome = ReconfigurationFailed
+		if errors.Is(err, errTransitionRolledBack) {
+			record.Outcome = ReconfigurationRolledBack
+		}
+		record.Error = err.Error()
+	}
+	c.saveReconfiguration(record)
+	if err != nil {
+		c.notifyReconfiguration(EventReconfigFailed, record)
+	} else {
+		c.notifyReconfiguration(EventReconfigComplete, record)
+	}
+}
+
+// startReconfiguration stores a reconfiguration whose deployment is about to start
+func (c *latencyController) startReconfiguration(record *ReconfigurationRecord) {
+	c.saveReconfiguration(record)
+	c.notifyReconfiguration(EventReconfigStarted, record)
+}
+
+func (c *latencyController) saveReconfiguration(record *ReconfigurationRecord) {
+	if err := c.reconfigRepo.Save(record); err != nil {
+		log.Printf("Error saving reconfiguration record %s for app %s: %v", record.Id, record.FunctionAppId, err)
+	}
+}
+
+// SetActiveLayout deploys one of the app's layout candidates regardless of the controller's decisions.
+// The controller may move the app away from it later, unless it is paused for the app.
+func (c *latencyController) SetActiveLayout(appId, layoutKey string) error {
+	app, err := c.composer.GetFunctionApp(appId)
+	if err != nil {
+		return err
+	}
+	if app == nil {
+		return fmt.Errorf("function app %s not found", appId)
+	}
+	layout, ok := app.LayoutCandidates[layoutKey]
+	if !ok {
+		return fmt.Errorf("no layout candidate found for key %s in app %s", layoutKey, appId)
+	}
+	// placement only moves the operator's choice away from loaded nodes if possible, it is only refused if it does not fit at all
+	layout, _ = c.placeLayout(app, layout, false)
+	if err := c.checkCapacity(app, layout, nil); err != nil {
+		return err
+	}
+
+	now := c.clock.Now()
+	record := &ReconfigurationRecord{
+		Id:            uuid.New(),
+		FunctionAppId: app.Id,
+		Action:        ActionManual,
+		Reason:        "layout set manually",
+		FromLayoutKey: app.ActiveLayoutKey,
+		ToLayoutKey:   layoutKey,
+		Layout:        layout,
+		StartTime:     now,
+		Outcome:       ReconfigurationInProgress,
+	}
+	isUpgrade := app.layoutLevel(layoutKey) > app.layoutLevel(app.ActiveLayoutKey)
+	if !c.transitions.occupy(record.Id, app.Id) {
+		return ErrReconfigurationInProgress
+	}
+
+	app.ActiveLayoutKey = layoutKey
+	if err := c.composer.functionAppRepo.Save(app); err != nil {
+		c.transitions.finish(record.Id)
+		return fmt.Errorf("failed to update active layout key for app %s: %w", app.Id, err)
+	}
+	c.startReconfiguration(record)
+
+	c.historyMu.Lock()
+	history := c.historyFor(app.Id)
+	history.LastReconfig = now
+	history.Samples = nil
+	history.PreviousLayoutKey = record.FromLayoutKey
+	delete(c.shadowLayoutKeys, app.Id)
+	c.historyMu.Unlock()
+	c.persistState(app.Id)
+
+	c.async(func() {
+		err := c.applyLayout(app.Id, layout, isUpgrade, record)
+		if err != nil {
+			log.Printf("Failed to deploy manually selected layout %s for app %s: %v", layoutKey, app.Id, err)
+		}
+		c.finishReconfiguration(record, err)
+	})
+
+	log.Printf("App %s manually set to layout %s", app.Id, layoutKey)
+	return nil
+}
+
+// SetPaused stops or resumes the controller for a single app. Resuming discards the counters and samples
+// collected before the pause, so decisions are only based on what happens after it.
+func (c *latencyController) SetPaused(appId string, paused bool) error {
+	app, err := c.composer.GetFunctionApp(appId)
+	if err != nil {
+		return err
+	}
+	if app == nil {
+		return fmt.Errorf("function app %s not found", appId)
+	}
+
+	app.ControllerPaused = paused
+	if err := c.composer.functionAppRepo.Save(app); err != nil {
+		return fmt.Errorf("failed to update controller state of app %s: %w", app.Id, err)
+	}
+
+	if !paused {
+		c.historyMu.Lock()
+		history := c.historyFor(app.Id)
+		history.ConsecutiveUpgradeEligible = 0
+		history.ConsecutiveDowngradeEligible = 0
+		history.Samples = nil
+		c.historyMu.Unlock()
+		c.persistState(app.Id)
+	}
+
+	log.Printf("Controller paused=%t for app %s", paused, app.Id)
+	return nil
+}
+
+func (c *latencyController) GetReconfigurations(appId string, from, to time.Time) ([]*ReconfigurationRecord, error) {
+	return c.reconfigRepo.GetByFunctionAppID(appId, from, to)
+}
+
+func (c *latencyController) historyFor(appId string) *PolicyHistory {
+	h, ok := c.history[appId]
+	if !ok {
+		h = &PolicyHistory{}
+		c.history[appId] = h
+	}
+	return h
 }
 
 func (c *latencyController) RegisterFunctionApp(creationData FunctionAppCreationData) (*FunctionApp, error) {
+	rateLevels, err := NormalizeRateLevels(creationData.RateLevels)
+	if err != nil {
+		return nil, err
+	}
+
 	app, err := c.composer.CreateFunctionApp(creationData)
 	if err != nil {
 		log.Printf("Error creating function app: %v", err)
 		return nil, err
 	}
 
-	candidates, err := c.scenarioManager.GenerateLayoutCandidates(
+	candidates, ladder, err := c.scenarioManager.GenerateLayoutCandidates(
 		app.Components,
 		app.Links,
+		creationData.RateLevels,
 		app.LatencyLimit,
-		c.availableNodeMemoryGb*1024)
+		app.PathLatencyLimits,
+		c.availableNodeMemoryGb*1024,
+		c.capacity.freeMemory(app.Id))
 	if err != nil {
 		log.Printf("Error generating layout candidates for app %s: %v", app.Id, err)
 		return nil, err
 	}
 	log.Printf("Generated layout candidates for app: %s: %v", app.Id, candidates)
+	app.RateLevels = rateLevels
 	app.LayoutCandidates = candidates
-	// Default to the minimal layout initially
-	app.ActiveLayoutKey = LayoutKeyMin
+	app.LayoutLadder = ladder
+	// Default to the lowest rate level initially
+	app.ActiveLayoutKey = ladder[0].Key
 	err = c.composer.functionAppRepo.Save(app)
 	if err != nil {
 		log.Printf("Error saving function app %s: %v", app.Id, err)
 		return nil, err
 	}
 
+	if err := c.ensureCompositions(app); err != nil {
+		return nil, err
+	}
+	// compositions reusing cached images are built already, the others record the version once their builds finished
+	c.ensureVersion(app.Id)
+
+	appId, layout := app.Id, c.initialLayout(app)
+	c.async(func() {
+		err := c.deployLayout(appId, layout, false, reuseDeployments, nil, nil)
+		if err != nil {
+			log.Printf("Error deploying layout for app %s: %v", appId, err)
+			return
+		}
+		log.Printf("Successfully deployed function app with layout %s: %v", appId, layout)
+	})
+
+	return app, nil
+}
+
+func (c *latencyController) initialLayout(app *FunctionApp) Layout {
+	layout, _ := c.placeLayout(app, app.LayoutCandidates[app.ActiveLayoutKey], false)
+	return layout
+}
+
+// ensureCompositions adds a function composition for every component group used by the app's layout candidates
+// which does not have one yet. Compositions of the active layout are added first, so they get built first.
+func (c *latencyController) ensureCompositions(app *FunctionApp) error {
 	createdCompositionKeys := make(map[string]bool)
+	for _, fc := range app.Compositions {
+		createdCompositionKeys[componentsKey(fc.Components)] = true
+	}
 
-	// Sort layout candidate keys, putting LayoutKeyMin first, so it gets built first
+	// Sort layout candidate keys, putting the active layout first, so it gets built first
 	var keys []string
 	for k := range app.LayoutCandidates {
 		keys = append(keys, k)
@@ -323,36 +779,32 @@ func (c *latencyController) RegisterFunctionApp(creationData FunctionAppCreation
 			_, err := c.composer.AddFunctionComposition(app.Id, componentNames, "")
 			if err != nil {
 				log.Printf("Error adding function composition for app %s: %v", app.Id, err)
-				return nil, err
+				return err
 			}
 			createdCompositionKeys[fcKey] = true
 		}
 	}
-
-	go func(appId string, layout Layout) {
-		err = c.deployLayout(appId, layout, false, reuseDeployments)
-		if err != nil {
-			log.Printf("Error deploying layout for app %s: %v", appId, err)
-			return
-		}
-		log.Printf("Successfully deployed function app with layout %s: %v", appId, layout)
-	}(app.Id, app.LayoutCandidates[app.ActiveLayoutKey])
-
-	return app, nil
+	return nil
 }
 
-func (c *latencyController) handleLatencyViolation(app *FunctionApp) (string, error) {
+func (c *latencyController) handleLatencyViolation(app *FunctionApp, targetKey string, record *ReconfigurationRecord) (string, error) {
 	//log.Printf("App %s exceeds latency threshold (%d ms). Triggering reconfiguration.", app.Id, app.LatencyLimit)
-	return c.handleLayoutChange(app, layoutUpgradePath, true)
+	return c.handleLayoutChange(app, 1, targetKey, true, record)
 }
 
-func (c *latencyController) handleLayoutDowngrade(app *FunctionApp) (string, error) {
+func (c *latencyController) handleLayoutDowngrade(app *FunctionApp, targetKey string, record *ReconfigurationRecord) (string, error) {
 	//log.Printf("App %s is below latency threshold. Considering layout downgrade.", app.Id)
-	return c.handleLayoutChange(app, layoutDowngradePath, false)
+	return c.handleLayoutChange(app, -1, targetKey, false, record)
 }
 
-func (c *latencyController) handleLayoutChange(app *FunctionApp, path map[string]string, isUpgrade bool) (string, error) {
-	nextLayoutKey := path[app.ActiveLayoutKey]
+// handleLayoutChange moves the app step levels along its layout ladder, one level at a time is the norm.
+// A non-empty targetKey moves the app straight to that layout instead.
+// The record is saved once the new layout is persisted and completed by the deployment goroutine.
+func (c *latencyController) handleLayoutChange(app *FunctionApp, step int, targetKey string, isUpgrade bool, record *ReconfigurationRecord) (string, error) {
+	nextLayoutKey := targetKey
+	if nextLayoutKey == "" {
+		nextLayoutKey = app.adjacentLayoutKey(step)
+	}
 	if nextLayoutKey == "" {
 		// No further layout candidates available
 		return "", nil
@@ -362,29 +814,49 @@ func (c *latencyController) handleLayoutChange(app *FunctionApp, path map[string
 	if !ok {
 		return "", fmt.Errorf("no layout candidate found for key %s in app %s", nextLayoutKey, app.Id)
 	}
+	nextLayout, err := c.placeLayout(app, nextLayout, isUpgrade)
+	if err != nil {
+		return "", err
+	}
+	if err := c.checkCapacity(app, nextLayout, nil); err != nil {
+		return "", err
+	}
 
+	record.ToLayoutKey = nextLayoutKey
+	record.Layout = nextLayout
 	app.ActiveLayoutKey = nextLayoutKey
 	if err := c.composer.functionAppRepo.Save(app); err != nil {
 		return "", fmt.Errorf("failed to update active layout key for app %s: %w", app.Id, err)
 	}
+	c.startReconfiguration(record)
 
-	go func() {
-		if err := c.deployLayout(app.Id, nextLayout, isUpgrade, reuseDeployments); err != nil {
+	c.async(func() {
+		err := c.applyLayout(app.Id, nextLayout, isUpgrade, record)
+		if err != nil {
 			log.Printf("Failed to deploy new layout for app %s: %v", app.Id, err)
 		}
-	}()
+		c.finishReconfiguration(record, err)
+	})
 
 	log.Printf("App %s successfully transitioned to layout %s", app.Id, nextLayoutKey)
 	return nextLayoutKey, nil
 }
 
-func (c *latencyController) deployLayout(appId string, layout Layout, isUpgrade bool, reuseFunctions bool) error {
+// deployLayout applies the layout to the cluster, record is the reconfiguration being carried out, nil for the initial deployment.
+// Traffic is only switched once every new deployment is ready. If any step fails, the new deployments are discarded
+// and the routing tables of the reused ones are restored, so the previous layout keeps serving.
+// With reuseFunctions, deployments of the same composition on the same node are kept, except for the compositions
+// in replaced, which get new deployments next to their current ones.
+func (c *latencyController) deployLayout(appId string, layout Layout, isUpgrade bool, reuseFunctions bool, replaced map[string]bool, record *ReconfigurationRecord) error {
 	log.Printf("Deploying layout for app %s: %v", appId, layout)
 
 	app, err := c.composer.GetFunctionApp(appId)
 	if err != nil {
 		return err
 	}
+	if err := c.checkCapacity(app, layout, replaced); err != nil {
+		return err
+	}
 
 	// Build a fast lookup: compositionKey -> FunctionComposition
 	fcByKey := make(map[string]*FunctionComposition)
@@ -414,9 +886,10 @@ func (c *latencyController) deployLayout(appId string, layout Layout, isUpgrade
 	compToDepID := make(map[string]string) // component -> deployment id
 
 	type depResult struct {
-		key string
-		dep *Deployment
-		err error
+		key     string
+		dep     *Deployment
+		created bool // the deployment was created for this layout, it is discarded if the transition fails
+		err     error
 	}
 
 	resultChan := make(chan depResult, len(layout))
@@ -437,16 +910,16 @@ func (c *latencyController) deployLayout(appId string, layout Layout, isUpgrade
 			fcKey := componentsKey(componentNames)
 			matchedFc, ok := fcByKey[fcKey]
 			if !ok {
-				resultChan <- depResult{"", nil, fmt.Errorf("no matching function composition for components: %v", components)}
+				resultChan <- depResult{err: fmt.Errorf("no matching function composition for components: %v", components)}
 				return
 			}
 
 			depKey := matchedFc.Id + "@" + node
-			if reuseFunctions {
+			if reuseFunctions && !replaced[matchedFc.Id] {
 				if dep, ok := activeDepsByKey[depKey]; ok {
 					// if a deployment already exists for this fc+node, reuse it
 					log.Printf("Reusing existing deployment %s for node %s", dep.Id, node)
-					resultChan <- depResult{depKey, dep, nil}
+					resultChan <- depResult{key: depKey, dep: dep}
 					return
 				}
 			}
@@ -472,27 +945,43 @@ func (c *latencyController) deployLayout(appId string, layout Layout, isUpgrade
 			}
 			newDep, depChan, err := c.composer.CreateFcDeployment(matchedFc.Id, c.deployNamespace, node, emptyRT, scale, resources)
 			if err != nil {
-				resultChan <- depResult{"", nil, fmt.Errorf("failed to create deployment for fc %s on node %s: %w", matchedFc.Id, node, err)}
+				resultChan <- depResult{err: fmt.Errorf("failed to create deployment for fc %s on node %s: %w", matchedFc.Id, node, err)}
 				return
 			}
 
 			r := <-depChan
 			if r.Err != nil {
-				resultChan <- depResult{"", nil, fmt.Errorf("deployment task failed for fc %s on node %s: %w", matchedFc.Id, node, r.Err)}
+				resultChan <- depResult{depKey, newDep, true, fmt.Errorf("deployment task failed for fc %s on node %s: %w", matchedFc.Id, node, r.Err)}
+				return
+			}
+			if err := c.waitReady(newDep, r); err != nil {
+				resultChan <- depResult{depKey, newDep, true, err}
 				return
 			}
 
 			matchedFc.Deployments = append(matchedFc.Deployments, newDep) // add to fc's deployments
 			log.Printf("Created new deployment %s for fc %s on node %s", newDep.Id, matchedFc.Id, node)
-			resultChan <- depResult{depKey, newDep, nil}
+			resultChan <- depResult{key: depKey, dep: newDep, created: true}
 		}(node, compositionInfo)
 	}
 
-	// collect results and update the active deployment mapping
+	// collect results and update the active deployment mapping, all results are awaited so a failed
+	// transition knows every deployment it created
+	var createdDeps []*Deployment
+	var deployErr error
 	for i := 0; i < len(layout); i++ {
 		res := <-resultChan
+		if res.created {
+			createdDeps = append(createdDeps, res.dep)
+		}
 		if res.err != nil {
-			return res.err
+			if deployErr == nil {
+				deployErr = res.err
+			}
+			continue
+		}
+		if deployErr != nil {
+			continue
 		}
 		activeDepsByKey[res.key] = res.dep
 		activeDepIDs[res.dep.Id] = true
@@ -504,6 +993,17 @@ func (c *latencyController) deployLayout(appId string, layout Layout, isUpgrade
 			compToDepID[comp] = res.dep.Id
 		}
 	}
+	if deployErr != nil {
+		c.discardDeployments(createdDeps)
+		return deployErr
+	}
+
+	// routing tables of reused deployments before the switch, restored if the transition fails
+	previousTables := make(map[string]RoutingTable)
+	rollback := func() {
+		c.restoreRoutingTables(previousTables)
+		c.discardDeployments(createdDeps)
+	}
 
 	// build and apply routing tables
 	referencedDepIDs := make(map[string]bool)
@@ -557,8 +1057,12 @@ func (c *latencyController) deployLayout(appId string, layout Layout, isUpgrade
 			rt[comp] = routes
 		}
 
+		if !slices.Contains(createdDeps, dep) {
+			previousTables[dep.Id] = dep.RoutingTable
+		}
 		err = c.composer.SetRoutingTable(dep.Id, rt)
 		if err != nil {
+			rollback()
 			return fmt.Errorf("failed to set routing table for deployment %s: %w", dep.Id, err)
 		}
 		log.Printf("Set routing table for deployment %s: %v", dep.Id, rt)
@@ -568,23 +1072,24 @@ func (c *latencyController) deployLayout(appId string, layout Layout, isUpgrade
 	firstComponent := app.Components[0].Name
 	firstDepID, ok := compToDepID[firstComponent]
 	if !ok {
+		rollback()
 		return fmt.Errorf("no deployment found for first component %s", firstComponent)
 	}
 	if err := c.composer.UpdateDNSRecord(app.Id, c.deployNamespace, firstDepID); err != nil {
+		rollback()
 		return fmt.Errorf("failed to update DNS record for app %s: %w", app.Id, err)
 	}
 	log.Printf("Updated DNS record for app %s to deployment %s (first component: %s)", app.Id, firstDepID, firstComponent)
 
 	// Measure and log reconfiguration end-to-end latency
-	c.lastReconfigsMu.Lock()
-	startTime, ok := c.reconfigStartTimes[appId]
-	if ok {
-		duration := time.Since(startTime)
+	if record != nil {
+		record.EndTime = c.clock.Now()
+		duration := record.EndTime.Sub(record.StartTime)
 		durationMs := float64(duration) / float64(time.Millisecond)
 		event := ReconfigEvent{
-			EventType:  "RECONFIG_COMPLETE",
+			EventType:  string(EventReconfigComplete),
 			AppID:      appId,
-			EventTime:  startTime.UnixMilli(),
+			EventTime:  record.StartTime.UnixMilli(),
 			DurationMs: durationMs,
 		}
 
@@ -594,16 +1099,10 @@ func (c *latencyController) deployLayout(appId string, layout Layout, isUpgrade
 		} else {
 			log.Printf("[RECONFIG_EVENT] %s", string(jsonBytes))
 		}
-
-		delete(c.reconfigStartTimes, appId)
-	} else {
-		log.Printf("Warning: Missing start time for app %s in reconfigStartTime map.", appId)
 	}
-	c.lastReconfigsMu.Unlock()
 
 	// cleanup: remove unused deployments asynchronously
-	go func() {
-		time.Sleep(10 * time.Second)
+	c.after(deploymentCleanupDelay, func() {
 		for _, fc := range app.Compositions {
 			var kept []*Deployment
 			for _, d := range fc.Deployments {
@@ -628,7 +1127,7 @@ func (c *latencyController) deployLayout(appId string, layout Layout, isUpgrade
 			}
 			fc.Deployments = kept
 		}
-	}()
+	})
 
 	return nil
 }
@@ -0,0 +1,120 @@
+package core
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"time"
+)
+
+// statePersistInterval is how often the samples and forecast of an app are saved while its counters do not change
+const statePersistInterval = time.Minute
+
+// ControllerState is the per-app controller state which has to survive restarts of the configurator
+type ControllerState struct {
+	FunctionAppId   string        `json:"function_app_id"`
+	History         PolicyHistory `json:"history"`
+	ShadowLayoutKey string        `json:"shadow_layout_key"`
+	UpdatedAt       time.Time     `json:"updated_at"`
+}
+
+// restoreState loads the persisted policy histories and shadow layouts, so cooldowns and counters continue where they stopped
+func (c *latencyController) restoreState() {
+	states, err := c.stateRepo.GetAll()
+	if err != nil {
+		log.Printf("Error restoring controller state: %v", err)
+		return
+	}
+
+	c.historyMu.Lock()
+	defer c.historyMu.Unlock()
+	for _, state := range states {
+		history := state.History
+		c.history[state.FunctionAppId] = &history
+		if state.ShadowLayoutKey != "" {
+			c.shadowLayoutKeys[state.FunctionAppId] = state.ShadowLayoutKey
+		}
+	}
+	log.Printf("Restored controller state for %d apps", len(states))
+}
+
+func (c *latencyController) persistState(appId string) {
+	c.historyMu.Lock()
+	state := &ControllerState{
+		FunctionAppId:   appId,
+		ShadowLayoutKey: c.shadowLayoutKeys[appId],
+		UpdatedAt:       c.clock.Now(),
+	}
+	if h, ok := c.history[appId]; ok {
+		state.History = *h
+		state.History.Samples = append([]MetricSample(nil), h.Samples...)
+	}
+	c.historyMu.Unlock()
+
+	if err := c.stateRepo.Save(state); err != nil {
+		log.Printf("Error persisting controller state for app %s: %v", appId, err)
+		return
+	}
+	c.historyMu.Lock()
+	c.persistedAt[appId] = state.UpdatedAt
+	c.historyMu.Unlock()
+}
+
+// persistStateOnChange saves the controller state of the app if its counters changed from before, or if it was last
+// saved more than statePersistInterval ago. Samples are added on every tick, saving each of them is not worth the write.
+func (c *latencyController) persistStateOnChange(appId string, before PolicyHistory) {
+	c.historyMu.Lock()
+	changed := !reflect.DeepEqual(before, c.historyFor(appId).counters())
+	due := c.clock.Now().Sub(c.persistedAt[appId]) >= statePersistInterval
+	c.historyMu.Unlock()
+	if changed || due {
+		c.persistState(appId)
+	}
+}
+
+// recoverReconfigurations handles the reconfigurations which were in progress when the configurator stopped.
+// The active layout key is saved before deploying, so if it still points to the target layout the deployment is resumed,
+// otherwise the reconfiguration can not be continued and is marked as failed. Source updates and version rollbacks
+// depend on builds and images of the stopped process, so they are never resumed.
+func (c *latencyController) recoverReconfigurations() {
+	records, err := c.reconfigRepo.GetInProgress()
+	if err != nil {
+		log.Printf("Error querying in-progress reconfigurations: %v", err)
+		return
+	}
+
+	for _, record := range records {
+		app, err := c.composer.GetFunctionApp(record.FunctionAppId)
+		if err != nil || app == nil {
+			c.finishReconfiguration(record, fmt.Errorf("interrupted by restart, function app could not be loaded: %v", err))
+			continue
+		}
+		if record.Action == ActionSourceUpdate || record.Action == ActionVersionRollback {
+			c.finishReconfiguration(record, fmt.Errorf("interrupted by restart, a %s can not be resumed", record.Action))
+			continue
+		}
+		layout, ok := app.LayoutCandidates[record.ToLayoutKey]
+		if len(record.Layout) > 0 {
+			// the layout as it was placed onto the nodes
+			layout = record.Layout
+		}
+		if !ok || app.ActiveLayoutKey != record.ToLayoutKey {
+			c.finishReconfiguration(record, fmt.Errorf("interrupted by restart, layout %s is no longer the active layout", record.ToLayoutKey))
+			continue
+		}
+
+		if !c.transitions.occupy(record.Id, app.Id) {
+			c.finishReconfiguration(record, fmt.Errorf("interrupted by restart, another reconfiguration of the app is resumed"))
+			continue
+		}
+
+		log.Printf("Resuming interrupted reconfiguration %s of app %s to layout %s", record.Id, app.Id, record.ToLayoutKey)
+		c.async(func() {
+			err := c.applyLayout(app.Id, layout, record.Action == ActionUpgrade, record)
+			if err != nil {
+				log.Printf("Failed to resume reconfiguration %s of app %s: %v", record.Id, app.Id, err)
+			}
+			c.finishReconfiguration(record, err)
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package core
+
+import (
+	"lsf-configurator/pkg/uuid"
+	"slices"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventReconfigStarted  EventType = "RECONFIG_STARTED"
+	EventReconfigComplete EventType = "RECONFIG_COMPLETE"
+	EventReconfigFailed   EventType = "RECONFIG_FAILED" // the reconfiguration failed or was rolled back
+	EventBuildFailed      EventType = "BUILD_FAILED"
+	EventDeploymentFailed EventType = "DEPLOYMENT_FAILED"
+)
+
+// EventTypes lists every event the composer and the controller report
+var EventTypes = []EventType{EventReconfigStarted, EventReconfigComplete, EventReconfigFailed, EventBuildFailed, EventDeploymentFailed}
+
+// Event is reported to the EventNotifier, only the fields related to the event type are set
+type Event struct {
+	Id                    string                 `json:"id"`
+	Type                  EventType              `json:"type"`
+	AppId                 string                 `json:"app_id"`
+	Time                  time.Time              `json:"time"`
+	Reconfiguration       *ReconfigurationRecord `json:"reconfiguration,omitempty"`
+	FunctionCompositionId string                 `json:"function_composition_id,omitempty"`
+	DeploymentId          string                 `json:"deployment_id,omitempty"`
+	Error                 string                 `json:"error,omitempty"`
+}
+
+// SetEventNotifier makes the composer and the controllers using it report their events to notifier
+func (c *Composer) SetEventNotifier(notifier EventNotifier) {
+	c.events = notifier
+}
+
+func (c *Composer) notify(event Event) {
+	if c.events == nil {
+		return
+	}
+	event.Id = uuid.New()
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	c.events.Notify(event)
+}
+
+// notifyReconfiguration reports the current state of the reconfiguration, the record is copied as it keeps changing
+func (c *latencyController) notifyReconfiguration(eventType EventType, record *ReconfigurationRecord) {
+	snapshot := *record
+	c.composer.notify(Event{
+		Type:            eventType,
+		AppId:           record.FunctionAppId,
+		Time:            c.clock.Now(),
+		Reconfiguration: &snapshot,
+		Error:           record.Error,
+	})
+}
+
+// WebhookSubscription receives the events matching its filters as signed JSON POST requests
+type WebhookSubscription struct {
+	Id         string      `json:"id"`
+	URL        string      `json:"url"`
+	Secret     string      `json:"-"`           // key of the HMAC-SHA256 signature of the payloads, never returned by the API
+	EventTypes []EventType `json:"event_types"` // empty means every event type
+	AppIds     []string    `json:"app_ids"`     // empty means every app
+	CreatedAt  time.Time   `json:"created_at"`
+}
+
+// Matches reports whether the event passes the filters of the subscription
+func (s *WebhookSubscription) Matches(event Event) bool {
+	if len(s.EventTypes) > 0 && !slices.Contains(s.EventTypes, event.Type) {
+		return false
+	}
+	return len(s.AppIds) == 0 || slices.Contains(s.AppIds, event.AppId)
+}
+
+// WebhookDelivery is a single attempt to deliver an event to a subscription
+type WebhookDelivery struct {
+	Id             string    `json:"id"`
+	SubscriptionId string    `json:"subscription_id"`
+	EventId        string    `json:"event_id"`
+	EventType      EventType `json:"event_type"`
+	Attempt        int       `json:"attempt"`
+	Time           time.Time `json:"time"`
+	StatusCode     int       `json:"status_code"` // 0 if no response was received
+	Delivered      bool      `json:"delivered"`
+	Error          string    `json:"error,omitempty"`
+}
@@ -0,0 +1,134 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	forecastAlpha = 0.3 // smoothing factor of the rate
+	forecastBeta  = 0.1 // smoothing factor of the trend
+	// ForecastPolicy is recorded as the policy of reconfigurations triggered by the arrival rate forecast
+	ForecastPolicy = "forecast"
+)
+
+// RateForecast is the state of the Holt (double exponential smoothing) forecast of an app's request arrival rate
+type RateForecast struct {
+	Rate      float64   `json:"rate"`  // smoothed arrival rate in requests per second
+	Trend     float64   `json:"trend"` // change of the arrival rate per second
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// observe updates the forecast with the arrival rate measured at t
+func (f *RateForecast) observe(t time.Time, rate float64) {
+	if f.UpdatedAt.IsZero() {
+		f.Rate, f.Trend, f.UpdatedAt = rate, 0, t
+		return
+	}
+	dt := t.Sub(f.UpdatedAt).Seconds()
+	if dt <= 0 {
+		return
+	}
+	previous := f.Rate
+	f.Rate = forecastAlpha*rate + (1-forecastAlpha)*(f.Rate+f.Trend*dt)
+	f.Trend = forecastBeta*(f.Rate-previous)/dt + (1-forecastBeta)*f.Trend
+	f.UpdatedAt = t
+}
+
+// predict returns the arrival rate expected horizon after the last observation, never negative
+func (f *RateForecast) predict(horizon time.Duration) float64 {
+	return math.Max(0, f.Rate+f.Trend*horizon.Seconds())
+}
+
+// ingressRate returns the arrival rate the layout level was calculated for. Levels of ladders created
+// before ingress rates were recorded are derived from the invocation rate of the app's entry link.
+func (app *FunctionApp) ingressRate(level LayoutLevel) float64 {
+	if level.IngressRate > 0 || len(app.Links) == 0 {
+		return level.IngressRate
+	}
+	entry := sortLinksByCallGraphOrder(app.Links)[0]
+	return rateAtLevel(level.RateLevel)(entry.InvocationRate.Min, entry.InvocationRate.Max)
+}
+
+// coveringIngressLevel returns the position of the lowest ladder level calculated for at least rate, or the highest level if there is none
+func (app *FunctionApp) coveringIngressLevel(rate float64) int {
+	ladder := app.Ladder()
+	for i, level := range ladder {
+		if app.ingressRate(level) >= rate {
+			return i
+		}
+	}
+	return len(ladder) - 1
+}
+
+// forecastDecision adjusts the policy's decision to the forecast arrival rate: the app is moved straight to the level
+// covering the forecast when it is above the active one or the policy's target, and downgrades never go below the forecast
+func (app *FunctionApp) forecastDecision(decision PolicyDecision, forecast *RateForecast, horizon time.Duration) (PolicyDecision, bool) {
+	active := app.layoutLevel(app.ActiveLayoutKey)
+	if forecast == nil || horizon <= 0 || active < 0 {
+		return decision, false
+	}
+	predicted := forecast.predict(horizon)
+	target := app.coveringIngressLevel(predicted)
+	ladder := app.Ladder()
+
+	switch decision.Action {
+	case ActionUpgrade:
+		to := active + 1
+		if decision.TargetLayoutKey != "" {
+			to = app.layoutLevel(decision.TargetLayoutKey)
+		}
+		if target > to {
+			// the upgrade is due anyway, skip the levels the forecast would outgrow
+			decision.TargetLayoutKey = ladder[target].Key
+		}
+		return decision, false
+	case ActionDowngrade:
+		to := active - 1
+		if decision.TargetLayoutKey != "" {
+			to = app.layoutLevel(decision.TargetLayoutKey)
+		}
+		if target >= active {
+			return hold(), false
+		}
+		if target > to {
+			decision.TargetLayoutKey = ladder[target].Key
+		}
+		return decision, false
+	}
+	if target <= active {
+		return decision, false
+	}
+	return PolicyDecision{
+		Action:          ActionUpgrade,
+		Reason:          fmt.Sprintf("arrival rate forecast %.2f req/s in %s exceeds %.2f req/s covered by layout %s", predicted, horizon, app.ingressRate(ladder[active]), app.ActiveLayoutKey),
+		TargetLayoutKey: ladder[target].Key,
+	}, true
+}
+
+// dateMathWindow returns the length of a relative Elasticsearch time range such as now-5m
+func dateMathWindow(expr string) (time.Duration, error) {
+	value, ok := strings.CutPrefix(expr, "now-")
+	if !ok || len(value) < 2 {
+		return 0, fmt.Errorf("unsupported time range %s", expr)
+	}
+	units := map[byte]time.Duration{
+		's': time.Second,
+		'm': time.Minute,
+		'h': time.Hour,
+		'd': 24 * time.Hour,
+		'w': 7 * 24 * time.Hour,
+	}
+	unit, ok := units[value[len(value)-1]]
+	if !ok {
+		return 0, fmt.Errorf("unsupported time unit in time range %s", expr)
+	}
+	n, err := strconv.Atoi(value[:len(value)-1])
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid time range %s", expr)
+	}
+	return time.Duration(n) * unit, nil
+}
@@ -0,0 +1,70 @@
+package core
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	healthQueryTimeRange = "now-1m" // kept short, so the errors of a replaced layout leave the window within the cooldown
+	errorSpikeIntervals  = 10       // consecutive intervals with a high failure ratio before the controller reacts
+	errorRollbackWindow  = 10 * time.Minute
+	// HealthPolicy is recorded as the policy of reconfigurations triggered by failing requests
+	HealthPolicy = "health"
+)
+
+// AppHealth counts the traces of an app within a time range which failed or never finished
+type AppHealth struct {
+	TraceCount      int
+	ErrorCount      int // traces containing at least one failed span
+	IncompleteCount int // traces which started but never reached a boundary end span
+}
+
+// FailureRatio returns the share of traces which failed or did not finish
+func (h AppHealth) FailureRatio() float64 {
+	if h.TraceCount == 0 {
+		return 0
+	}
+	return min(1, float64(h.ErrorCount+h.IncompleteCount)/float64(h.TraceCount))
+}
+
+// failing reports whether the failure ratio of the app exceeds the threshold on enough traces to be meaningful
+func (h *AppHealth) failing(settings ControllerSettings) bool {
+	return h != nil && h.TraceCount >= value(settings.MinimalTraceCount) && h.FailureRatio() > value(settings.ErrorRateThreshold)
+}
+
+// healthDecision overrides the policy's decision while the app is failing. A sustained error spike which started shortly after
+// a reconfiguration rolls the app back to its previous layout, other spikes upgrade it, and downgrades are held back as long as requests fail.
+// Apps which were already failing before the reconfiguration are not rolled back, the previous layout failed as well.
+func (app *FunctionApp) healthDecision(decision PolicyDecision, health *AppHealth, history *PolicyHistory, settings ControllerSettings, now time.Time) (PolicyDecision, bool) {
+	if !health.failing(settings) {
+		return decision, false
+	}
+	if history.ConsecutiveErrorIntervals < errorSpikeIntervals {
+		if decision.Action == ActionDowngrade {
+			return hold(), false
+		}
+		return decision, false
+	}
+
+	ratio := health.FailureRatio()
+	if history.PreviousLayoutKey != "" && history.PreviousLayoutKey != app.ActiveLayoutKey && !history.LastReconfig.IsZero() &&
+		history.ErrorSpikeStart.After(history.LastReconfig) && history.ErrorSpikeStart.Sub(history.LastReconfig) <= errorRollbackWindow {
+		if _, ok := app.LayoutCandidates[history.PreviousLayoutKey]; ok {
+			history.ConsecutiveErrorIntervals = 0
+			return PolicyDecision{
+				Action:          ActionRollback,
+				Reason:          fmt.Sprintf("%.0f%% of requests failing since the move to layout %s", ratio*100, app.ActiveLayoutKey),
+				TargetLayoutKey: history.PreviousLayoutKey,
+			}, true
+		}
+	}
+	if decision.Action == ActionUpgrade {
+		return decision, false
+	}
+	history.ConsecutiveErrorIntervals = 0
+	return PolicyDecision{
+		Action: ActionUpgrade,
+		Reason: fmt.Sprintf("%.0f%% of requests failing for %d consecutive intervals", ratio*100, errorSpikeIntervals),
+	}, true
+}
@@ -8,6 +8,12 @@ type NodeMetrics struct {
 	Memory memory `json:"memory"`
 }
 
+// SpanStats summarises the spans of a single component
+type SpanStats struct {
+	AvgDurationMs float64
+	Count         int
+}
+
 type cpu struct {
 	Utilization float64 `json:"utilization"`
 }
@@ -3,6 +3,7 @@ package core
 import (
 	"encoding/json"
 	"mime/multipart"
+	"time"
 )
 
 type Component struct {
@@ -10,6 +11,17 @@ type Component struct {
 	Memory  int      `json:"memory"`  // in MB
 	Runtime int      `json:"runtime"` // The execution time of the component in milliseconds
 	Files   []string `json:"files"`   // List of files required by the component
+	// Observed is the latest profile measured by the profiler, Memory and Runtime always keep the declared values
+	Observed *ObservedProfile `json:"observed,omitempty"`
+	// Applied is the observed profile the current layout candidates were calculated from, nil means the declared values were used
+	Applied *ObservedProfile `json:"applied,omitempty"`
+}
+
+type ObservedProfile struct {
+	Runtime     int       `json:"runtime"` // average span duration in milliseconds, 0 if not observed
+	Memory      int       `json:"memory"`  // in MB, share of the pod memory usage, 0 if not observed
+	SampleCount int       `json:"sample_count"`
+	UpdatedAt   time.Time `json:"updated_at"`
 }
 
 type ComponentLink struct {
@@ -35,7 +47,33 @@ type FunctionApp struct {
 	SourcePath       string                 `json:"source_path"`
 	LatencyLimit     int                    `json:"latency_limit"`     // in milliseconds
 	LayoutCandidates map[string]Layout      `json:"layout_candidates"` // Key: LayoutKey, Value: Layout
+	LayoutLadder     []LayoutLevel          `json:"layout_ladder"`     // Layout keys ordered from the lowest to the highest rate level
+	RateLevels       []float64              `json:"rate_levels"`       // Rate levels the layout candidates were requested for
 	ActiveLayoutKey  string                 `json:"active_layout_key"`
+	ControllerPolicy string                 `json:"controller_policy"` // Name of the ReconfigurationPolicy, empty means DefaultPolicy
+	// Overrides of the global controller settings, nil means the app uses the defaults
+	ControllerSettings *ControllerSettings `json:"controller_settings,omitempty"`
+	ControllerPaused   bool                `json:"controller_paused"` // the controller leaves paused apps on their current layout
+	Priority           int                 `json:"priority"`          // apps with a higher priority are upgraded first when transitions have to wait
+	Schedule           []ScheduleEntry     `json:"schedule"`          // layouts the app is kept at or above for known traffic patterns
+	// Latency limits in milliseconds of the paths from the entry component to individual end components
+	PathLatencyLimits map[string]int `json:"path_latency_limits"`
+	// SourceRevision is the source revision the compositions are built from, 0 is the code uploaded with the app
+	SourceRevision int `json:"source_revision"`
+	Version        int `json:"version"` // the AppVersion the app is running, 0 before the first version is recorded
+}
+
+// AppVersion is a release of an app, kept so the app can be rolled back to it without building its images again
+type AppVersion struct {
+	Id             string            `json:"id"`
+	FunctionAppId  string            `json:"function_app_id"`
+	Version        int               `json:"version"`         // numbered from 1 in the order the versions were recorded
+	SourceRevision int               `json:"source_revision"` // source snapshot the images were built from
+	Components     []Component       `json:"components"`
+	Links          []ComponentLink   `json:"links"`
+	Files          []string          `json:"files"`
+	Images         map[string]string `json:"images"` // function composition id -> image
+	CreatedAt      time.Time         `json:"created_at"`
 }
 
 type BuildStatus string
@@ -74,6 +112,9 @@ type Deployment struct {
 	Status                DeploymentStatus `json:"status"`
 	Scale                 Scale            `json:"scale"`
 	Resources             Resources        `json:"resources"`
+	Image                 string           `json:"image"` // image the deployment runs, set when it is deployed
+	// StatusReason explains an error status, like the condition knative reported for a service which did not become ready
+	StatusReason string `json:"status_reason,omitempty"`
 }
 
 type Scale struct {
@@ -94,24 +135,64 @@ type Route struct {
 
 type RoutingTable map[string][]Route // Key: Component name
 
+type ReconfigurationOutcome string
+
+const (
+	ReconfigurationInProgress ReconfigurationOutcome = "in_progress"
+	ReconfigurationSucceeded  ReconfigurationOutcome = "succeeded"
+	ReconfigurationFailed     ReconfigurationOutcome = "failed"
+	ReconfigurationSkipped    ReconfigurationOutcome = "skipped"     // the policy asked for a change, but there was no layout to move to
+	ReconfigurationDryRun     ReconfigurationOutcome = "dry_run"     // the change was only recorded, the controller runs in dry-run mode for the app
+	ReconfigurationPostponed  ReconfigurationOutcome = "postponed"   // the layout did not fit onto the nodes, the change is retried later
+	ReconfigurationRolledBack ReconfigurationOutcome = "rolled_back" // the new layout did not become ready, the previous layout was restored
+)
+
+// ReconfigurationRecord describes a single reconfiguration decision of the controller and its result
+type ReconfigurationRecord struct {
+	Id            string                 `json:"id"`
+	FunctionAppId string                 `json:"function_app_id"`
+	Action        ReconfigurationAction  `json:"action"`
+	Policy        string                 `json:"policy"`
+	Reason        string                 `json:"reason"`
+	TriggerMetric float64                `json:"trigger_metric"` // in milliseconds, 0 if no runtime was reported
+	MetricType    MetricType             `json:"metric_type"`
+	TraceCount    int                    `json:"trace_count"`
+	FromLayoutKey string                 `json:"from_layout_key"`
+	ToLayoutKey   string                 `json:"to_layout_key"`
+	Layout        Layout                 `json:"layout,omitempty"` // the layout deployed, or in dry-run mode the one that would have been deployed
+	StartTime     time.Time              `json:"start_time"`
+	EndTime       time.Time              `json:"end_time"`
+	Outcome       ReconfigurationOutcome `json:"outcome"`
+	Error         string                 `json:"error,omitempty"`
+}
+
 type Build struct {
-	Image     string `json:"image"`
-	Timestamp string `json:"timestamp"`
+	Image          string `json:"image"`
+	Timestamp      string `json:"timestamp"`
+	SourceRevision int    `json:"source_revision"`     // source revision of the app the image was built from
+	CacheKey       string `json:"cache_key,omitempty"` // hash of the sources the image was built from, empty if not cached
 }
 
 type FunctionAppCreationData struct {
-	Components   []Component
-	Links        []ComponentLink
-	UploadDir    string
-	Files        []*multipart.FileHeader
-	AppName      string
-	Runtime      string
-	LatencyLimit int
+	Components         []Component
+	Links              []ComponentLink
+	UploadDir          string
+	Files              []*multipart.FileHeader
+	AppName            string
+	Runtime            string
+	LatencyLimit       int
+	ControllerPolicy   string
+	RateLevels         []float64
+	ControllerSettings *ControllerSettings
+	Priority           int
+	PathLatencyLimits  map[string]int
 }
 
 type LayoutScenario struct {
 	LatencyRequirement          int
+	PathLatencyRequirements     map[string]int // end component -> latency requirement of the path leading to it, in ms
 	AvailableNodeMemory         int
+	NodeFreeMemory              map[string]int // memory in MB per platform node not promised to other apps, nil if unknown
 	Profiles                    []ComponentProfile
 	Links                       []ScenarioLink
 	ComponentMCPUAllocation     int
@@ -149,6 +230,12 @@ type ScenarioLink struct {
 	DataDelay      int
 }
 
+type LayoutLevel struct {
+	Key         string  `json:"key"`
+	RateLevel   float64 `json:"rate_level"`   // 0 = InvocationRate.Min, 1 = InvocationRate.Max
+	IngressRate float64 `json:"ingress_rate"` // requests per second arriving at the entry component at this level
+}
+
 type Layout = map[string]CompositionInfo // Key: Node name, Value: CompositionInfo assigned to that node
 
 type CompositionInfo struct {
@@ -0,0 +1,90 @@
+package core
+
+import (
+	"fmt"
+	"log"
+)
+
+// pathQueryResult holds the runtimes of the paths to each end component per app
+type pathQueryResult struct {
+	runtimes    map[string]map[string]float64
+	traceCounts map[string]map[string]int
+	err         error
+}
+
+// endComponents returns the components the call graph ends in, the ones which do not call another component
+func endComponents(components []Component, links []ComponentLink) map[string]bool {
+	callers := make(map[string]bool, len(links))
+	for _, link := range links {
+		callers[link.From] = true
+	}
+	ends := make(map[string]bool)
+	for _, comp := range components {
+		if !callers[comp.Name] {
+			ends[comp.Name] = true
+		}
+	}
+	return ends
+}
+
+// ValidatePathLatencyLimits checks that every limit is positive and belongs to an end component of the call graph
+func ValidatePathLatencyLimits(components []Component, links []ComponentLink, limits map[string]int) error {
+	ends := endComponents(components, links)
+	for end, limit := range limits {
+		if !ends[end] {
+			return fmt.Errorf("path latency limit set for %s, which is not an end component of the call graph", end)
+		}
+		if limit <= 0 {
+			return fmt.Errorf("path latency limit of %s must be positive", end)
+		}
+	}
+	return nil
+}
+
+// worstPath returns the end component whose path misses its latency limit by the largest factor. The path runtime is
+// scaled to the app-wide latency limit, so the policies can compare it with LatencyLimit like the runtime of the app.
+func (app *FunctionApp) worstPath(runtimes map[string]float64, traceCounts map[string]int) (string, float64, int, bool) {
+	if app.LatencyLimit <= 0 {
+		return "", 0, 0, false
+	}
+	var worst string
+	var worstRatio float64
+	for end, limit := range app.PathLatencyLimits {
+		runtime, ok := runtimes[end]
+		if !ok || limit <= 0 {
+			continue
+		}
+		if ratio := runtime / float64(limit); worst == "" || ratio > worstRatio {
+			worst, worstRatio = end, ratio
+		}
+	}
+	if worst == "" {
+		return "", 0, 0, false
+	}
+	return worst, worstRatio * float64(app.LatencyLimit), traceCounts[worst], true
+}
+
+// pathSignals replaces the app's metric with the one of its worst path, if that path is further from its limit.
+// Path runtimes are queried once per distinct query and tick, results caches them.
+func (c *latencyController) pathSignals(app *FunctionApp, query metricQuery, results map[metricQuery]pathQueryResult, signals *appSignals) {
+	if len(app.PathLatencyLimits) == 0 {
+		return
+	}
+	result, ok := results[query]
+	if !ok {
+		result.runtimes, result.traceCounts, result.err = c.metrics.QueryPathRuntimes(query.timeRange, query.metricType)
+		if result.err != nil {
+			log.Printf("Error querying path runtimes: %v", result.err)
+		}
+		results[query] = result
+	}
+	if result.err != nil {
+		return
+	}
+
+	end, metric, traceCount, ok := app.worstPath(result.runtimes[app.Id], result.traceCounts[app.Id])
+	if !ok || (signals.hasMetric && metric <= signals.metric) {
+		return
+	}
+	signals.metric, signals.hasMetric, signals.traceCount, signals.path = metric, true, traceCount, end
+}
@@ -0,0 +1,193 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// errNodeOvercommit is returned when a layout does not fit onto the platform nodes with their current load
+var errNodeOvercommit = errors.New("layout would overcommit the platform nodes")
+
+// postponeRetryInterval is the time the controller waits before retrying an upgrade which was postponed because of node pressure
+const postponeRetryInterval = 30 * time.Second
+
+// PlacementSettings configures how the node assignments of a layout are adjusted to the current node load
+type PlacementSettings struct {
+	Enabled             bool
+	Nodes               []string // the platform nodes layouts may be placed on
+	CPUUtilizationLimit float64  // nodes above this utilisation (0-1) do not receive new deployments
+	NodeMCPU            int      // CPU of a node in millicores, 0 leaves the CPU promised to deployments unchecked
+}
+
+type nodeHeadroom struct {
+	freeMemory     int // in MB
+	cpuUtilization float64
+}
+
+// placeLayout moves the compositions of the layout to nodes with enough headroom, keeping each composition on its
+// calculated node whenever possible. Compositions are only moved as a whole, so the grouping of the layout is preserved.
+// For upgrades errNodeOvercommit is returned if the layout does not fit, other changes fall back to the calculated placement.
+func (c *latencyController) placeLayout(app *FunctionApp, layout Layout, isUpgrade bool) (Layout, error) {
+	if !c.placement.Enabled {
+		return layout, nil
+	}
+
+	nodeMetrics, err := c.metrics.QueryNodeMetrics()
+	if err != nil {
+		log.Printf("Error querying node metrics, keeping the calculated placement for app %s: %v", app.Id, err)
+		return layout, nil
+	}
+
+	// memory already used by the app's deployments, these are reused if the composition stays on the node.
+	// Apps listed by the repository come without their compositions, so they are loaded here.
+	compositions := app.Compositions
+	if len(compositions) == 0 {
+		if full, err := c.composer.GetFunctionApp(app.Id); err == nil && full != nil {
+			compositions = full.Compositions
+		}
+	}
+	inUse := make(map[string]int) // componentsKey@node -> memory in MB
+	for _, fc := range compositions {
+		for _, d := range fc.Deployments {
+			if d.Status == DeploymentStatusError {
+				continue
+			}
+			inUse[componentsKey(fc.Components)+"@"+d.Node] += d.Resources.Memory * d.Scale.MaxReplicas
+		}
+	}
+
+	// the app is accounted for like in checkCapacity: only the deployments the layout keeps on their node stay promised,
+	// the others are deleted by the transition
+	promised, err := c.capacity.promised(app.Id)
+	if err != nil {
+		log.Printf("Error accounting node capacity, placing app %s by the measured usage only: %v", app.Id, err)
+	}
+	for node, info := range layout {
+		names := make([]string, len(info.ComponentProfiles))
+		for i, cp := range info.ComponentProfiles {
+			names[i] = cp.Name
+		}
+		kept, ok := inUse[componentsKey(names)+"@"+node]
+		if promised == nil || !ok {
+			continue
+		}
+		p := promised[node]
+		p.Memory += kept
+		promised[node] = p
+	}
+
+	placed, err := placeOnNodes(layout, inUse, c.nodeHeadroom(nodeMetrics, promised), c.placement.CPUUtilizationLimit)
+	if err != nil {
+		if isUpgrade {
+			return nil, err
+		}
+		log.Printf("Warning: %v, keeping the calculated placement for app %s", err, app.Id)
+		return layout, nil
+	}
+	return placed, nil
+}
+
+// nodeHeadroom returns the free resources per platform node, promised are the resources held by the deployments on each node
+func (c *latencyController) nodeHeadroom(nodeMetrics []NodeMetrics, promised map[string]NodeCapacity) map[string]nodeHeadroom {
+	platformNodes := make(map[string]bool, len(c.placement.Nodes))
+	for _, node := range c.placement.Nodes {
+		platformNodes[node] = true
+	}
+
+	headroom := make(map[string]nodeHeadroom)
+	for _, m := range nodeMetrics {
+		node := string(m.Node)
+		if len(platformNodes) > 0 && !platformNodes[node] {
+			continue
+		}
+		usedMb := int(m.Memory.Usage / (1024 * 1024)) // usage is reported in bytes
+		freeMemory := c.availableNodeMemoryGb*1024 - usedMb
+		if promised != nil {
+			// replicas which are scaled down do not use memory, but the memory promised to them can not be given away
+			freeMemory = min(freeMemory, c.availableNodeMemoryGb*1024-promised[node].Memory)
+		}
+		headroom[node] = nodeHeadroom{
+			freeMemory:     freeMemory,
+			cpuUtilization: m.Cpu.Utilization,
+		}
+	}
+	return headroom
+}
+
+func placeOnNodes(layout Layout, inUse map[string]int, headroom map[string]nodeHeadroom, cpuLimit float64) (Layout, error) {
+	type group struct {
+		node string
+		key  string
+		info CompositionInfo
+	}
+	groups := make([]group, 0, len(layout))
+	for node, info := range layout {
+		names := make([]string, len(info.ComponentProfiles))
+		for i, cp := range info.ComponentProfiles {
+			names[i] = cp.Name
+		}
+		groups = append(groups, group{node: node, key: componentsKey(names), info: info})
+	}
+	// place the largest compositions first, they are the hardest to fit
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].info.TotalMemory() != groups[j].info.TotalMemory() {
+			return groups[i].info.TotalMemory() > groups[j].info.TotalMemory()
+		}
+		return groups[i].node < groups[j].node
+	})
+
+	required := func(g group, node string) int {
+		return max(0, g.info.TotalMemory()-inUse[g.key+"@"+node])
+	}
+	fits := func(g group, node string) bool {
+		h, known := headroom[node]
+		if !known {
+			// without metrics the node can only keep what was calculated for it
+			return node == g.node
+		}
+		need := required(g, node)
+		if need == 0 {
+			return true
+		}
+		return h.freeMemory >= need && h.cpuUtilization < cpuLimit
+	}
+	assign := func(g group, node string) {
+		if h, ok := headroom[node]; ok {
+			h.freeMemory -= required(g, node)
+			headroom[node] = h
+		}
+	}
+
+	placed := make(Layout, len(layout))
+	var unplaced []group
+	for _, g := range groups {
+		if fits(g, g.node) {
+			placed[g.node] = g.info
+			assign(g, g.node)
+			continue
+		}
+		unplaced = append(unplaced, g)
+	}
+
+	for _, g := range unplaced {
+		best := ""
+		for node, h := range headroom {
+			if _, taken := placed[node]; taken || !fits(g, node) {
+				continue
+			}
+			if best == "" || h.freeMemory > headroom[best].freeMemory || (h.freeMemory == headroom[best].freeMemory && node < best) {
+				best = node
+			}
+		}
+		if best == "" {
+			return nil, fmt.Errorf("%w: no node has %dMB free for composition %s", errNodeOvercommit, g.info.TotalMemory(), g.key)
+		}
+		log.Printf("Moving composition %s from node %s to node %s because of node pressure", g.key, g.node, best)
+		placed[best] = g.info
+		assign(g, best)
+	}
+	return placed, nil
+}
@@ -0,0 +1,283 @@
+package core
+
+import (
+	"fmt"
+	"time"
+)
+
+type ReconfigurationAction string
+
+const (
+	ActionHold            ReconfigurationAction = "hold"
+	ActionUpgrade         ReconfigurationAction = "upgrade"
+	ActionDowngrade       ReconfigurationAction = "downgrade"
+	ActionManual          ReconfigurationAction = "manual"           // layout selected through the API, never returned by a policy
+	ActionReprofile       ReconfigurationAction = "reprofile"        // layout candidates regenerated from observed profiles, never returned by a policy
+	ActionRollback        ReconfigurationAction = "rollback"         // back to the previous layout after it started failing, never returned by a policy
+	ActionSourceUpdate    ReconfigurationAction = "source_update"    // compositions rebuilt from new source and redeployed in the active layout, never returned by a policy
+	ActionVersionRollback ReconfigurationAction = "version_rollback" // images of a recorded app version redeployed in the active layout, never returned by a policy
+)
+
+const (
+	PolicyThreshold  = "threshold"
+	PolicyHysteresis = "hysteresis"
+	PolicyPID        = "pid"
+	PolicySLO        = "slo_burn_rate"
+	PolicyRate       = "rate"
+	DefaultPolicy    = PolicyThreshold
+)
+
+const (
+	maxPolicySamples = 120 // number of metric samples kept per app for policies that look at trends
+
+	// threshold policy
+	minConsecutiveDowngrade = 300
+	silentDowngradeStep     = 20 // apps without traces are considered idle, so they become eligible for downgrade faster
+
+	// hysteresis policy
+	hysteresisUpgradeIntervals   = 5
+	hysteresisDowngradeIntervals = 60
+
+	// pid policy
+	pidKp            = 1.0
+	pidKi            = 0.02
+	pidKd            = 0.5
+	pidSilentTimeout = 5 * time.Minute
+)
+
+type MetricSample struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// PolicyHistory is the per-app state carried between controller ticks, policies are free to update it
+type PolicyHistory struct {
+	LastReconfig                 time.Time      `json:"last_reconfig"`
+	ConsecutiveUpgradeEligible   int            `json:"consecutive_upgrade_eligible"`
+	ConsecutiveDowngradeEligible int            `json:"consecutive_downgrade_eligible"`
+	PostponedUntil               time.Time      `json:"postponed_until"` // set when a reconfiguration did not fit onto the nodes
+	Samples                      []MetricSample `json:"samples"`
+	Forecast                     *RateForecast  `json:"forecast,omitempty"` // kept across reconfigurations, the arrival rate does not depend on the layout
+	ConsecutiveErrorIntervals    int            `json:"consecutive_error_intervals"`
+	ErrorSpikeStart              time.Time      `json:"error_spike_start"`    // zero while the app is healthy
+	PreviousLayoutKey            string         `json:"previous_layout_key"`  // layout before the last reconfiguration, empty after a rollback
+	ScheduledLayoutKey           string         `json:"scheduled_layout_key"` // layout the app's schedule selected when the app last reached it
+}
+
+// counters returns the history without the samples and the forecast, which change on every interval
+func (h *PolicyHistory) counters() PolicyHistory {
+	counters := *h
+	counters.Samples = nil
+	counters.Forecast = nil
+	return counters
+}
+
+func (h *PolicyHistory) addSample(t time.Time, value float64) {
+	h.Samples = append(h.Samples, MetricSample{Time: t, Value: value})
+	if len(h.Samples) > maxPolicySamples {
+		h.Samples = h.Samples[len(h.Samples)-maxPolicySamples:]
+	}
+}
+
+type PolicyInput struct {
+	AppId           string
+	Metric          float64
+	HasMetric       bool // false if no traces were reported for the app in the current interval
+	TraceCount      int
+	LatencyLimit    int
+	ActiveLayoutKey string
+	History         *PolicyHistory
+	DowngradeFactor float64
+	MinTraceCount   int
+	Now             time.Time
+	BurnRates       *BurnRates    // only set for apps using the slo_burn_rate policy, nil if the burn rates could not be queried
+	SLOPeriod       time.Duration // period the error budget of the SLO is calculated for
+	// burn rate below which the slo_burn_rate policy downgrades, DowngradeFactor is a latency ratio and does not apply to it
+	SLODowngradeBurnRate float64
+	RateCoverage         *RateCoverage // only set for apps using the rate policy
+}
+
+type PolicyDecision struct {
+	Action ReconfigurationAction
+	Reason string
+	// TargetLayoutKey is the layout to move to, empty means the adjacent level in the direction of the action
+	TargetLayoutKey string
+}
+
+func hold() PolicyDecision {
+	return PolicyDecision{Action: ActionHold}
+}
+
+// ReconfigurationPolicy decides whether an app should move up or down its layout ladder
+type ReconfigurationPolicy interface {
+	Name() string
+	Decide(input PolicyInput) PolicyDecision
+}
+
+var reconfigurationPolicies = map[string]ReconfigurationPolicy{
+	PolicyThreshold:  &thresholdPolicy{},
+	PolicyHysteresis: &hysteresisPolicy{},
+	PolicyPID:        &pidPolicy{},
+	PolicySLO:        &sloBurnRatePolicy{},
+	PolicyRate:       &ratePolicy{},
+}
+
+// GetReconfigurationPolicy returns the policy registered under name, an empty name selects the default policy
+func GetReconfigurationPolicy(name string) (ReconfigurationPolicy, error) {
+	if name == "" {
+		name = DefaultPolicy
+	}
+	policy, ok := reconfigurationPolicies[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown reconfiguration policy: %s", name)
+	}
+	return policy, nil
+}
+
+// thresholdPolicy upgrades as soon as the metric exceeds the latency limit and downgrades
+// after the metric stayed below LatencyLimit*DowngradeFactor for minConsecutiveDowngrade intervals
+type thresholdPolicy struct{}
+
+func (p *thresholdPolicy) Name() string { return PolicyThreshold }
+
+func (p *thresholdPolicy) Decide(in PolicyInput) PolicyDecision {
+	h := in.History
+	if !in.HasMetric {
+		h.ConsecutiveDowngradeEligible += silentDowngradeStep
+		if h.ConsecutiveDowngradeEligible < minConsecutiveDowngrade {
+			return hold()
+		}
+		count := h.ConsecutiveDowngradeEligible
+		h.ConsecutiveDowngradeEligible = 0
+		return PolicyDecision{
+			Action: ActionDowngrade,
+			Reason: fmt.Sprintf("no runtime reported for %d consecutive intervals", count),
+		}
+	}
+
+	limit := float64(in.LatencyLimit)
+	switch {
+	case in.Metric > limit:
+		// Check trace count for upgrades to avoid reconfigurations based on insufficient data
+		if in.TraceCount < in.MinTraceCount {
+			return hold()
+		}
+		h.ConsecutiveDowngradeEligible = 0
+		return PolicyDecision{
+			Action: ActionUpgrade,
+			Reason: fmt.Sprintf("latency %.0fms exceeds limit %dms", in.Metric, in.LatencyLimit),
+		}
+	case in.Metric < limit*in.DowngradeFactor:
+		// Oscillation defense for downgrade: track consecutive intervals
+		h.ConsecutiveDowngradeEligible++
+		if h.ConsecutiveDowngradeEligible < minConsecutiveDowngrade {
+			return hold()
+		}
+		return PolicyDecision{
+			Action: ActionDowngrade,
+			Reason: fmt.Sprintf("latency below %.0fms for %d consecutive intervals", limit*in.DowngradeFactor, h.ConsecutiveDowngradeEligible),
+		}
+	default:
+		h.ConsecutiveDowngradeEligible = 0
+		return hold()
+	}
+}
+
+// hysteresisPolicy keeps a dead band between LatencyLimit*DowngradeFactor and LatencyLimit,
+// both directions require several consecutive samples outside the band and any sample inside it resets the counters
+type hysteresisPolicy struct{}
+
+func (p *hysteresisPolicy) Name() string { return PolicyHysteresis }
+
+func (p *hysteresisPolicy) Decide(in PolicyInput) PolicyDecision {
+	h := in.History
+	upper := float64(in.LatencyLimit)
+	lower := upper * in.DowngradeFactor
+
+	switch {
+	case in.HasMetric && in.Metric > upper:
+		if in.TraceCount < in.MinTraceCount {
+			return hold()
+		}
+		h.ConsecutiveDowngradeEligible = 0
+		h.ConsecutiveUpgradeEligible++
+		if h.ConsecutiveUpgradeEligible < hysteresisUpgradeIntervals {
+			return hold()
+		}
+		h.ConsecutiveUpgradeEligible = 0
+		return PolicyDecision{
+			Action: ActionUpgrade,
+			Reason: fmt.Sprintf("latency above %.0fms for %d consecutive intervals", upper, hysteresisUpgradeIntervals),
+		}
+	case !in.HasMetric || in.Metric < lower:
+		h.ConsecutiveUpgradeEligible = 0
+		h.ConsecutiveDowngradeEligible++
+		if h.ConsecutiveDowngradeEligible < hysteresisDowngradeIntervals {
+			return hold()
+		}
+		h.ConsecutiveDowngradeEligible = 0
+		return PolicyDecision{
+			Action: ActionDowngrade,
+			Reason: fmt.Sprintf("latency below %.0fms for %d consecutive intervals", lower, hysteresisDowngradeIntervals),
+		}
+	default:
+		h.ConsecutiveUpgradeEligible = 0
+		h.ConsecutiveDowngradeEligible = 0
+		return hold()
+	}
+}
+
+// pidPolicy treats the relative distance from the latency limit as the control error and combines
+// its current value, its integral over the sample history and its rate of change into a single control signal.
+// A positive signal means the app is under-provisioned, a signal below DowngradeFactor-1 means it is over-provisioned.
+type pidPolicy struct{}
+
+func (p *pidPolicy) Name() string { return PolicyPID }
+
+func (p *pidPolicy) Decide(in PolicyInput) PolicyDecision {
+	h := in.History
+	if !in.HasMetric {
+		// every reconfiguration clears the samples, without any the silence is counted from the last reconfiguration
+		lastSeen := h.LastReconfig
+		if len(h.Samples) > 0 {
+			lastSeen = h.Samples[len(h.Samples)-1].Time
+		}
+		if lastSeen.IsZero() || in.Now.Sub(lastSeen) < pidSilentTimeout {
+			return hold()
+		}
+		return PolicyDecision{Action: ActionDowngrade, Reason: "no runtime reported within the silence timeout"}
+	}
+	if len(h.Samples) == 0 {
+		return hold()
+	}
+
+	limit := float64(in.LatencyLimit)
+	relErr := func(v float64) float64 { return (v - limit) / limit }
+
+	var integral float64
+	for i := 1; i < len(h.Samples); i++ {
+		dt := h.Samples[i].Time.Sub(h.Samples[i-1].Time).Seconds()
+		integral += relErr(h.Samples[i].Value) * dt
+	}
+
+	current := relErr(h.Samples[len(h.Samples)-1].Value)
+	var derivative float64
+	if n := len(h.Samples); n > 1 {
+		if dt := h.Samples[n-1].Time.Sub(h.Samples[n-2].Time).Seconds(); dt > 0 {
+			derivative = (current - relErr(h.Samples[n-2].Value)) / dt
+		}
+	}
+
+	signal := pidKp*current + pidKi*integral + pidKd*derivative
+	switch {
+	case signal > 0:
+		if in.TraceCount < in.MinTraceCount {
+			return hold()
+		}
+		return PolicyDecision{Action: ActionUpgrade, Reason: fmt.Sprintf("control signal %.3f above 0", signal)}
+	case signal < in.DowngradeFactor-1:
+		return PolicyDecision{Action: ActionDowngrade, Reason: fmt.Sprintf("control signal %.3f below %.3f", signal, in.DowngradeFactor-1)}
+	default:
+		return hold()
+	}
+}
@@ -0,0 +1,302 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"lsf-configurator/pkg/uuid"
+	"math"
+	"reflect"
+	"time"
+)
+
+// ProfilerSettings configures the online re-profiling of component runtimes and memory usage
+type ProfilerSettings struct {
+	Interval       time.Duration // 0 disables the profiler
+	TimeRange      string        // Elasticsearch date math, e.g. now-15m
+	DriftThreshold float64       // relative difference between the observed and the applied profile which triggers regeneration
+	MinSamples     int           // minimal number of spans before the runtime of a component is considered observed
+}
+
+// profiledValues returns the runtime and memory the layout candidates of the app are calculated from
+func (comp Component) profiledValues() (runtime, memory int) {
+	runtime, memory = comp.Runtime, comp.Memory
+	if comp.Applied != nil {
+		if comp.Applied.Runtime > 0 {
+			runtime = comp.Applied.Runtime
+		}
+		if comp.Applied.Memory > 0 {
+			memory = comp.Applied.Memory
+		}
+	}
+	return runtime, memory
+}
+
+// profileDrift returns the largest relative difference between an observed and an applied value over all components
+func (app *FunctionApp) profileDrift() float64 {
+	var drift float64
+	relDiff := func(observed, applied int) float64 {
+		if observed <= 0 || applied <= 0 {
+			return 0
+		}
+		return math.Abs(float64(observed-applied)) / float64(applied)
+	}
+	for _, comp := range app.Components {
+		if comp.Observed == nil {
+			continue
+		}
+		runtime, memory := comp.profiledValues()
+		drift = math.Max(drift, relDiff(comp.Observed.Runtime, runtime))
+		drift = math.Max(drift, relDiff(comp.Observed.Memory, memory))
+	}
+	return drift
+}
+
+// profileApps updates the observed profiles of the apps and regenerates the layout candidates of those which drifted too far
+func (c *latencyController) profileApps(apps []*FunctionApp) {
+	spanStats, err := c.metrics.QueryComponentSpanStats(c.profiler.TimeRange)
+	if err != nil {
+		log.Printf("Error querying component span statistics: %v", err)
+		return
+	}
+	memoryUsage, err := c.metrics.QueryServiceMemoryUsage(c.profiler.TimeRange)
+	if err != nil {
+		// runtimes can still be profiled without memory metrics
+		log.Printf("Error querying pod memory usage: %v", err)
+	}
+
+	for _, app := range apps {
+		if len(app.LayoutCandidates) == 0 {
+			// not managed by the controller
+			continue
+		}
+		// the listed apps come without their compositions, which are needed to attribute the pod memory usage
+		app, err := c.composer.GetFunctionApp(app.Id)
+		if err != nil || app == nil {
+			log.Printf("Error loading app for profiling: %v", err)
+			continue
+		}
+		if !c.observeProfiles(app, spanStats[app.Id], memoryUsage) {
+			continue
+		}
+
+		drift := app.profileDrift()
+		settings := app.ControllerSettings.withDefaults(c.defaults)
+		if drift < c.profiler.DriftThreshold || app.ControllerPaused || settings.dryRun() {
+			if err := c.composer.functionAppRepo.Save(app); err != nil {
+				log.Printf("Error saving observed profiles of app %s: %v", app.Id, err)
+			}
+			continue
+		}
+
+		log.Printf("Observed profiles of app %s drifted by %.0f%%, regenerating layout candidates", app.Id, drift*100)
+		if err := c.regenerateLayouts(app, drift); errors.Is(err, ErrReconfigurationInProgress) {
+			// the profiles are observed again in the next round
+			log.Printf("Postponing regeneration of layout candidates of app %s: %v", app.Id, err)
+		} else if err != nil {
+			log.Printf("Error regenerating layout candidates of app %s: %v", app.Id, err)
+		}
+	}
+}
+
+// observeProfiles stores the runtimes and memory usages observed for the components of the app, returns whether anything was observed.
+// Pods run whole compositions, so the memory usage of a pod is split among its components in proportion to their declared memory.
+func (c *latencyController) observeProfiles(app *FunctionApp, spanStats map[string]SpanStats, memoryUsage map[string]float64) bool {
+	memorySum := make(map[string]float64)
+	memoryCount := make(map[string]int)
+	for _, fc := range app.Compositions {
+		declared := 0
+		for _, name := range fc.Components {
+			declared += app.component(name).Memory
+		}
+		if declared == 0 {
+			continue
+		}
+		for _, dep := range fc.Deployments {
+			usage, ok := memoryUsage[dep.Id]
+			if !ok {
+				continue
+			}
+			for _, name := range fc.Components {
+				memorySum[name] += usage * float64(app.component(name).Memory) / float64(declared)
+				memoryCount[name]++
+			}
+		}
+	}
+
+	observed := false
+	now := c.clock.Now()
+	for i, comp := range app.Components {
+		profile := ObservedProfile{UpdatedAt: now}
+		if stats, ok := spanStats[comp.Name]; ok && stats.Count >= c.profiler.MinSamples {
+			profile.Runtime = int(math.Round(stats.AvgDurationMs))
+			profile.SampleCount = stats.Count
+		}
+		if n := memoryCount[comp.Name]; n > 0 {
+			profile.Memory = int(math.Round(memorySum[comp.Name] / float64(n)))
+		}
+		if profile.Runtime == 0 && profile.Memory == 0 {
+			continue
+		}
+		app.Components[i].Observed = &profile
+		observed = true
+	}
+	return observed
+}
+
+// regenerateLayouts recalculates the layout candidates of the app from the observed profiles
+// and deploys the new version of the active layout if it changed. Apps in the middle of a transition are left alone.
+// If the deployment fails, the previous candidates are restored, as the layout still serving is one of them.
+func (c *latencyController) regenerateLayouts(app *FunctionApp, drift float64) error {
+	recordId := uuid.New()
+	if !c.transitions.occupy(recordId, app.Id) {
+		return ErrReconfigurationInProgress
+	}
+	deploying := false
+	defer func() {
+		if !deploying {
+			c.transitions.finish(recordId)
+		}
+	}()
+
+	components := make([]Component, len(app.Components))
+	profiled := make([]Component, len(app.Components))
+	for i, comp := range app.Components {
+		if comp.Observed != nil {
+			applied := *comp.Observed
+			comp.Applied = &applied
+		}
+		components[i] = comp
+		profiled[i] = comp
+		profiled[i].Runtime, profiled[i].Memory = comp.profiledValues()
+	}
+
+	rateLevels := app.RateLevels
+	if len(rateLevels) == 0 {
+		for _, level := range app.Ladder() {
+			rateLevels = append(rateLevels, level.RateLevel)
+		}
+	}
+
+	candidates, ladder, err := c.scenarioManager.GenerateLayoutCandidates(
+		profiled,
+		app.Links,
+		rateLevels,
+		app.LatencyLimit,
+		app.PathLatencyLimits,
+		c.availableNodeMemoryGb*1024,
+		c.capacity.freeMemory(app.Id))
+	if err != nil {
+		return err
+	}
+
+	previousKey := app.ActiveLayoutKey
+	previousLayout := app.LayoutCandidates[previousKey]
+	previous := layoutCandidates{
+		candidates: app.LayoutCandidates,
+		ladder:     app.LayoutLadder,
+		applied:    make(map[string]*ObservedProfile, len(app.Components)),
+	}
+	for _, comp := range app.Components {
+		previous.applied[comp.Name] = comp.Applied
+	}
+	previousLevel := 0.0
+	if i := app.layoutLevel(previousKey); i >= 0 {
+		previousLevel = app.Ladder()[i].RateLevel
+	}
+
+	app.Components = components
+	app.LayoutCandidates = candidates
+	app.LayoutLadder = ladder
+	app.ActiveLayoutKey = coveringLayoutKey(ladder, previousLevel)
+	if err := c.composer.functionAppRepo.Save(app); err != nil {
+		return fmt.Errorf("failed to save regenerated layout candidates: %w", err)
+	}
+	if err := c.ensureCompositions(app); err != nil {
+		return err
+	}
+
+	layout := candidates[app.ActiveLayoutKey]
+	if reflect.DeepEqual(layout, previousLayout) {
+		return nil
+	}
+	layout, _ = c.placeLayout(app, layout, false)
+
+	record := &ReconfigurationRecord{
+		Id:            recordId,
+		FunctionAppId: app.Id,
+		Action:        ActionReprofile,
+		Reason:        fmt.Sprintf("observed profiles drifted by %.0f%%", drift*100),
+		FromLayoutKey: previousKey,
+		ToLayoutKey:   app.ActiveLayoutKey,
+		Layout:        layout,
+		StartTime:     c.clock.Now(),
+		Outcome:       ReconfigurationInProgress,
+	}
+	c.startReconfiguration(record)
+	deploying = true
+
+	c.async(func() {
+		err := c.deployLayout(app.Id, layout, false, reuseDeployments, nil, record)
+		if err != nil {
+			log.Printf("Failed to deploy regenerated layout for app %s: %v", app.Id, err)
+			if restoreErr := c.restoreLayoutCandidates(app.Id, record, previous); restoreErr != nil {
+				err = fmt.Errorf("%w, and the previous layout candidates could not be restored: %v", err, restoreErr)
+			} else {
+				err = fmt.Errorf("%w to layout %s: %v", errTransitionRolledBack, record.FromLayoutKey, err)
+			}
+		}
+		c.finishReconfiguration(record, err)
+	})
+	return nil
+}
+
+// layoutCandidates are the layout candidates of an app and the profiles of its components they were generated from
+type layoutCandidates struct {
+	candidates map[string]Layout
+	ladder     []LayoutLevel
+	applied    map[string]*ObservedProfile
+}
+
+// restoreLayoutCandidates puts back the candidates the app had before regenerateLayouts, along with the layout
+// the app was on. The previous layout keeps serving when a regenerated layout fails to deploy.
+func (c *latencyController) restoreLayoutCandidates(appId string, record *ReconfigurationRecord, previous layoutCandidates) error {
+	app, err := c.composer.GetFunctionApp(appId)
+	if err != nil {
+		return err
+	}
+	if app == nil {
+		return fmt.Errorf("function app %s not found", appId)
+	}
+
+	app.LayoutCandidates = previous.candidates
+	app.LayoutLadder = previous.ladder
+	app.ActiveLayoutKey = record.FromLayoutKey
+	for i, comp := range app.Components {
+		app.Components[i].Applied = previous.applied[comp.Name]
+	}
+	if err := c.composer.functionAppRepo.Save(app); err != nil {
+		return fmt.Errorf("failed to restore layout candidates: %w", err)
+	}
+	log.Printf("Restored the layout candidates of app %s after the regenerated layout %s failed", appId, record.ToLayoutKey)
+	return nil
+}
+
+// coveringLayoutKey returns the key of the lowest level at or above rateLevel, or the highest level if there is none
+func coveringLayoutKey(ladder []LayoutLevel, rateLevel float64) string {
+	for _, level := range ladder {
+		if level.RateLevel >= rateLevel {
+			return level.Key
+		}
+	}
+	return ladder[len(ladder)-1].Key
+}
+
+func (app *FunctionApp) component(name string) Component {
+	for _, comp := range app.Components {
+		if comp.Name == name {
+			return comp
+		}
+	}
+	return Component{}
+}
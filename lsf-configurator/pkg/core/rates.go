@@ -0,0 +1,143 @@
+package core
+
+import (
+	"fmt"
+	"log"
+)
+
+// rateDowngradeIntervals is the number of consecutive intervals the observed rates have to fit a lower layout before the rate policy downgrades
+const rateDowngradeIntervals = 60
+
+// ObservedRates are the invocation rates measured for an app over the metric query time range
+type ObservedRates struct {
+	Ingress float64 // requests per second arriving at the app
+	// invocations per second per link (from->to), only links whose target is not called by other components can be told apart
+	Links map[string]float64
+}
+
+// RateCoverage is the lowest layout level calculated for rates at least as high as the observed ones
+type RateCoverage struct {
+	Key     string
+	Offset  int // levels between the covering and the active layout, positive if the covering layout is higher
+	Ingress float64
+}
+
+func linkKey(link ComponentLink) string {
+	return link.From + "->" + link.To
+}
+
+// observedRates derives the link rates of the app from the number of spans of each component within window seconds
+func (app *FunctionApp) observedRates(ingress float64, spanStats map[string]SpanStats, windowSeconds float64) ObservedRates {
+	rates := ObservedRates{Ingress: ingress, Links: make(map[string]float64)}
+	callers := make(map[string]int)
+	for _, link := range app.Links {
+		callers[link.To]++
+	}
+	for _, link := range app.Links {
+		stats, ok := spanStats[link.To]
+		if !ok || callers[link.To] != 1 {
+			continue
+		}
+		rates.Links[linkKey(link)] = float64(stats.Count) / windowSeconds
+	}
+	return rates
+}
+
+// rateCoverage returns the level covering the observed rates, or nil if the active layout is not on the ladder
+func (app *FunctionApp) rateCoverage(rates ObservedRates) *RateCoverage {
+	active := app.layoutLevel(app.ActiveLayoutKey)
+	if active < 0 {
+		return nil
+	}
+	ladder := app.Ladder()
+	target := len(ladder) - 1
+	for i, level := range ladder {
+		if app.levelCovers(level, rates) {
+			target = i
+			break
+		}
+	}
+	return &RateCoverage{Key: ladder[target].Key, Offset: target - active, Ingress: rates.Ingress}
+}
+
+func (app *FunctionApp) levelCovers(level LayoutLevel, rates ObservedRates) bool {
+	if app.ingressRate(level) < rates.Ingress {
+		return false
+	}
+	for _, link := range app.Links {
+		measured, ok := rates.Links[linkKey(link)]
+		if ok && rateAtLevel(level.RateLevel)(link.InvocationRate.Min, link.InvocationRate.Max) < measured {
+			return false
+		}
+	}
+	return true
+}
+
+// ratePolicy selects the layout calculated for the observed invocation rates. Latency only acts as a safety override:
+// a violation upgrades one level even if the rates are covered, and downgrades require the latency to be below LatencyLimit*DowngradeFactor.
+type ratePolicy struct{}
+
+func (p *ratePolicy) Name() string { return PolicyRate }
+
+func (p *ratePolicy) Decide(in PolicyInput) PolicyDecision {
+	h := in.History
+	cov := in.RateCoverage
+	limit := float64(in.LatencyLimit)
+
+	switch {
+	case cov != nil && cov.Offset > 0:
+		h.ConsecutiveDowngradeEligible = 0
+		return PolicyDecision{
+			Action:          ActionUpgrade,
+			Reason:          fmt.Sprintf("observed rates (ingress %.2f req/s) require layout %s", cov.Ingress, cov.Key),
+			TargetLayoutKey: cov.Key,
+		}
+	case in.HasMetric && in.Metric > limit && in.TraceCount >= in.MinTraceCount:
+		h.ConsecutiveDowngradeEligible = 0
+		return PolicyDecision{
+			Action: ActionUpgrade,
+			Reason: fmt.Sprintf("latency %.0fms exceeds limit %dms although the observed rates are covered", in.Metric, in.LatencyLimit),
+		}
+	case cov != nil && cov.Offset < 0 && (!in.HasMetric || in.Metric < limit*in.DowngradeFactor):
+		h.ConsecutiveDowngradeEligible++
+		if h.ConsecutiveDowngradeEligible < rateDowngradeIntervals {
+			return hold()
+		}
+		h.ConsecutiveDowngradeEligible = 0
+		return PolicyDecision{
+			Action:          ActionDowngrade,
+			Reason:          fmt.Sprintf("observed rates (ingress %.2f req/s) covered by layout %s for %d consecutive intervals", cov.Ingress, cov.Key, rateDowngradeIntervals),
+			TargetLayoutKey: cov.Key,
+		}
+	default:
+		h.ConsecutiveDowngradeEligible = 0
+		return hold()
+	}
+}
+
+type spanStatsResult struct {
+	stats map[string]map[string]SpanStats
+	err   error
+}
+
+// rateCoverage measures the invocation rates of an app using the rate policy, span statistics are queried once per time range and tick
+func (c *latencyController) rateCoverage(app *FunctionApp, settings ControllerSettings, signals appSignals, cache map[string]spanStatsResult) *RateCoverage {
+	if app.ControllerPolicy != PolicyRate || !signals.hasArrivalRate {
+		return nil
+	}
+	window, err := dateMathWindow(settings.MetricQueryTimeRange)
+	if err != nil {
+		return nil
+	}
+	result, ok := cache[settings.MetricQueryTimeRange]
+	if !ok {
+		stats, err := c.metrics.QueryComponentSpanStats(settings.MetricQueryTimeRange)
+		result = spanStatsResult{stats: stats, err: err}
+		cache[settings.MetricQueryTimeRange] = result
+	}
+	if result.err != nil {
+		// the ingress rate alone still selects a layout
+		log.Printf("Error querying component span statistics for app %s: %v", app.Id, result.err)
+	}
+	return app.rateCoverage(app.observedRates(signals.arrivalRate, result.stats[app.Id], window.Seconds()))
+}
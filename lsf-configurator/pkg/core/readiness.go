@@ -0,0 +1,34 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// defaultReadinessTimeout is the time a new deployment has to become ready before it is considered failed
+const defaultReadinessTimeout = 2 * time.Minute
+
+// SetReadinessProber makes the composer report a deployment as deployed only once its knative service is ready.
+// A deployment whose service fails, or is not ready within timeout, ends up in error with the reason knative reported.
+// Without a prober deployments are deployed as soon as the deploy call returns.
+func (c *Composer) SetReadinessProber(prober ReadinessProber, timeout time.Duration) {
+	c.readiness = prober
+	c.readinessTimeout = defaultReadinessTimeout
+	if timeout > 0 {
+		c.readinessTimeout = timeout
+	}
+}
+
+// waitServiceReady blocks until the knative service of the deployment is ready, the service is named after the deployment
+func (c *Composer) waitServiceReady(deployment *Deployment) error {
+	if c.readiness == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.readinessTimeout)
+	defer cancel()
+	if err := c.readiness.WaitForServiceReady(ctx, deployment.Namespace, deployment.Id); err != nil {
+		return fmt.Errorf("deployment %s did not become ready: %w", deployment.Id, err)
+	}
+	return nil
+}
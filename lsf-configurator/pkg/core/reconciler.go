@@ -0,0 +1,470 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxRedeployAttempts is the number of failed redeploys after which the reconciler gives up on a deployment.
+// Between the attempts it waits twice as many rounds as before the previous one.
+const maxRedeployAttempts = 5
+
+// AppLabel and FunctionCompositionLabel are set on the knative services of the deployments, the reconciler lists the services by AppLabel
+const (
+	AppLabel                 = "lsf-configurator/app"
+	FunctionCompositionLabel = "lsf-configurator/function-composition"
+)
+
+// KnativeService is the observed state of a knative service deployed for a function app
+type KnativeService struct {
+	Name                  string // id of the deployment
+	Namespace             string
+	AppId                 string
+	FunctionCompositionId string
+	Image                 string
+	Node                  string
+	Scale                 Scale // TargetConcurrency is not read back
+	Resources             Resources
+	Ready                 bool
+	Reason                string // why the service is not ready
+}
+
+type DriftKind string
+
+const (
+	DriftMissing  DriftKind = "missing"   // the deployment has no knative service
+	DriftOutdated DriftKind = "outdated"  // the service differs from the image, node, scale or resources of the deployment
+	DriftOrphaned DriftKind = "orphaned"  // the service belongs to no deployment
+	DriftStale    DriftKind = "stale"     // the deployment waits for a deploy nothing runs anymore, e.g. after a restart
+	DriftStatus   DriftKind = "status"    // the deployment is in error, although its service is up to date and ready
+	DriftNotReady DriftKind = "not_ready" // the service of a deployed deployment is not ready, only reported
+)
+
+// Drift is a difference between the deployments table and the cluster. It is only converged once it was observed
+// in two consecutive rounds, so deployments and deletions which are still in flight are not mistaken for drift.
+type Drift struct {
+	Kind                  DriftKind `json:"kind"`
+	AppId                 string    `json:"app_id"`
+	FunctionCompositionId string    `json:"function_composition_id"`
+	DeploymentId          string    `json:"deployment_id"`
+	Namespace             string    `json:"namespace"`
+	Detail                string    `json:"detail"`
+	FirstSeen             time.Time `json:"first_seen"`
+	Action                string    `json:"action,omitempty"` // redeploy, delete or update_status, empty if nothing was done yet
+	Repaired              bool      `json:"repaired"`
+	Error                 string    `json:"error,omitempty"`
+	Attempts              int       `json:"attempts,omitempty"` // failed redeploys of the deployment so far
+	RetryAt               time.Time `json:"retry_at,omitempty"` // the deployment is not redeployed again before, zero if it is not backing off
+
+	deployment *Deployment
+	fc         *FunctionComposition
+	gaveUp     bool // the deployment is not redeployed anymore
+}
+
+// DriftReport is the outcome of a reconciliation round
+type DriftReport struct {
+	Time   time.Time `json:"time"`
+	Drifts []Drift   `json:"drifts"`
+	Error  string    `json:"error,omitempty"` // the round was aborted, the cluster or the database could not be read
+}
+
+// ForApp returns the report restricted to the drift of the app
+func (r DriftReport) ForApp(appId string) DriftReport {
+	filtered := DriftReport{Time: r.Time, Drifts: make([]Drift, 0), Error: r.Error}
+	for _, d := range r.Drifts {
+		if d.AppId == appId {
+			filtered.Drifts = append(filtered.Drifts, d)
+		}
+	}
+	return filtered
+}
+
+type stateReconciler struct {
+	composer *Composer
+	services ServiceLister
+	interval time.Duration
+	repair   bool // false only reports the drift
+	clock    Clock
+
+	mu        sync.Mutex // guards the state below, it is not held while repairs run
+	seen      map[string]Drift
+	redeploys map[string]redeployAttempts // deploymentId -> failed redeploys, until the deployment converges or stops drifting
+	repairing map[string]bool             // deploymentId -> a round is repairing the deployment
+	reportMu  sync.RWMutex
+	report    DriftReport
+}
+
+// ReconcilerOption customizes a reconciler created by NewReconciler
+type ReconcilerOption func(r *stateReconciler)
+
+// WithReconcilerClock makes the reconciler read the time from clock instead of the system clock,
+// simulations pass the clock of their controller
+func WithReconcilerClock(clock Clock) ReconcilerOption {
+	return func(r *stateReconciler) {
+		r.clock = clock
+	}
+}
+
+// NewReconciler creates a reconciler which converges the knative services to the deployments table every interval
+func NewReconciler(composer *Composer, services ServiceLister, interval time.Duration, repair bool, opts ...ReconcilerOption) Reconciler {
+	r := &stateReconciler{
+		composer:  composer,
+		services:  services,
+		interval:  interval,
+		repair:    repair,
+		clock:     systemClock{},
+		seen:      make(map[string]Drift),
+		redeploys: make(map[string]redeployAttempts),
+		repairing: make(map[string]bool),
+		report:    DriftReport{Drifts: make([]Drift, 0)},
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// redeployAttempts are the failed redeploys of a deployment, the next one is not started before retryAt
+type redeployAttempts struct {
+	count   int
+	retryAt time.Time
+}
+
+// redeploys reports whether the drift is converged by redeploying the deployment
+func (d *Drift) redeploys() bool {
+	return d.Kind == DriftMissing || d.Kind == DriftOutdated || d.Kind == DriftStale
+}
+
+func (r *stateReconciler) Start(ctx context.Context) error {
+	log.Println("Reconciler started")
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Reconciler received cancellation signal")
+			return nil
+		case <-ticker.C:
+			r.Reconcile(ctx)
+		}
+	}
+}
+
+func (r *stateReconciler) LastReport() DriftReport {
+	r.reportMu.RLock()
+	defer r.reportMu.RUnlock()
+	return r.report
+}
+
+// Reconcile compares the cluster with the deployments table, converges the drift confirmed by the previous round
+// and waits for the repairs. The repairs are decided under the lock and run without it, a deployment which is
+// still being repaired by another round is left to that round.
+func (r *stateReconciler) Reconcile(ctx context.Context) DriftReport {
+	now := r.clock.Now()
+	report := DriftReport{Time: now, Drifts: make([]Drift, 0)}
+	drifts, err := r.detect(ctx)
+	if err != nil {
+		log.Printf("Reconciliation round aborted: %v", err)
+		report.Error = err.Error()
+		r.setReport(report)
+		return report
+	}
+
+	r.mu.Lock()
+	seen := make(map[string]Drift, len(drifts))
+	var repairs []*Drift
+	for i := range drifts {
+		d := &drifts[i]
+		key := string(d.Kind) + "/" + d.DeploymentId
+		prev, confirmed := r.seen[key]
+		d.FirstSeen = now
+		if confirmed {
+			d.FirstSeen = prev.FirstSeen
+		}
+		seen[key] = *d
+		if !confirmed || !r.repair {
+			continue
+		}
+		if attempts, ok := r.redeploys[d.DeploymentId]; ok && d.redeploys() {
+			d.Attempts = attempts.count
+			if attempts.count >= maxRedeployAttempts {
+				d.Action = "redeploy"
+				d.Error = fmt.Sprintf("gave up after %d failed redeploys", attempts.count)
+				d.gaveUp = true
+				continue
+			}
+			if now.Before(attempts.retryAt) {
+				d.RetryAt = attempts.retryAt
+				continue
+			}
+		}
+		if r.repairing[d.DeploymentId] {
+			continue
+		}
+		r.repairing[d.DeploymentId] = true
+		repairs = append(repairs, d)
+	}
+	r.seen = seen
+	r.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, d := range repairs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.converge(ctx, d)
+		}()
+	}
+	wg.Wait()
+
+	r.mu.Lock()
+	for _, d := range repairs {
+		delete(r.repairing, d.DeploymentId)
+	}
+	drifting := make(map[string]bool, len(drifts))
+	for i := range drifts {
+		d := &drifts[i]
+		if !d.redeploys() {
+			continue
+		}
+		drifting[d.DeploymentId] = true
+		if d.Action != "redeploy" || d.Attempts >= maxRedeployAttempts {
+			continue
+		}
+		if d.Repaired {
+			delete(r.redeploys, d.DeploymentId)
+			continue
+		}
+		d.Attempts++
+		if d.Attempts < maxRedeployAttempts {
+			d.RetryAt = now.Add(r.interval << d.Attempts)
+		} else {
+			log.Printf("Giving up on redeploying deployment %s (app %s) after %d failed redeploys", d.DeploymentId, d.AppId, d.Attempts)
+		}
+		r.redeploys[d.DeploymentId] = redeployAttempts{count: d.Attempts, retryAt: d.RetryAt}
+	}
+	for deploymentId := range r.redeploys {
+		if !drifting[deploymentId] && !r.repairing[deploymentId] {
+			delete(r.redeploys, deploymentId)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, d := range drifts {
+		switch {
+		case d.gaveUp:
+			// still reported every round, but only logged when giving up
+		case d.Error != "":
+			log.Printf("Could not converge %s drift of deployment %s (app %s): %s", d.Kind, d.DeploymentId, d.AppId, d.Error)
+		case d.Repaired:
+			log.Printf("Converged %s drift of deployment %s (app %s) with %s: %s", d.Kind, d.DeploymentId, d.AppId, d.Action, d.Detail)
+		}
+	}
+	report.Drifts = drifts
+	r.setReport(report)
+	return report
+}
+
+// setReport keeps the report of the latest round, a round which finishes after a later one does not replace its report
+func (r *stateReconciler) setReport(report DriftReport) {
+	r.reportMu.Lock()
+	defer r.reportMu.Unlock()
+	if report.Time.Before(r.report.Time) {
+		return
+	}
+	r.report = report
+}
+
+// detect lists the services before reading the deployments table, a deployment saved in between is at worst
+// reported as orphaned once, which is not confirmed by the next round
+func (r *stateReconciler) detect(ctx context.Context) ([]Drift, error) {
+	c := r.composer
+	services, err := r.services.ListServices(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list knative services: %w", err)
+	}
+	byName := make(map[string]KnativeService, len(services))
+	for _, svc := range services {
+		byName[svc.Name] = svc
+	}
+
+	apps, err := c.functionAppRepo.GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load function apps: %w", err)
+	}
+
+	drifts := make([]Drift, 0)
+	known := make(map[string]bool)
+	fcs := make(map[string]*FunctionComposition)
+	for _, app := range apps {
+		deployments, err := c.deploymentRepo.GetByFunctionAppID(app.Id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load deployments of app %s: %w", app.Id, err)
+		}
+		for _, dep := range deployments {
+			known[dep.Id] = true
+			fc, ok := fcs[dep.FunctionCompositionId]
+			if !ok {
+				fc, err = c.fcRepo.GetByID(dep.FunctionCompositionId)
+				if err != nil {
+					log.Printf("Could not load function composition %s of deployment %s: %v", dep.FunctionCompositionId, dep.Id, err)
+				}
+				fcs[dep.FunctionCompositionId] = fc
+			}
+			svc, exists := byName[dep.Id]
+			if d, ok := c.deploymentDrift(dep, fc, svc, exists); ok {
+				d.AppId, d.FunctionCompositionId, d.DeploymentId, d.Namespace = app.Id, dep.FunctionCompositionId, dep.Id, dep.Namespace
+				d.deployment, d.fc = dep, fc
+				drifts = append(drifts, d)
+			}
+		}
+	}
+
+	for _, svc := range services {
+		if known[svc.Name] {
+			continue
+		}
+		drifts = append(drifts, Drift{
+			Kind:                  DriftOrphaned,
+			AppId:                 svc.AppId,
+			FunctionCompositionId: svc.FunctionCompositionId,
+			DeploymentId:          svc.Name,
+			Namespace:             svc.Namespace,
+			Detail:                "knative service has no deployment",
+		})
+	}
+
+	sort.SliceStable(drifts, func(i, j int) bool {
+		if drifts[i].AppId != drifts[j].AppId {
+			return drifts[i].AppId < drifts[j].AppId
+		}
+		return drifts[i].DeploymentId < drifts[j].DeploymentId
+	})
+	return drifts, nil
+}
+
+// deploymentDrift compares the deployment with its service, deployments with a deploy in flight are left alone
+func (c *Composer) deploymentDrift(dep *Deployment, fc *FunctionComposition, svc KnativeService, exists bool) (Drift, bool) {
+	if c.deploymentInFlight(dep.Id) {
+		return Drift{}, false
+	}
+	built := fc != nil && fc.Status == BuildStatusBuilt && fc.Build.Image != ""
+
+	switch dep.Status {
+	case DeploymentStatusPending:
+		return Drift{Kind: DriftStale, Detail: "deployment is pending, but it is not being deployed"}, true
+	case DeploymentStatusWaitingForBuild:
+		if built {
+			return Drift{Kind: DriftStale, Detail: "deployment waits for a build which already finished"}, true
+		}
+		return Drift{}, false
+	}
+
+	if !exists {
+		return Drift{Kind: DriftMissing, Detail: fmt.Sprintf("deployment is %s, but its knative service does not exist", dep.Status)}, true
+	}
+	// deployments keep the image they were deployed with, a rebuild of the composition only reaches new deployments.
+	// Deployments saved before their image was recorded take over the image of their service, the composition may
+	// already be rebuilt for a source update or a version rollback which has not switched to new deployments yet.
+	image := dep.Image
+	if image == "" && svc.Image != "" {
+		image = svc.Image
+		dep.Image = svc.Image
+		if err := c.deploymentRepo.Save(dep); err != nil {
+			log.Printf("Failed to record image of deployment %s: %v", dep.Id, err)
+		}
+	}
+	if image != "" {
+		if diff := serviceDiff(dep, image, svc); diff != "" {
+			return Drift{Kind: DriftOutdated, Detail: diff}, true
+		}
+	}
+	switch {
+	case dep.Status == DeploymentStatusError && svc.Ready:
+		return Drift{Kind: DriftStatus, Detail: "deployment is in error, but its knative service is ready"}, true
+	case dep.Status == DeploymentStatusDeployed && !svc.Ready:
+		return Drift{Kind: DriftNotReady, Detail: "knative service is not ready: " + svc.Reason}, true
+	}
+	return Drift{}, false
+}
+
+// serviceDiff describes how the service differs from what deploying the deployment with image would create
+func serviceDiff(dep *Deployment, image string, svc KnativeService) string {
+	var diffs []string
+	if svc.Image != image {
+		diffs = append(diffs, fmt.Sprintf("image %s instead of %s", svc.Image, image))
+	}
+	if svc.Node != dep.Node {
+		diffs = append(diffs, fmt.Sprintf("node %s instead of %s", svc.Node, dep.Node))
+	}
+	if svc.Scale.MinReplicas != dep.Scale.MinReplicas || svc.Scale.MaxReplicas != dep.Scale.MaxReplicas {
+		diffs = append(diffs, fmt.Sprintf("scale %d-%d instead of %d-%d",
+			svc.Scale.MinReplicas, svc.Scale.MaxReplicas, dep.Scale.MinReplicas, dep.Scale.MaxReplicas))
+	}
+	if svc.Resources != dep.Resources {
+		diffs = append(diffs, fmt.Sprintf("resources %dMi/%dm instead of %dMi/%dm",
+			svc.Resources.Memory, svc.Resources.CPU, dep.Resources.Memory, dep.Resources.CPU))
+	}
+	return strings.Join(diffs, ", ")
+}
+
+// converge repairs the drift, only the not_ready drift is left to knative
+func (r *stateReconciler) converge(ctx context.Context, d *Drift) {
+	c := r.composer
+	switch d.Kind {
+	case DriftMissing, DriftOutdated, DriftStale:
+		d.Action = "redeploy"
+		if d.fc == nil || d.deployment.Image == "" && (d.fc.Status != BuildStatusBuilt || d.fc.Build.Image == "") {
+			d.Error = "function composition is not built"
+			return
+		}
+		d.deployment.Status = DeploymentStatusPending
+		if err := c.deploymentRepo.Save(d.deployment); err != nil {
+			d.Error = fmt.Sprintf("failed to save deployment: %v", err)
+			return
+		}
+		select {
+		case res := <-c.startDeployment(d.deployment, d.fc):
+			if res.Err != nil {
+				d.Error = res.Err.Error()
+				return
+			}
+		case <-ctx.Done():
+			d.Error = ctx.Err().Error()
+			return
+		}
+	case DriftOrphaned:
+		d.Action = "delete"
+		orphan := Deployment{Id: d.DeploymentId, FunctionCompositionId: d.FunctionCompositionId, Namespace: d.Namespace}
+		select {
+		case res := <-c.scheduler.AddTask(c.deleteTask(orphan), MaxRetries):
+			if res.Err != nil {
+				d.Error = res.Err.Error()
+				return
+			}
+		case <-ctx.Done():
+			d.Error = ctx.Err().Error()
+			return
+		}
+		if err := c.routingClient.DeleteRoutingTable(d.DeploymentId); err != nil {
+			log.Printf("Failed to delete routing table of orphaned deployment %s: %v", d.DeploymentId, err)
+		}
+	case DriftStatus:
+		d.Action = "update_status"
+		d.deployment.Status = DeploymentStatusDeployed
+		d.deployment.StatusReason = ""
+		if err := c.deploymentRepo.Save(d.deployment); err != nil {
+			d.Error = fmt.Sprintf("failed to save deployment: %v", err)
+			return
+		}
+	default:
+		return
+	}
+	d.Repaired = true
+}
@@ -1,5 +1,7 @@
 package core
 
+import "time"
+
 type FunctionAppRepository interface {
 	Save(app *FunctionApp) error
 	GetByID(id string) (*FunctionApp, error)
@@ -20,3 +22,37 @@ type DeploymentRepository interface {
 	GetByFunctionAppID(functionAppID string) ([]*Deployment, error)
 	Delete(id string) error
 }
+
+type ReconfigurationRepository interface {
+	Save(record *ReconfigurationRecord) error
+	// GetByFunctionAppID returns the records started within [from, to], ordered by start time, zero values leave the range open
+	GetByFunctionAppID(functionAppID string, from, to time.Time) ([]*ReconfigurationRecord, error)
+	GetInProgress() ([]*ReconfigurationRecord, error)
+}
+
+type WebhookRepository interface {
+	SaveSubscription(sub *WebhookSubscription) error
+	GetSubscriptions() ([]*WebhookSubscription, error)
+	// DeleteSubscription removes the subscription together with its delivery attempts
+	DeleteSubscription(id string) error
+	SaveDelivery(delivery *WebhookDelivery) error
+	// GetDeliveries returns the latest delivery attempts of the subscription, newest first
+	GetDeliveries(subscriptionId string, limit int) ([]*WebhookDelivery, error)
+}
+
+type AppVersionRepository interface {
+	Save(version *AppVersion) error
+	// GetByFunctionAppID returns the versions of the app, ordered by version number
+	GetByFunctionAppID(functionAppID string) ([]*AppVersion, error)
+}
+
+type BuildCacheRepository interface {
+	// Get returns the image cached under the key, nil if there is none
+	Get(key string) (*BuildCacheEntry, error)
+	Save(entry *BuildCacheEntry) error
+}
+
+type ControllerStateRepository interface {
+	Save(state *ControllerState) error
+	GetAll() ([]*ControllerState, error)
+}
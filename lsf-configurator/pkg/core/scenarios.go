@@ -2,27 +2,57 @@ package core
 
 import (
 	"fmt"
+	"math"
+	"reflect"
 	"sort"
+	"strconv"
 )
 
 const (
 	LayoutKeyMin = "min_rate"
-	// LayoutKeyAvg = "avg_rate"
 	LayoutKeyMax = "max_rate"
 )
 
-var layoutUpgradePath = map[string]string{
-	// LayoutKeyMin: LayoutKeyAvg,
-	// LayoutKeyAvg: LayoutKeyMax,
-	LayoutKeyMin: LayoutKeyMax,
-	LayoutKeyMax: "",
+// DefaultRateLevels are used when an app does not declare its own rate levels.
+// A rate level is a fraction between InvocationRate.Min (0) and InvocationRate.Max (1) of every link.
+var DefaultRateLevels = []float64{0, 1}
+
+// NormalizeRateLevels validates rate levels and returns them sorted and without duplicates
+func NormalizeRateLevels(levels []float64) ([]float64, error) {
+	if len(levels) == 0 {
+		return DefaultRateLevels, nil
+	}
+
+	seen := make(map[float64]bool)
+	normalized := make([]float64, 0, len(levels))
+	for _, l := range levels {
+		if l < 0 || l > 1 {
+			return nil, fmt.Errorf("rate level %v is out of range, it must be between 0 and 1", l)
+		}
+		l = math.Round(l*1000) / 1000
+		if seen[l] {
+			continue
+		}
+		seen[l] = true
+		normalized = append(normalized, l)
+	}
+	sort.Float64s(normalized)
+	return normalized, nil
+}
+
+func layoutKeyForRateLevel(level float64) string {
+	switch level {
+	case 0:
+		return LayoutKeyMin
+	case 1:
+		return LayoutKeyMax
+	default:
+		return "rate_p" + strconv.FormatFloat(level*100, 'f', -1, 64)
+	}
 }
 
-var layoutDowngradePath = map[string]string{
-	// LayoutKeyMax: LayoutKeyAvg,
-	// LayoutKeyAvg: LayoutKeyMin,
-	LayoutKeyMax: LayoutKeyMin,
-	LayoutKeyMin: "",
+func rateAtLevel(level float64) func(min, max float64) float64 {
+	return func(min, max float64) float64 { return min + (max-min)*level }
 }
 
 type scenarioManager struct {
@@ -51,41 +81,40 @@ func NewScenarioManager(calculator LayoutCalculator, targetConcurrency int, invo
 func (sm *scenarioManager) GenerateLayoutCandidates(
 	components []Component,
 	links []ComponentLink,
+	rateLevels []float64,
 	appLatencyReq int,
-	memoryAvailable int) (map[string]Layout, error) {
-	rates := []struct {
-		Name     string
-		Key      string
-		RateFunc func(min, max float64) float64
-	}{
-		{
-			Name:     "Minimum Rate Layout",
-			Key:      LayoutKeyMin,
-			RateFunc: func(min, max float64) float64 { return min },
-		},
-		// {
-		// 	Name:     "Average Rate Layout",
-		// 	Key:      LayoutKeyAvg,
-		// 	RateFunc: func(min, max float64) float64 { return (min + max) * 0.5 },
-		// },
-		{
-			Name:     "Maximum Rate Layout",
-			Key:      LayoutKeyMax,
-			RateFunc: func(min, max float64) float64 { return max },
-		},
-	}
-
-	candidates := make(map[string]Layout, len(rates))
+	pathLatencyReqs map[string]int,
+	memoryAvailable int,
+	freeMemory map[string]int) (map[string]Layout, []LayoutLevel, error) {
+	levels, err := NormalizeRateLevels(rateLevels)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	candidates := make(map[string]Layout, len(levels))
+	ladder := make([]LayoutLevel, 0, len(levels))
 
 	compMap := make(map[string]Component)
 	for _, c := range components {
 		compMap[c.Name] = c
 	}
 
-	for _, r := range rates {
-		layoutScenario := sm.buildLayoutScenario(compMap, links, r.RateFunc)
+	// no composition can be larger than what is left on the emptiest node
+	if len(freeMemory) > 0 {
+		largestFree := 0
+		for _, free := range freeMemory {
+			largestFree = max(largestFree, free)
+		}
+		memoryAvailable = min(memoryAvailable, largestFree)
+	}
+
+	for _, level := range levels {
+		key := layoutKeyForRateLevel(level)
+		layoutScenario := sm.buildLayoutScenario(compMap, links, rateAtLevel(level))
 		layoutScenario.LatencyRequirement = appLatencyReq
+		layoutScenario.PathLatencyRequirements = pathLatencyReqs
 		layoutScenario.AvailableNodeMemory = memoryAvailable
+		layoutScenario.NodeFreeMemory = freeMemory
 		layoutScenario.TargetConcurrency = sm.targetConcurrency
 		layoutScenario.InvocationSharedMemoryRatio = sm.invocationSharedMemoryRatio
 		layoutScenario.ComponentMCPUAllocation = sm.componentMCPUAllocation
@@ -95,11 +124,25 @@ func (sm *scenarioManager) GenerateLayoutCandidates(
 
 		layout, err := sm.calculator.CalculateLayout(*layoutScenario)
 		if err != nil {
-			return nil, fmt.Errorf("failed to calculate layout for %s: %w", r.Name, err)
+			return nil, nil, fmt.Errorf("failed to calculate layout for rate level %s: %w", key, err)
+		}
+
+		var ingressRate float64
+		if len(layoutScenario.Links) > 0 {
+			ingressRate = layoutScenario.Links[0].InvocationRate
+		}
+
+		// If the previous level produced the same layout, this level supersedes it,
+		// since the layout is able to handle the higher rate as well
+		if n := len(ladder); n > 0 && reflect.DeepEqual(candidates[ladder[n-1].Key], layout) {
+			delete(candidates, ladder[n-1].Key)
+			ladder = ladder[:n-1]
 		}
-		candidates[r.Key] = layout
+
+		candidates[key] = layout
+		ladder = append(ladder, LayoutLevel{Key: key, RateLevel: level, IngressRate: ingressRate})
 	}
-	return candidates, nil
+	return candidates, ladder, nil
 }
 
 func (sm *scenarioManager) buildLayoutScenario(
@@ -246,3 +289,49 @@ func sortComponentsByCallGraphOrder(compMap map[string]Component, sortedLinks []
 
 	return componentOrder
 }
+
+// Ladder returns the layout levels of the app ordered from the lowest to the highest rate level.
+// Apps registered before layout ladders were introduced only have the min and max rate layouts.
+func (app *FunctionApp) Ladder() []LayoutLevel {
+	if len(app.LayoutLadder) > 0 {
+		return app.LayoutLadder
+	}
+	ladder := make([]LayoutLevel, 0, len(DefaultRateLevels))
+	for _, level := range DefaultRateLevels {
+		key := layoutKeyForRateLevel(level)
+		if _, ok := app.LayoutCandidates[key]; ok {
+			ladder = append(ladder, LayoutLevel{Key: key, RateLevel: level})
+		}
+	}
+	return ladder
+}
+
+// adjacentLayoutKey returns the key of the layout step levels away from the active one,
+// or an empty string if there is no such level
+func (app *FunctionApp) adjacentLayoutKey(step int) string {
+	i := app.layoutLevel(app.ActiveLayoutKey)
+	if i < 0 {
+		return ""
+	}
+	ladder := app.Ladder()
+	next := i + step
+	if next < 0 || next >= len(ladder) {
+		return ""
+	}
+	return ladder[next].Key
+}
+
+// layoutLevel returns the position of the layout on the app's ladder, or -1 if it is not on it
+func (app *FunctionApp) layoutLevel(key string) int {
+	for i, level := range app.Ladder() {
+		if level.Key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func (app *FunctionApp) isLowestLayout() bool {
+	ladder := app.Ladder()
+	return len(ladder) == 0 || ladder[0].Key == app.ActiveLayoutKey
+}
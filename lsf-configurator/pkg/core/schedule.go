@@ -0,0 +1,159 @@
+package core
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// SchedulePolicy is recorded as the policy of reconfigurations triggered by the app's schedule
+const SchedulePolicy = "schedule"
+
+// scheduleLookbacks are the windows searched for the latest activation of a schedule entry, shortest first
+var scheduleLookbacks = []time.Duration{time.Hour, 24 * time.Hour, 8 * 24 * time.Hour, 32 * 24 * time.Hour, 367 * 24 * time.Hour}
+
+// ScheduleEntry selects the lowest layout of the app from the time its cron expression fires until another entry fires.
+// The layout is given by its key, or by a rate level selecting the lowest layout calculated for at least that level.
+type ScheduleEntry struct {
+	Cron      string   `json:"cron"` // standard 5 field expression, prefix it with CRON_TZ=<zone> to use another time zone than the configurator's
+	LayoutKey string   `json:"layout_key,omitempty"`
+	RateLevel *float64 `json:"rate_level,omitempty"`
+}
+
+// ValidateSchedule checks that every entry has a valid cron expression and selects one of the app's layouts
+func (app *FunctionApp) ValidateSchedule(schedule []ScheduleEntry) error {
+	for i, entry := range schedule {
+		if _, err := cron.ParseStandard(entry.Cron); err != nil {
+			return fmt.Errorf("invalid cron expression %q in schedule entry %d: %w", entry.Cron, i, err)
+		}
+		switch {
+		case (entry.LayoutKey == "") == (entry.RateLevel == nil):
+			return fmt.Errorf("schedule entry %d must set exactly one of layout_key and rate_level", i)
+		case entry.RateLevel != nil && (*entry.RateLevel < 0 || *entry.RateLevel > 1):
+			return fmt.Errorf("rate_level of schedule entry %d must be between 0 and 1", i)
+		case entry.LayoutKey != "" && app.layoutLevel(entry.LayoutKey) < 0:
+			return fmt.Errorf("layout %s of schedule entry %d is not on the layout ladder of app %s", entry.LayoutKey, i, app.Id)
+		}
+	}
+	return nil
+}
+
+// lastActivation returns the latest time up to now the cron expression of the entry fired, false if it did not fire within a year
+func (e ScheduleEntry) lastActivation(now time.Time) (time.Time, bool, error) {
+	schedule, err := cron.ParseStandard(e.Cron)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	for _, lookback := range scheduleLookbacks {
+		last := schedule.Next(now.Add(-lookback))
+		if last.IsZero() || last.After(now) {
+			continue
+		}
+		for next := schedule.Next(last); !next.IsZero() && !next.After(now); next = schedule.Next(last) {
+			last = next
+		}
+		return last, true, nil
+	}
+	return time.Time{}, false, nil
+}
+
+// scheduleLevel returns the position of the layout selected by the entry on the app's ladder, or -1 if it is not on it
+func (app *FunctionApp) scheduleLevel(entry ScheduleEntry) int {
+	if entry.RateLevel == nil {
+		return app.layoutLevel(entry.LayoutKey)
+	}
+	ladder := app.Ladder()
+	for i, level := range ladder {
+		if level.RateLevel >= *entry.RateLevel {
+			return i
+		}
+	}
+	return len(ladder) - 1
+}
+
+// scheduledLevel returns the ladder position selected by the entry which fired last, or -1 if no entry applies at now
+func (app *FunctionApp) scheduledLevel(now time.Time) (int, ScheduleEntry) {
+	floor, latest := -1, time.Time{}
+	var selected ScheduleEntry
+	for _, entry := range app.Schedule {
+		activation, ok, err := entry.lastActivation(now)
+		if err != nil {
+			log.Printf("Skipping schedule entry %q of app %s: %v", entry.Cron, app.Id, err)
+			continue
+		}
+		level := app.scheduleLevel(entry)
+		if !ok || level < 0 {
+			continue
+		}
+		// entries firing at the same time resolve to the higher layout
+		if activation.After(latest) || (activation.Equal(latest) && level > floor) {
+			floor, latest, selected = level, activation, entry
+		}
+	}
+	return floor, selected
+}
+
+// scheduleDecision keeps the app at or above the layout its schedule selects: the app is upgraded to it, downgrades
+// stop at it and the policy is free to upgrade beyond it. When the schedule lowers the layout, an app still on the
+// layout the schedule selected before is downgraded with it, apps the policy moved elsewhere are left to the policy.
+func (app *FunctionApp) scheduleDecision(decision PolicyDecision, history *PolicyHistory, now time.Time) (PolicyDecision, bool) {
+	active := app.layoutLevel(app.ActiveLayoutKey)
+	if len(app.Schedule) == 0 || active < 0 {
+		history.ScheduledLayoutKey = ""
+		return decision, false
+	}
+	floor, entry := app.scheduledLevel(now)
+	if floor < 0 {
+		history.ScheduledLayoutKey = ""
+		return decision, false
+	}
+	ladder := app.Ladder()
+	previous := app.layoutLevel(history.ScheduledLayoutKey)
+
+	switch decision.Action {
+	case ActionUpgrade:
+		to := active + 1
+		if decision.TargetLayoutKey != "" {
+			to = app.layoutLevel(decision.TargetLayoutKey)
+		}
+		if floor > to {
+			decision.TargetLayoutKey = ladder[floor].Key
+		}
+		return decision, false
+	case ActionDowngrade:
+		to := active - 1
+		if decision.TargetLayoutKey != "" {
+			to = app.layoutLevel(decision.TargetLayoutKey)
+		}
+		if floor >= active {
+			history.ScheduledLayoutKey = ladder[floor].Key
+			return hold(), false
+		}
+		if floor > to {
+			decision.TargetLayoutKey = ladder[floor].Key
+		}
+		return decision, false
+	case ActionRollback:
+		return decision, false
+	}
+
+	// the selected layout is only remembered once the app reached it, so a postponed transition is retried
+	switch {
+	case floor > active:
+		return PolicyDecision{
+			Action:          ActionUpgrade,
+			Reason:          fmt.Sprintf("schedule %q selects layout %s", entry.Cron, ladder[floor].Key),
+			TargetLayoutKey: ladder[floor].Key,
+		}, true
+	case floor < active && previous == active:
+		return PolicyDecision{
+			Action:          ActionDowngrade,
+			Reason:          fmt.Sprintf("schedule %q lowers layout %s to %s", entry.Cron, app.ActiveLayoutKey, ladder[floor].Key),
+			TargetLayoutKey: ladder[floor].Key,
+		}, true
+	}
+	history.ScheduledLayoutKey = ladder[floor].Key
+	return decision, false
+}
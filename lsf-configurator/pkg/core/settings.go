@@ -0,0 +1,127 @@
+package core
+
+import (
+	"fmt"
+	"time"
+)
+
+// ControllerSettings tunes the latency controller. The global defaults come from the configuration,
+// apps can override any of them. Settings for which 0 is a valid value are pointers, nil falls back to the default.
+// The other fields fall back to the default when left at their zero value, which is not a valid setting for them.
+type ControllerSettings struct {
+	CooldownSeconds      *int       `json:"cooldown_seconds,omitempty"`
+	DowngradeFactor      *float64   `json:"downgrade_factor,omitempty"`
+	MetricType           MetricType `json:"metric_type,omitempty"`
+	MetricQueryTimeRange string     `json:"metric_query_time_range,omitempty"` // Elasticsearch date math, e.g. now-5m
+	MinimalTraceCount    *int       `json:"minimal_trace_count,omitempty"`
+	// In dry-run mode the controller only records the reconfigurations it would make, nil means the global setting applies
+	DryRun *bool `json:"dry_run,omitempty"`
+	// Latency SLO used by the slo_burn_rate policy: SLOTarget of the requests finish within LatencyLimit over SLOPeriodDays.
+	// Neither can be 0, leaving them out falls back to the defaults.
+	SLOTarget     float64 `json:"slo_target,omitempty"`
+	SLOPeriodDays int     `json:"slo_period_days,omitempty"`
+	// The slo_burn_rate policy downgrades once the error budget burns slower than this in every short window and the slow long window
+	SLODowngradeBurnRate *float64 `json:"slo_downgrade_burn_rate,omitempty"`
+	// How far ahead the arrival rate is forecast to upgrade before the latency degrades, 0 disables forecasting
+	ForecastHorizonSeconds *int `json:"forecast_horizon_seconds,omitempty"`
+	// Share of failed or unfinished requests above which the app is considered failing
+	ErrorRateThreshold *float64 `json:"error_rate_threshold,omitempty"`
+}
+
+func (s ControllerSettings) Validate() error {
+	if s.CooldownSeconds != nil && *s.CooldownSeconds < 0 {
+		return fmt.Errorf("cooldown_seconds must not be negative")
+	}
+	if s.DowngradeFactor != nil && (*s.DowngradeFactor < 0 || *s.DowngradeFactor >= 1) {
+		return fmt.Errorf("downgrade_factor must be between 0 and 1")
+	}
+	if s.MetricType != "" && s.MetricType != MetricTypeP95 && s.MetricType != MetricTypeAverage {
+		return fmt.Errorf("invalid metric_type %s, supported values are %s and %s", s.MetricType, MetricTypeP95, MetricTypeAverage)
+	}
+	if s.MinimalTraceCount != nil && *s.MinimalTraceCount < 0 {
+		return fmt.Errorf("minimal_trace_count must not be negative")
+	}
+	if s.SLOTarget < 0 || s.SLOTarget >= 1 {
+		return fmt.Errorf("slo_target must be between 0 and 1")
+	}
+	if s.SLOPeriodDays < 0 {
+		return fmt.Errorf("slo_period_days must not be negative")
+	}
+	if s.SLODowngradeBurnRate != nil && (*s.SLODowngradeBurnRate < 0 || *s.SLODowngradeBurnRate >= 1) {
+		return fmt.Errorf("slo_downgrade_burn_rate must be between 0 and 1")
+	}
+	if s.ForecastHorizonSeconds != nil && *s.ForecastHorizonSeconds < 0 {
+		return fmt.Errorf("forecast_horizon_seconds must not be negative")
+	}
+	if s.ErrorRateThreshold != nil && (*s.ErrorRateThreshold < 0 || *s.ErrorRateThreshold >= 1) {
+		return fmt.Errorf("error_rate_threshold must be between 0 and 1")
+	}
+	return nil
+}
+
+// withDefaults returns the settings with every unset field taken from defaults
+func (s *ControllerSettings) withDefaults(defaults ControllerSettings) ControllerSettings {
+	if s == nil {
+		return defaults
+	}
+	merged := *s
+	if merged.CooldownSeconds == nil {
+		merged.CooldownSeconds = defaults.CooldownSeconds
+	}
+	if merged.DowngradeFactor == nil {
+		merged.DowngradeFactor = defaults.DowngradeFactor
+	}
+	if merged.MetricType == "" {
+		merged.MetricType = defaults.MetricType
+	}
+	if merged.MetricQueryTimeRange == "" {
+		merged.MetricQueryTimeRange = defaults.MetricQueryTimeRange
+	}
+	if merged.MinimalTraceCount == nil {
+		merged.MinimalTraceCount = defaults.MinimalTraceCount
+	}
+	if merged.DryRun == nil {
+		merged.DryRun = defaults.DryRun
+	}
+	if merged.SLOTarget == 0 {
+		merged.SLOTarget = defaults.SLOTarget
+	}
+	if merged.SLOPeriodDays == 0 {
+		merged.SLOPeriodDays = defaults.SLOPeriodDays
+	}
+	if merged.SLODowngradeBurnRate == nil {
+		merged.SLODowngradeBurnRate = defaults.SLODowngradeBurnRate
+	}
+	if merged.ForecastHorizonSeconds == nil {
+		merged.ForecastHorizonSeconds = defaults.ForecastHorizonSeconds
+	}
+	if merged.ErrorRateThreshold == nil {
+		merged.ErrorRateThreshold = defaults.ErrorRateThreshold
+	}
+	return merged
+}
+
+func (s ControllerSettings) dryRun() bool {
+	return s.DryRun != nil && *s.DryRun
+}
+
+func (s ControllerSettings) cooldown() time.Duration {
+	return time.Duration(value(s.CooldownSeconds)) * time.Second
+}
+
+func (s ControllerSettings) forecastHorizon() time.Duration {
+	return time.Duration(value(s.ForecastHorizonSeconds)) * time.Second
+}
+
+func (s ControllerSettings) sloPeriod() time.Duration {
+	return time.Duration(s.SLOPeriodDays) * 24 * time.Hour
+}
+
+// value returns the setting p points to, or the zero value if it is neither set by the app nor by the defaults
+func value[T any](p *T) T {
+	if p == nil {
+		var zero T
+		return zero
+	}
+	return *p
+}
@@ -0,0 +1,164 @@
+package core
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"time"
+)
+
+// sloDowngradeIntervals is the number of consecutive intervals with a low burn rate required for a downgrade
+const sloDowngradeIntervals = 60
+
+// burnRateRefreshInterval is how often the windows other than the fast short one are queried again,
+// the violation ratio over 30 minutes or more hardly changes from one interval to the next
+const burnRateRefreshInterval = time.Minute
+
+// burnRateWindow is a multi-window burn rate check, it fires when the burn rate exceeds its threshold over both windows.
+// The long window makes sure a meaningful part of the budget was consumed, the short one that the burn is still going on.
+type burnRateWindow struct {
+	long, short   string        // Elasticsearch date math
+	duration      time.Duration // length of the long window
+	shortDuration time.Duration // length of the short window
+	budgetShare   float64       // share of the error budget which may be consumed within the long window
+}
+
+var (
+	fastBurnWindow = burnRateWindow{long: "now-1h", short: "now-5m", duration: time.Hour, shortDuration: 5 * time.Minute, budgetShare: 0.02}
+	slowBurnWindow = burnRateWindow{long: "now-6h", short: "now-30m", duration: 6 * time.Hour, shortDuration: 30 * time.Minute, budgetShare: 0.05}
+)
+
+// threshold returns the burn rate at which the window consumes its budget share, e.g. 14.4 for the fast window of a 30 day SLO
+func (w burnRateWindow) threshold(period time.Duration) float64 {
+	return w.budgetShare * period.Hours() / w.duration.Hours()
+}
+
+// settled reports whether the short window only covers requests served since the last reconfiguration. The long window
+// stays above the threshold long after an upgrade, so only a short window which is still hot on the new layout confirms the burn.
+func (w burnRateWindow) settled(lastReconfig, now time.Time) bool {
+	return lastReconfig.IsZero() || now.Sub(lastReconfig) >= w.shortDuration
+}
+
+// BurnRates are the rates the error budget of an app's latency SLO is consumed at, 1 means the budget lasts exactly the SLO period
+type BurnRates struct {
+	FastLong   float64
+	FastShort  float64
+	SlowLong   float64
+	SlowShort  float64
+	TraceCount int // number of traces in the short window of the fast check
+}
+
+// sloBurnRatePolicy upgrades when either the fast or the slow multi-window check detects the error budget of the latency SLO burning,
+// and downgrades after the burn rate stayed below SLODowngradeBurnRate in every short window and the slow long window for sloDowngradeIntervals intervals
+type sloBurnRatePolicy struct{}
+
+func (p *sloBurnRatePolicy) Name() string { return PolicySLO }
+
+func (p *sloBurnRatePolicy) Decide(in PolicyInput) PolicyDecision {
+	b := in.BurnRates
+	if b == nil || in.SLOPeriod <= 0 {
+		return hold()
+	}
+	h := in.History
+	fast := fastBurnWindow.threshold(in.SLOPeriod)
+	slow := slowBurnWindow.threshold(in.SLOPeriod)
+
+	switch {
+	case b.FastLong > fast && b.FastShort > fast && fastBurnWindow.settled(h.LastReconfig, in.Now):
+		if b.TraceCount < in.MinTraceCount {
+			return hold()
+		}
+		h.ConsecutiveDowngradeEligible = 0
+		return PolicyDecision{
+			Action: ActionUpgrade,
+			Reason: fmt.Sprintf("fast error budget burn %.1f/%.1f over %s/%s exceeds %.1f", b.FastLong, b.FastShort, fastBurnWindow.long, fastBurnWindow.short, fast),
+		}
+	case b.SlowLong > slow && b.SlowShort > slow && slowBurnWindow.settled(h.LastReconfig, in.Now):
+		if b.TraceCount < in.MinTraceCount {
+			return hold()
+		}
+		h.ConsecutiveDowngradeEligible = 0
+		return PolicyDecision{
+			Action: ActionUpgrade,
+			Reason: fmt.Sprintf("slow error budget burn %.1f/%.1f over %s/%s exceeds %.1f", b.SlowLong, b.SlowShort, slowBurnWindow.long, slowBurnWindow.short, slow),
+		}
+	case b.SlowLong < in.SLODowngradeBurnRate && b.SlowShort < in.SLODowngradeBurnRate && b.FastShort < in.SLODowngradeBurnRate:
+		h.ConsecutiveDowngradeEligible++
+		if h.ConsecutiveDowngradeEligible < sloDowngradeIntervals {
+			return hold()
+		}
+		h.ConsecutiveDowngradeEligible = 0
+		return PolicyDecision{
+			Action: ActionDowngrade,
+			Reason: fmt.Sprintf("error budget burn below %.2f for %d consecutive intervals", in.SLODowngradeBurnRate, sloDowngradeIntervals),
+		}
+	default:
+		h.ConsecutiveDowngradeEligible = 0
+		return hold()
+	}
+}
+
+// violationRatios are the latency violation ratios of the apps over a window, as last queried
+type violationRatios struct {
+	ratios    map[string]float64
+	limits    map[string]int // the latency limits the ratios were queried with
+	queriedAt time.Time
+}
+
+// queryBurnRates returns the burn rates of the apps using the slo_burn_rate policy, apps are missing if their burn rates could not be queried.
+// Only the fast short window is queried every interval, the others every burnRateRefreshInterval or when the latency limits changed.
+func (c *latencyController) queryBurnRates(apps []*FunctionApp) map[string]*BurnRates {
+	limits := make(map[string]int)
+	budgets := make(map[string]float64)
+	for _, app := range apps {
+		if app.ControllerPolicy != PolicySLO || app.ControllerPaused || app.LatencyLimit <= 0 {
+			continue
+		}
+		settings := app.ControllerSettings.withDefaults(c.defaults)
+		if settings.SLOTarget <= 0 || settings.SLOTarget >= 1 {
+			log.Printf("Warning: app %s uses the %s policy without a valid SLO target", app.Id, PolicySLO)
+			continue
+		}
+		limits[app.Id] = app.LatencyLimit
+		budgets[app.Id] = 1 - settings.SLOTarget
+	}
+	if len(limits) == 0 {
+		return nil
+	}
+
+	now := c.clock.Now()
+	ranges := []string{fastBurnWindow.long, fastBurnWindow.short, slowBurnWindow.long, slowBurnWindow.short}
+	ratios := make([]map[string]float64, len(ranges))
+	var traceCounts map[string]int
+	for i, timeRange := range ranges {
+		cached, ok := c.violations[timeRange]
+		if ok && now.Sub(cached.queriedAt) < burnRateRefreshInterval && maps.Equal(cached.limits, limits) {
+			ratios[i] = cached.ratios
+			continue
+		}
+		r, counts, err := c.metrics.QueryLatencyViolations(timeRange, limits)
+		if err != nil {
+			log.Printf("Error querying latency violations over %s: %v", timeRange, err)
+			return nil
+		}
+		ratios[i] = r
+		if timeRange == fastBurnWindow.short {
+			traceCounts = counts
+			continue
+		}
+		c.violations[timeRange] = &violationRatios{ratios: r, limits: limits, queriedAt: now}
+	}
+
+	burnRates := make(map[string]*BurnRates, len(limits))
+	for appId, budget := range budgets {
+		// apps without traces in a window did not consume any budget in it
+		burnRates[appId] = &BurnRates{
+			FastLong:   ratios[0][appId] / budget,
+			FastShort:  ratios[1][appId] / budget,
+			SlowLong:   ratios[2][appId] / budget,
+			SlowShort:  ratios[3][appId] / budget,
+			TraceCount: traceCounts[appId],
+		}
+	}
+	return burnRates
+}
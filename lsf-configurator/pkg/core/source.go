@@ -0,0 +1,430 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"lsf-configurator/pkg/filesystem"
+	"lsf-configurator/pkg/uuid"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	sourceRevisionsDir = "revisions"      // revisions uploaded after the app was created are stored here under SourcePath
+	sourceBuildTimeout = 30 * time.Minute // time a rebuild has to finish before the source update is abandoned
+)
+
+// ErrReconfigurationInProgress is returned when a change of the app has to wait for the reconfiguration in progress
+var ErrReconfigurationInProgress = errors.New("a reconfiguration of the app is in progress")
+
+// SourceDir returns the directory of the source revision the app's compositions are built from
+func (app *FunctionApp) SourceDir() string {
+	return app.sourceRevisionDir(app.SourceRevision)
+}
+
+// sourceRevisionDir returns the directory of a source revision, revision 0 is kept directly in SourcePath
+func (app *FunctionApp) sourceRevisionDir(revision int) string {
+	if revision == 0 {
+		return app.SourcePath
+	}
+	return filepath.Join(app.SourcePath, sourceRevisionsDir, strconv.Itoa(revision))
+}
+
+// sourceRevision is a source revision stored for an app, which becomes the app's revision once its compositions are built
+type sourceRevision struct {
+	revision      int
+	previous      int // revision it replaces
+	dir           string
+	files         []string        // non-component files of the revision
+	previousFiles []string        // non-component files of the revision it replaces
+	changed       map[string]bool // files which differ from the revision it replaces
+}
+
+// storeSourceRevision stores the uploaded files on top of the app's current source as its next source revision,
+// files which are not uploaded are carried over. An error is returned if no file differs from the current revision.
+func (c *Composer) storeSourceRevision(app *FunctionApp, files []*multipart.FileHeader) (*sourceRevision, error) {
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files were uploaded")
+	}
+	for _, fileHeader := range files {
+		fileName := fileHeader.Filename
+		if fileName != filepath.Base(fileName) {
+			return nil, fmt.Errorf("file name %s must not contain a path", fileName)
+		}
+		if isComponent(fileName, app.Runtime) {
+			componentName := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+			if !containsComponent(app.Components, componentName) {
+				return nil, fmt.Errorf("component file %s does not match any declared component", fileName)
+			}
+		}
+	}
+
+	current := app.SourceDir()
+	rev := &sourceRevision{
+		revision:      nextSourceRevision(app),
+		previous:      app.SourceRevision,
+		previousFiles: app.Files,
+		files:         slices.Clone(app.Files),
+		changed:       make(map[string]bool),
+	}
+	rev.dir = app.sourceRevisionDir(rev.revision)
+	if err := filesystem.CreateDir(rev.dir); err != nil {
+		return nil, fmt.Errorf("could not create directory for source revision %d: %w", rev.revision, err)
+	}
+
+	entries, err := os.ReadDir(current)
+	if err != nil {
+		c.discardSourceRevision(rev)
+		return nil, fmt.Errorf("could not read source revision %d: %w", app.SourceRevision, err)
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		if _, err := filesystem.CopyFileToDstFolder(filepath.Join(current, entry.Name()), rev.dir); err != nil {
+			c.discardSourceRevision(rev)
+			return nil, err
+		}
+	}
+
+	for _, fileHeader := range files {
+		fileName := fileHeader.Filename
+		previous, readErr := os.ReadFile(filepath.Join(current, fileName))
+		if err := filesystem.SaveMultiPartFile(fileHeader, rev.dir); err != nil {
+			c.discardSourceRevision(rev)
+			return nil, err
+		}
+		uploaded, err := os.ReadFile(filepath.Join(rev.dir, fileName))
+		if err != nil {
+			c.discardSourceRevision(rev)
+			return nil, err
+		}
+		if readErr != nil || !bytes.Equal(previous, uploaded) {
+			rev.changed[fileName] = true
+		}
+		if !isComponent(fileName, app.Runtime) && !slices.Contains(rev.files, fileName) {
+			rev.files = append(rev.files, fileName)
+		}
+	}
+
+	if len(rev.changed) == 0 {
+		c.discardSourceRevision(rev)
+		return nil, fmt.Errorf("the uploaded files do not differ from source revision %d", app.SourceRevision)
+	}
+	return rev, nil
+}
+
+// nextSourceRevision numbers a new revision after every revision stored so far. After a rollback the app may run an
+// older revision than the latest one, whose directory must be kept for the versions built from it.
+func nextSourceRevision(app *FunctionApp) int {
+	latest := app.SourceRevision
+	entries, err := os.ReadDir(filepath.Join(app.SourcePath, sourceRevisionsDir))
+	if err != nil {
+		return latest + 1
+	}
+	for _, entry := range entries {
+		if revision, err := strconv.Atoi(entry.Name()); err == nil && entry.IsDir() {
+			latest = max(latest, revision)
+		}
+	}
+	return latest + 1
+}
+
+func (c *Composer) discardSourceRevision(rev *sourceRevision) {
+	if err := filesystem.DeleteDir(rev.dir); err != nil {
+		log.Printf("Could not delete source revision directory %s: %v", rev.dir, err)
+	}
+}
+
+// setSourceRevision makes the revision the one new compositions of the app are built from
+func (c *Composer) setSourceRevision(appId string, revision int, files []string) error {
+	app, err := c.functionAppRepo.GetByID(appId)
+	if err != nil {
+		return err
+	}
+	if app == nil {
+		return fmt.Errorf("function app %s not found", appId)
+	}
+	app.SourceRevision = revision
+	app.Files = files
+	return c.functionAppRepo.Save(app)
+}
+
+// affectedCompositions returns the compositions whose image depends on one of the changed files. Files which are
+// neither a component nor belong to one, like the requirements of the app, are shared by every composition.
+func (app *FunctionApp) affectedCompositions(changed map[string]bool) []*FunctionComposition {
+	ext := runtimeExtensions[app.Runtime]
+	owned := make(map[string]bool)
+	for _, comp := range app.Components {
+		owned[comp.Name+ext] = true
+		for _, f := range comp.Files {
+			owned[f] = true
+		}
+	}
+	shared := false
+	for name := range changed {
+		if !owned[name] {
+			shared = true
+		}
+	}
+
+	var affected []*FunctionComposition
+	for _, fc := range app.Compositions {
+		uses := shared
+		for _, comp := range fc.Components {
+			uses = uses || changed[comp+ext]
+		}
+		for _, f := range fc.Files {
+			uses = uses || changed[f]
+		}
+		if uses {
+			affected = append(affected, fc)
+		}
+	}
+	return affected
+}
+
+// rebuild is a build of a composition started by rebuildFunctionComposition, waiting for the build notification
+type rebuild struct {
+	result   chan Result
+	revision int
+	cacheKey string
+}
+
+// rebuildFunctionComposition builds the composition again from a source revision of its app. The composition keeps
+// serving its current image until the build finishes, the result is sent once the new image is saved.
+// The caller stops waiting for an unfinished rebuild with cancelRebuild.
+func (c *Composer) rebuildFunctionComposition(fc *FunctionComposition, runtime string, revision int, sourceDir string) *rebuild {
+	pending := &rebuild{result: make(chan Result, 1), revision: revision}
+	target := *fc
+	target.Build.SourceRevision = revision
+	key, cached := c.cachedBuild(&target, runtime, sourceDir)
+	if cached != nil {
+		fc.Build = Build{Image: cached.Image, Timestamp: createBuildTimestamp(), SourceRevision: revision, CacheKey: key}
+		fc.Status = BuildStatusBuilt
+		if err := c.fcRepo.Save(fc); err != nil {
+			pending.result <- Result{Err: fmt.Errorf("failed to save function composition %s: %w", fc.Id, err)}
+			return pending
+		}
+		log.Printf("Reusing cached image %v for function composition with id %v", cached.Image, fc.Id)
+		pending.result <- Result{Value: fc}
+		return pending
+	}
+	pending.cacheKey = key
+
+	c.mu.Lock()
+	if c.rebuilds == nil {
+		c.rebuilds = make(map[string]*rebuild)
+	}
+	c.rebuilds[fc.Id] = pending
+	c.mu.Unlock()
+
+	go func() {
+		r := <-c.scheduler.AddTask(c.buildTask(target, runtime, sourceDir), MaxRetries)
+		if r.Err != nil && c.cancelRebuild(fc.Id, pending) {
+			log.Printf("Rebuilding of function composition with id %v failed: %v", fc.Id, r.Err)
+			pending.result <- Result{Err: r.Err}
+		}
+	}()
+	return pending
+}
+
+// cancelRebuild stops waiting for the build notification, false if the rebuild already finished
+func (c *Composer) cancelRebuild(fcId string, pending *rebuild) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.rebuilds[fcId] != pending {
+		return false
+	}
+	delete(c.rebuilds, fcId)
+	return true
+}
+
+// finishRebuild completes a rebuild of the composition with the build notification, false if no rebuild was waiting for it.
+// A failed rebuild leaves the composition on its current image.
+func (c *Composer) finishRebuild(fc *FunctionComposition, imageURL, status string) bool {
+	c.mu.Lock()
+	pending, ok := c.rebuilds[fc.Id]
+	delete(c.rebuilds, fc.Id)
+	c.mu.Unlock()
+	if !ok {
+		return false
+	}
+
+	if strings.ToLower(status) == "failed" {
+		log.Printf("Rebuild for function composition %s failed", fc.Id)
+		c.notify(Event{Type: EventBuildFailed, AppId: fc.FunctionAppId, FunctionCompositionId: fc.Id, Error: "build failed"})
+		pending.result <- Result{Err: fmt.Errorf("build of function composition %s failed", fc.Id)}
+		return true
+	}
+
+	fc.Build = Build{Image: imageURL, Timestamp: createBuildTimestamp(), SourceRevision: pending.revision, CacheKey: pending.cacheKey}
+	fc.Status = BuildStatusBuilt
+	if err := c.fcRepo.Save(fc); err != nil {
+		pending.result <- Result{Err: fmt.Errorf("failed to save function composition %s: %w", fc.Id, err)}
+		return true
+	}
+	log.Printf("Successfully rebuilt function composition with id %v. Image: %v", fc.Id, fc.Build.Image)
+	c.cacheBuild(fc)
+	pending.result <- Result{Value: fc}
+	return true
+}
+
+// setBuilds puts the compositions on already built images, keyed by composition id. Every composition is updated
+// even if one fails, the first error is returned.
+func (c *Composer) setBuilds(builds map[string]Build) error {
+	var firstErr error
+	for fcId, build := range builds {
+		fc, err := c.fcRepo.GetByID(fcId)
+		if err == nil && fc == nil {
+			err = fmt.Errorf("function composition %s not found", fcId)
+		}
+		if err == nil {
+			fc.Build = build
+			fc.Status = BuildStatusBuilt
+			err = c.fcRepo.Save(fc)
+		}
+		if err != nil {
+			log.Printf("Failed to set image of function composition %s: %v", fcId, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// UpdateSource stores the files as the next source revision of the app and rolls it out. The compositions depending
+// on a changed file are rebuilt, new deployments of them are started next to the current ones of the active layout,
+// and routing tables and the DNS record are only switched once all of them are ready. The record tracks the rollout.
+func (c *latencyController) UpdateSource(appId string, files []*multipart.FileHeader) (*ReconfigurationRecord, error) {
+	app, err := c.composer.GetFunctionApp(appId)
+	if err != nil {
+		return nil, err
+	}
+	if app == nil {
+		return nil, fmt.Errorf("function app %s not found", appId)
+	}
+	for _, fc := range app.Compositions {
+		if fc.Status == BuildStatusPending {
+			return nil, fmt.Errorf("%w: function composition %s is still being built", ErrReconfigurationInProgress, fc.Id)
+		}
+	}
+	// the release being replaced is kept as the first version, so the update can be rolled back
+	c.ensureVersion(app.Id)
+
+	var layout Layout
+	if candidate, ok := app.LayoutCandidates[app.ActiveLayoutKey]; ok {
+		layout, _ = c.placeLayout(app, candidate, false)
+	}
+	record := &ReconfigurationRecord{
+		Id:            uuid.New(),
+		FunctionAppId: app.Id,
+		Action:        ActionSourceUpdate,
+		FromLayoutKey: app.ActiveLayoutKey,
+		ToLayoutKey:   app.ActiveLayoutKey,
+		Layout:        layout,
+		StartTime:     c.clock.Now(),
+		Outcome:       ReconfigurationInProgress,
+	}
+	if !c.transitions.occupy(record.Id, app.Id) {
+		return nil, ErrReconfigurationInProgress
+	}
+
+	rev, err := c.composer.storeSourceRevision(app, files)
+	if err != nil {
+		c.transitions.finish(record.Id)
+		return nil, err
+	}
+	affected := app.affectedCompositions(rev.changed)
+	changed := make([]string, 0, len(rev.changed))
+	for name := range rev.changed {
+		changed = append(changed, name)
+	}
+	sort.Strings(changed)
+	record.Reason = fmt.Sprintf("source revision %d changes %s, rebuilding %d compositions", rev.revision, strings.Join(changed, ", "), len(affected))
+	c.startReconfiguration(record)
+
+	c.async(func() {
+		err := c.rolloutSource(app, rev, affected, layout, record)
+		if err != nil {
+			log.Printf("Failed to roll out source revision %d of app %s: %v", rev.revision, app.Id, err)
+		}
+		c.finishReconfiguration(record, err)
+	})
+
+	log.Printf("Rolling out source revision %d of app %s", rev.revision, app.Id)
+	return record, nil
+}
+
+// rolloutSource rebuilds the affected compositions and replaces their deployments in the active layout. If a build or
+// the deployment fails, the compositions keep their previous images and the previous revision keeps serving.
+func (c *latencyController) rolloutSource(app *FunctionApp, rev *sourceRevision, affected []*FunctionComposition, layout Layout, record *ReconfigurationRecord) error {
+	previous := make(map[string]Build, len(affected))
+	pending := make(map[string]*rebuild, len(affected))
+	for _, fc := range affected {
+		previous[fc.Id] = fc.Build
+		pending[fc.Id] = c.composer.rebuildFunctionComposition(fc, app.Runtime, rev.revision, rev.dir)
+	}
+
+	// one deadline for all builds, a build which fails or does not finish in time ends the wait for the others
+	ctx, cancel := context.WithTimeout(context.Background(), sourceBuildTimeout)
+	defer cancel()
+	var buildErr error
+	for fcId, build := range pending {
+		select {
+		case r := <-build.result:
+			delete(pending, fcId)
+			buildErr = r.Err
+		case <-ctx.Done():
+			buildErr = fmt.Errorf("function composition %s was not rebuilt within %s", fcId, sourceBuildTimeout)
+		}
+		if buildErr != nil {
+			break
+		}
+	}
+	if buildErr != nil {
+		// builds finishing later must not replace the images restored below
+		for fcId, build := range pending {
+			c.composer.cancelRebuild(fcId, build)
+		}
+		c.composer.setBuilds(previous)
+		c.composer.discardSourceRevision(rev)
+		return buildErr
+	}
+
+	if err := c.composer.setSourceRevision(app.Id, rev.revision, rev.files); err != nil {
+		c.composer.setBuilds(previous)
+		c.composer.discardSourceRevision(rev)
+		return fmt.Errorf("failed to save source revision of app %s: %w", app.Id, err)
+	}
+	if layout == nil {
+		// the app's deployments are not managed by the controller, the new images are used by deployments created from now on
+		c.recordVersion(app.Id)
+		return nil
+	}
+
+	replaced := make(map[string]bool, len(affected))
+	for _, fc := range affected {
+		replaced[fc.Id] = true
+	}
+	if err := c.deployLayout(app.Id, layout, false, reuseDeployments, replaced, record); err != nil {
+		c.composer.setBuilds(previous)
+		if revertErr := c.composer.setSourceRevision(app.Id, rev.previous, rev.previousFiles); revertErr != nil {
+			return fmt.Errorf("%v, and the source revision could not be reverted: %v", err, revertErr)
+		}
+		c.composer.discardSourceRevision(rev)
+		return fmt.Errorf("%w, source revision %d keeps serving: %v", errTransitionRolledBack, rev.previous, err)
+	}
+	c.recordVersion(app.Id)
+	return nil
+}
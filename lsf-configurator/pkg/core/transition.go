@@ -0,0 +1,93 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+// errTransitionRolledBack marks a layout transition which was undone, because the new layout did not become ready
+var errTransitionRolledBack = errors.New("layout transition rolled back")
+
+// waitReady checks that the result of a newly created deployment reports DeploymentStatusDeployed. The composer only
+// reports a deployment deployed once its knative service is ready, so traffic is never routed to a revision which is not serving.
+func (c *latencyController) waitReady(dep *Deployment, r Result) error {
+	// the composer saves the status through its own copy of the deployment and sends that copy with the result
+	saved, ok := r.Value.(*Deployment)
+	if !ok || saved == nil {
+		return fmt.Errorf("deployment %s finished without a status", dep.Id)
+	}
+	dep.Status = saved.Status
+	dep.StatusReason = saved.StatusReason
+	if dep.Status != DeploymentStatusDeployed {
+		return fmt.Errorf("deployment %s is %s instead of %s", dep.Id, dep.Status, DeploymentStatusDeployed)
+	}
+	return nil
+}
+
+// discardDeployments deletes the deployments created for a transition which did not complete
+func (c *latencyController) discardDeployments(deployments []*Deployment) {
+	for _, d := range deployments {
+		log.Printf("Discarding deployment %s (fc %s on node %s) of an incomplete transition", d.Id, d.FunctionCompositionId, d.Node)
+		delChan, err := c.composer.DeleteFcDeployment(d.Id)
+		if err != nil {
+			log.Printf("Failed to delete deployment %s: %v", d.Id, err)
+			continue
+		}
+		if r := <-delChan; r.Err != nil {
+			log.Printf("Failed to delete deployment %s: %v", d.Id, r.Err)
+		}
+	}
+}
+
+// restoreRoutingTables sets the routing tables reused deployments had before the transition, keyed by deployment id
+func (c *latencyController) restoreRoutingTables(previous map[string]RoutingTable) {
+	for depId, rt := range previous {
+		if err := c.composer.SetRoutingTable(depId, rt); err != nil {
+			log.Printf("Failed to restore routing table of deployment %s: %v", depId, err)
+		}
+	}
+}
+
+// applyLayout deploys the layout record switched the app to. deployLayout leaves the previous layout serving if it fails,
+// in that case the active layout key of the app is reverted to the previous layout as well.
+func (c *latencyController) applyLayout(appId string, layout Layout, isUpgrade bool, record *ReconfigurationRecord) error {
+	err := c.deployLayout(appId, layout, isUpgrade, reuseDeployments, nil, record)
+	if err == nil || record.FromLayoutKey == "" {
+		return err
+	}
+	if revertErr := c.revertActiveLayout(appId, record); revertErr != nil {
+		return fmt.Errorf("%w, and the active layout could not be reverted: %v", err, revertErr)
+	}
+	return fmt.Errorf("%w to layout %s: %v", errTransitionRolledBack, record.FromLayoutKey, err)
+}
+
+func (c *latencyController) revertActiveLayout(appId string, record *ReconfigurationRecord) error {
+	app, err := c.composer.GetFunctionApp(appId)
+	if err != nil {
+		return err
+	}
+	if app == nil {
+		return fmt.Errorf("function app %s not found", appId)
+	}
+	if app.ActiveLayoutKey != record.ToLayoutKey {
+		// the app was moved to another layout in the meantime
+		return nil
+	}
+	if _, ok := app.LayoutCandidates[record.FromLayoutKey]; !ok {
+		return fmt.Errorf("layout %s is no longer a layout candidate", record.FromLayoutKey)
+	}
+
+	app.ActiveLayoutKey = record.FromLayoutKey
+	if err := c.composer.functionAppRepo.Save(app); err != nil {
+		return fmt.Errorf("failed to update active layout key: %w", err)
+	}
+	c.historyMu.Lock()
+	// the app is back on the layout a rollback would target
+	c.historyFor(appId).PreviousLayoutKey = ""
+	c.historyMu.Unlock()
+	c.persistState(appId)
+
+	log.Printf("Reverted app %s to layout %s after the transition to %s failed", appId, record.FromLayoutKey, record.ToLayoutKey)
+	return nil
+}
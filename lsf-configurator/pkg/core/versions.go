@@ -0,0 +1,220 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"lsf-configurator/pkg/uuid"
+)
+
+// ErrVersionNotFound is returned for a rollback to a version which was never recorded for the app
+var ErrVersionNotFound = errors.New("version not found")
+
+// GetVersions returns the versions recorded for the app, oldest first
+func (c *latencyController) GetVersions(appId string) ([]*AppVersion, error) {
+	return c.versionRepo.GetByFunctionAppID(appId)
+}
+
+// ensureVersion records the release the app is running as its first version, unless a version was recorded already.
+// It runs when the app is registered and whenever one of its compositions finished its first build, apps with
+// compositions which are not built yet have no release to keep.
+func (c *latencyController) ensureVersion(appId string) {
+	c.versionMu.Lock()
+	defer c.versionMu.Unlock()
+	app, err := c.composer.GetFunctionApp(appId)
+	if err != nil || app == nil {
+		log.Printf("Error loading app %s to record its first version: %v", appId, err)
+		return
+	}
+	if app.Version > 0 || len(app.Compositions) == 0 {
+		return
+	}
+	for _, fc := range app.Compositions {
+		if fc.Status != BuildStatusBuilt || fc.Build.Image == "" {
+			return
+		}
+	}
+	c.saveVersion(app)
+}
+
+// recordVersion records the release the app is running as its next version, failures are only logged
+// since the release itself is already serving
+func (c *latencyController) recordVersion(appId string) {
+	c.versionMu.Lock()
+	defer c.versionMu.Unlock()
+	app, err := c.composer.GetFunctionApp(appId)
+	if err != nil || app == nil {
+		log.Printf("Error loading app %s to record its version: %v", appId, err)
+		return
+	}
+	c.saveVersion(app)
+}
+
+func (c *latencyController) saveVersion(app *FunctionApp) {
+	versions, err := c.versionRepo.GetByFunctionAppID(app.Id)
+	if err != nil {
+		log.Printf("Error loading versions of app %s: %v", app.Id, err)
+		return
+	}
+
+	version := &AppVersion{
+		Id:             uuid.New(),
+		FunctionAppId:  app.Id,
+		Version:        1,
+		SourceRevision: app.SourceRevision,
+		Components:     app.Components,
+		Links:          app.Links,
+		Files:          app.Files,
+		Images:         make(map[string]string, len(app.Compositions)),
+		CreatedAt:      c.clock.Now(),
+	}
+	if len(versions) > 0 {
+		version.Version = versions[len(versions)-1].Version + 1
+	}
+	for _, fc := range app.Compositions {
+		if fc.Status == BuildStatusBuilt && fc.Build.Image != "" {
+			version.Images[fc.Id] = fc.Build.Image
+		}
+	}
+	if err := c.versionRepo.Save(version); err != nil {
+		log.Printf("Error saving version %d of app %s: %v", version.Version, app.Id, err)
+		return
+	}
+	if err := c.composer.applyVersion(app.Id, version); err != nil {
+		log.Printf("Error saving version %d as the running version of app %s: %v", version.Version, app.Id, err)
+		return
+	}
+	log.Printf("Recorded version %d of app %s from source revision %d", version.Version, app.Id, version.SourceRevision)
+}
+
+// applyVersion makes the version the app's running release, new compositions are built from its source revision.
+// Only the source is restored, components and links keep the profiles observed and applied since the version.
+func (c *Composer) applyVersion(appId string, version *AppVersion) error {
+	app, err := c.functionAppRepo.GetByID(appId)
+	if err != nil {
+		return err
+	}
+	if app == nil {
+		return fmt.Errorf("function app %s not found", appId)
+	}
+	app.Version = version.Version
+	app.SourceRevision = version.SourceRevision
+	app.Files = version.Files
+	return c.functionAppRepo.Save(app)
+}
+
+// RollbackToVersion redeploys the images of a recorded version through the active layout, nothing is built again.
+// Like a source update, the new deployments start next to the current ones and traffic is switched once all are ready.
+func (c *latencyController) RollbackToVersion(appId string, version int) (*ReconfigurationRecord, error) {
+	app, err := c.composer.GetFunctionApp(appId)
+	if err != nil {
+		return nil, err
+	}
+	if app == nil {
+		return nil, fmt.Errorf("function app %s not found", appId)
+	}
+	versions, err := c.versionRepo.GetByFunctionAppID(appId)
+	if err != nil {
+		return nil, err
+	}
+	var target *AppVersion
+	for _, v := range versions {
+		if v.Version == version {
+			target = v
+		}
+	}
+	if target == nil {
+		return nil, fmt.Errorf("%w: app %s has no version %d", ErrVersionNotFound, appId, version)
+	}
+	for _, fc := range app.Compositions {
+		if fc.Status == BuildStatusPending {
+			return nil, fmt.Errorf("%w: function composition %s is still being built", ErrReconfigurationInProgress, fc.Id)
+		}
+	}
+
+	var layout Layout
+	if candidate, ok := app.LayoutCandidates[app.ActiveLayoutKey]; ok {
+		layout, _ = c.placeLayout(app, candidate, false)
+	}
+	deployed := make(map[string]bool) // componentsKey of the compositions in the layout
+	for _, info := range layout {
+		names := make([]string, len(info.ComponentProfiles))
+		for i, cp := range info.ComponentProfiles {
+			names[i] = cp.Name
+		}
+		deployed[componentsKey(names)] = true
+	}
+
+	builds := make(map[string]Build) // compositions whose image differs from the version's
+	for _, fc := range app.Compositions {
+		image, ok := target.Images[fc.Id]
+		if !ok {
+			// compositions created after the version are fine as long as they were built from the same source
+			if fc.Build.SourceRevision != target.SourceRevision && deployed[componentsKey(fc.Components)] {
+				return nil, fmt.Errorf("function composition %s of the active layout has no image of version %d", fc.Id, version)
+			}
+			continue
+		}
+		if image != fc.Build.Image {
+			builds[fc.Id] = Build{Image: image, Timestamp: createBuildTimestamp(), SourceRevision: target.SourceRevision}
+		}
+	}
+	if len(builds) == 0 && app.Version == version {
+		return nil, fmt.Errorf("app %s already runs version %d", appId, version)
+	}
+
+	record := &ReconfigurationRecord{
+		Id:            uuid.New(),
+		FunctionAppId: app.Id,
+		Action:        ActionVersionRollback,
+		Reason:        fmt.Sprintf("rollback from version %d to version %d, redeploying %d compositions", app.Version, version, len(builds)),
+		FromLayoutKey: app.ActiveLayoutKey,
+		ToLayoutKey:   app.ActiveLayoutKey,
+		Layout:        layout,
+		StartTime:     c.clock.Now(),
+		Outcome:       ReconfigurationInProgress,
+	}
+	if !c.transitions.occupy(record.Id, app.Id) {
+		return nil, ErrReconfigurationInProgress
+	}
+	c.startReconfiguration(record)
+
+	c.async(func() {
+		err := c.rollbackVersion(app, target, builds, layout, record)
+		if err != nil {
+			log.Printf("Failed to roll back app %s to version %d: %v", app.Id, version, err)
+		}
+		c.finishReconfiguration(record, err)
+	})
+
+	log.Printf("Rolling back app %s from version %d to version %d", app.Id, app.Version, version)
+	return record, nil
+}
+
+// rollbackVersion puts the compositions on the images of the version and replaces their deployments in the layout.
+// If the deployment fails, the compositions keep their current images and the current version keeps serving.
+func (c *latencyController) rollbackVersion(app *FunctionApp, version *AppVersion, builds map[string]Build, layout Layout, record *ReconfigurationRecord) error {
+	previous := make(map[string]Build, len(builds))
+	replaced := make(map[string]bool, len(builds))
+	for _, fc := range app.Compositions {
+		if _, ok := builds[fc.Id]; ok {
+			previous[fc.Id] = fc.Build
+			replaced[fc.Id] = true
+		}
+	}
+	if err := c.composer.setBuilds(builds); err != nil {
+		c.composer.setBuilds(previous)
+		return fmt.Errorf("failed to set images of version %d: %w", version.Version, err)
+	}
+
+	if layout != nil && len(replaced) > 0 {
+		if err := c.deployLayout(app.Id, layout, false, reuseDeployments, replaced, record); err != nil {
+			c.composer.setBuilds(previous)
+			return fmt.Errorf("%w, version %d keeps serving: %v", errTransitionRolledBack, app.Version, err)
+		}
+	}
+	if err := c.composer.applyVersion(app.Id, version); err != nil {
+		return fmt.Errorf("failed to save version %d as the running version of app %s: %w", version.Version, app.Id, err)
+	}
+	return nil
+}
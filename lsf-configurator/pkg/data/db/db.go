@@ -12,6 +12,30 @@ import (
 //go:embed schema.sql
 var schemaFS embed.FS
 
+// columnMigrations lists columns added after a table was first created.
+// CREATE TABLE IF NOT EXISTS does not touch existing tables, so these are added to older databases on startup.
+var columnMigrations = []struct {
+	table      string
+	column     string
+	definition string
+}{
+	{"function_apps", "controller_policy", "TEXT DEFAULT ''"},
+	{"function_apps", "layout_ladder", "TEXT DEFAULT '[]'"},
+	{"function_apps", "controller_settings", "TEXT DEFAULT ''"},
+	{"reconfigurations", "layout", "TEXT"},
+	{"function_apps", "controller_paused", "INTEGER DEFAULT 0"},
+	{"function_apps", "rate_levels", "TEXT DEFAULT '[]'"},
+	{"function_apps", "priority", "INTEGER DEFAULT 0"},
+	{"function_apps", "schedule", "TEXT DEFAULT '[]'"},
+	{"function_apps", "path_latency_limits", "TEXT DEFAULT '{}'"},
+	{"function_apps", "source_revision", "INTEGER DEFAULT 0"},
+	{"function_compositions", "source_revision", "INTEGER DEFAULT 0"},
+	{"deployments", "image", "TEXT DEFAULT ''"},
+	{"function_apps", "version", "INTEGER DEFAULT 0"},
+	{"function_compositions", "cache_key", "TEXT DEFAULT ''"},
+	{"deployments", "status_reason", "TEXT DEFAULT ''"},
+}
+
 func InitDB(path string) (*sql.DB, error) {
 	db, err := sql.Open("sqlite3", path)
 	if err != nil {
@@ -43,5 +67,47 @@ func InitDB(path string) (*sql.DB, error) {
 		}
 	}
 
+	if err := migrateColumns(db); err != nil {
+		return nil, err
+	}
+
 	return db, nil
 }
+
+func migrateColumns(db *sql.DB) error {
+	for _, m := range columnMigrations {
+		exists, err := columnExists(db, m.table, m.column)
+		if err != nil {
+			return fmt.Errorf("failed to inspect table %s: %w", m.table, err)
+		}
+		if exists {
+			continue
+		}
+		_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", m.table, m.column, m.definition))
+		if err != nil {
+			return fmt.Errorf("failed to add column %s.%s: %w", m.table, m.column, err)
+		}
+	}
+	return nil
+}
+
+func columnExists(db *sql.DB, table, column string) (bool, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
@@ -8,7 +8,17 @@ CREATE TABLE IF NOT EXISTS function_apps (
     source_path TEXT,
     latency_limit INTEGER,
     layout_candidates TEXT,
-    active_layout_key TEXT
+    active_layout_key TEXT,
+    controller_policy TEXT DEFAULT '',
+    layout_ladder TEXT DEFAULT '[]',
+    controller_settings TEXT DEFAULT '',
+    controller_paused INTEGER DEFAULT 0,
+    rate_levels TEXT DEFAULT '[]',
+    priority INTEGER DEFAULT 0,
+    schedule TEXT DEFAULT '[]',
+    path_latency_limits TEXT DEFAULT '{}',
+    source_revision INTEGER DEFAULT 0,
+    version INTEGER DEFAULT 0
 );
 
 CREATE TABLE IF NOT EXISTS function_compositions (
@@ -16,6 +26,8 @@ CREATE TABLE IF NOT EXISTS function_compositions (
     function_app_id TEXT NOT NULL,
     image TEXT,
     timestamp TEXT,
+    source_revision INTEGER DEFAULT 0,
+    cache_key TEXT DEFAULT '',
     files TEXT,           
     components TEXT, 
     status TEXT DEFAULT 'pending',     
@@ -34,5 +46,80 @@ CREATE TABLE IF NOT EXISTS deployments (
     scale_target_concurrency INTEGER DEFAULT 1,
     resources_memory INTEGER,
     resources_cpu INTEGER,
+    image TEXT DEFAULT '',
     FOREIGN KEY (function_composition_id) REFERENCES function_compositions(id) ON DELETE CASCADE
 );
+
+CREATE TABLE IF NOT EXISTS reconfigurations (
+    id TEXT PRIMARY KEY,
+    function_app_id TEXT NOT NULL,
+    action TEXT NOT NULL,
+    policy TEXT,
+    reason TEXT,
+    trigger_metric REAL,
+    metric_type TEXT,
+    trace_count INTEGER,
+    from_layout_key TEXT,
+    to_layout_key TEXT,
+    start_time INTEGER NOT NULL, -- unix milliseconds
+    end_time INTEGER DEFAULT 0,
+    outcome TEXT NOT NULL,
+    error TEXT,
+    layout TEXT,
+    FOREIGN KEY (function_app_id) REFERENCES function_apps(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_reconfigurations_app_start ON reconfigurations(function_app_id, start_time);
+
+CREATE TABLE IF NOT EXISTS app_versions (
+    id TEXT PRIMARY KEY,
+    function_app_id TEXT NOT NULL,
+    version INTEGER NOT NULL,
+    source_revision INTEGER DEFAULT 0,
+    components TEXT,
+    links TEXT,
+    files TEXT,
+    images TEXT DEFAULT '{}',
+    created_at INTEGER, -- unix milliseconds
+    UNIQUE (function_app_id, version),
+    FOREIGN KEY (function_app_id) REFERENCES function_apps(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS build_cache (
+    key TEXT PRIMARY KEY,
+    image TEXT NOT NULL,
+    function_composition_id TEXT,
+    created_at INTEGER -- unix milliseconds
+);
+
+CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+    id TEXT PRIMARY KEY,
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    event_types TEXT DEFAULT '[]',
+    app_ids TEXT DEFAULT '[]',
+    created_at INTEGER -- unix milliseconds
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id TEXT PRIMARY KEY,
+    subscription_id TEXT NOT NULL,
+    event_id TEXT NOT NULL,
+    event_type TEXT NOT NULL,
+    attempt INTEGER NOT NULL,
+    time INTEGER NOT NULL, -- unix milliseconds
+    status_code INTEGER DEFAULT 0,
+    delivered INTEGER DEFAULT 0,
+    error TEXT,
+    FOREIGN KEY (subscription_id) REFERENCES webhook_subscriptions(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_subscription_time ON webhook_deliveries(subscription_id, time);
+
+CREATE TABLE IF NOT EXISTS controller_state (
+    function_app_id TEXT PRIMARY KEY,
+    history TEXT NOT NULL,
+    shadow_layout_key TEXT DEFAULT '',
+    updated_at INTEGER, -- unix milliseconds
+    FOREIGN KEY (function_app_id) REFERENCES function_apps(id) ON DELETE CASCADE
+);
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"lsf-configurator/pkg/core"
 	"sync"
+	"time"
 )
 
 var dbWriteMutex sync.Mutex
@@ -18,6 +19,8 @@ func NewFunctionAppRepository(db *sql.DB) core.FunctionAppRepository {
 	return &functionAppRepo{db: db}
 }
 
+const functionAppColumns = `id, name, runtime, components, links, files, source_path, latency_limit, layout_candidates, active_layout_key, controller_policy, layout_ladder, controller_settings, controller_paused, rate_levels, priority, schedule, path_latency_limits, source_revision, version`
+
 func (r *functionAppRepo) Save(app *core.FunctionApp) error {
 	dbWriteMutex.Lock()
 	defer dbWriteMutex.Unlock()
@@ -44,12 +47,33 @@ func (r *functionAppRepo) Save(app *core.FunctionApp) error {
 	if err != nil {
 		return fmt.Errorf("failed to marshal layout candidates: %w", err)
 	}
+	ladderJSON, err := json.Marshal(app.LayoutLadder)
+	if err != nil {
+		return fmt.Errorf("failed to marshal layout ladder: %w", err)
+	}
+	settingsJSON, err := json.Marshal(app.ControllerSettings)
+	if err != nil {
+		return fmt.Errorf("failed to marshal controller settings: %w", err)
+	}
+	rateLevelsJSON, err := json.Marshal(app.RateLevels)
+	if err != nil {
+		return fmt.Errorf("failed to marshal rate levels: %w", err)
+	}
+	scheduleJSON, err := json.Marshal(app.Schedule)
+	if err != nil {
+		return fmt.Errorf("failed to marshal schedule: %w", err)
+	}
+	pathLimitsJSON, err := json.Marshal(app.PathLatencyLimits)
+	if err != nil {
+		return fmt.Errorf("failed to marshal path latency limits: %w", err)
+	}
 
 	_, err = tx.Exec(`
-		INSERT OR REPLACE INTO function_apps (id, name, runtime, components, links, files, source_path, latency_limit, layout_candidates, active_layout_key) 
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		INSERT OR REPLACE INTO function_apps (`+functionAppColumns+`) 
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		app.Id, app.Name, app.Runtime, string(componentsJSON), string(linksJSON),
-		string(filesJSON), app.SourcePath, app.LatencyLimit, string(layoutJSON), app.ActiveLayoutKey)
+		string(filesJSON), app.SourcePath, app.LatencyLimit, string(layoutJSON), app.ActiveLayoutKey,
+		app.ControllerPolicy, string(ladderJSON), string(settingsJSON), app.ControllerPaused, string(rateLevelsJSON), app.Priority, string(scheduleJSON), string(pathLimitsJSON), app.SourceRevision, app.Version)
 	if err != nil {
 		return err
 	}
@@ -69,39 +93,17 @@ func (r *functionAppRepo) Save(app *core.FunctionApp) error {
 
 func (r *functionAppRepo) GetByID(id string) (*core.FunctionApp, error) {
 	row := r.db.QueryRow(`
-	SELECT id, name, runtime, components, links, files, source_path, latency_limit, layout_candidates, active_layout_key
+	SELECT `+functionAppColumns+`
 	FROM function_apps WHERE id = ?`, id)
 
-	var app core.FunctionApp
-	var componentsJSON, linksJSON, filesJSON, sourcePath string
-	var latencyLimit int
-	var activeLayoutKey string
-	var layoutCandidatesJSON string
-
-	if err := row.Scan(&app.Id, &app.Name, &app.Runtime, &componentsJSON,
-		&linksJSON, &filesJSON, &sourcePath, &latencyLimit, &layoutCandidatesJSON, &activeLayoutKey); err != nil {
+	app, err := scanFunctionApp(row)
+	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
 		return nil, err
 	}
 
-	if err := json.Unmarshal([]byte(componentsJSON), &app.Components); err != nil {
-		return nil, fmt.Errorf("failed to parse components: %w", err)
-	}
-	if err := json.Unmarshal([]byte(linksJSON), &app.Links); err != nil {
-		return nil, fmt.Errorf("failed to parse links: %w", err)
-	}
-	if err := json.Unmarshal([]byte(filesJSON), &app.Files); err != nil {
-		return nil, fmt.Errorf("failed to parse files: %w", err)
-	}
-	if err := json.Unmarshal([]byte(layoutCandidatesJSON), &app.LayoutCandidates); err != nil {
-		return nil, fmt.Errorf("failed to parse layout candidates: %w", err)
-	}
-	app.SourcePath = sourcePath
-	app.LatencyLimit = latencyLimit
-	app.ActiveLayoutKey = activeLayoutKey
-
 	app.Compositions = make([]*core.FunctionComposition, 0)
 	rows, err := r.db.Query(`SELECT id FROM function_compositions WHERE function_app_id = ?`, app.Id)
 	if err != nil {
@@ -122,12 +124,12 @@ func (r *functionAppRepo) GetByID(id string) (*core.FunctionApp, error) {
 		app.Compositions = append(app.Compositions, comp)
 	}
 
-	return &app, nil
+	return app, nil
 }
 
 func (r *functionAppRepo) GetAll() ([]*core.FunctionApp, error) {
 	rows, err := r.db.Query(`
-	SELECT id, name, runtime, components, links, files, source_path, latency_limit, layout_candidates, active_layout_key
+	SELECT ` + functionAppColumns + `
 	FROM function_apps`)
 	if err != nil {
 		return nil, err
@@ -136,35 +138,66 @@ func (r *functionAppRepo) GetAll() ([]*core.FunctionApp, error) {
 
 	var apps []*core.FunctionApp
 	for rows.Next() {
-		var app core.FunctionApp
-		var componentsJSON, linksJSON, filesJSON, sourcePath string
-		var latencyLimit int
-		var layoutCandidatesJSON, activeLayoutKey string
-		if err := rows.Scan(&app.Id, &app.Name, &app.Runtime, &componentsJSON,
-			&linksJSON, &filesJSON, &sourcePath, &latencyLimit, &layoutCandidatesJSON, &activeLayoutKey); err != nil {
+		app, err := scanFunctionApp(rows)
+		if err != nil {
 			return nil, err
 		}
+		apps = append(apps, app)
+	}
 
-		if err := json.Unmarshal([]byte(componentsJSON), &app.Components); err != nil {
-			return nil, fmt.Errorf("failed to parse components: %w", err)
-		}
-		if err := json.Unmarshal([]byte(linksJSON), &app.Links); err != nil {
-			return nil, fmt.Errorf("failed to parse links: %w", err)
-		}
-		if err := json.Unmarshal([]byte(layoutCandidatesJSON), &app.LayoutCandidates); err != nil {
-			return nil, fmt.Errorf("failed to parse layout candidates: %w", err)
-		}
-		if err := json.Unmarshal([]byte(filesJSON), &app.Files); err != nil {
-			return nil, fmt.Errorf("failed to parse files: %w", err)
-		}
-		app.SourcePath = sourcePath
-		app.LatencyLimit = latencyLimit
-		app.ActiveLayoutKey = activeLayoutKey
+	return apps, nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
 
-		apps = append(apps, &app)
+func scanFunctionApp(row rowScanner) (*core.FunctionApp, error) {
+	var app core.FunctionApp
+	var componentsJSON, linksJSON, filesJSON, sourcePath string
+	var latencyLimit int
+	var layoutCandidatesJSON, activeLayoutKey, ladderJSON, settingsJSON, rateLevelsJSON, scheduleJSON, pathLimitsJSON string
+
+	if err := row.Scan(&app.Id, &app.Name, &app.Runtime, &componentsJSON,
+		&linksJSON, &filesJSON, &sourcePath, &latencyLimit, &layoutCandidatesJSON, &activeLayoutKey,
+		&app.ControllerPolicy, &ladderJSON, &settingsJSON, &app.ControllerPaused, &rateLevelsJSON, &app.Priority, &scheduleJSON, &pathLimitsJSON, &app.SourceRevision, &app.Version); err != nil {
+		return nil, err
 	}
 
-	return apps, nil
+	if err := json.Unmarshal([]byte(componentsJSON), &app.Components); err != nil {
+		return nil, fmt.Errorf("failed to parse components: %w", err)
+	}
+	if err := json.Unmarshal([]byte(linksJSON), &app.Links); err != nil {
+		return nil, fmt.Errorf("failed to parse links: %w", err)
+	}
+	if err := json.Unmarshal([]byte(filesJSON), &app.Files); err != nil {
+		return nil, fmt.Errorf("failed to parse files: %w", err)
+	}
+	if err := json.Unmarshal([]byte(layoutCandidatesJSON), &app.LayoutCandidates); err != nil {
+		return nil, fmt.Errorf("failed to parse layout candidates: %w", err)
+	}
+	if err := json.Unmarshal([]byte(ladderJSON), &app.LayoutLadder); err != nil {
+		return nil, fmt.Errorf("failed to parse layout ladder: %w", err)
+	}
+	if err := json.Unmarshal([]byte(rateLevelsJSON), &app.RateLevels); err != nil {
+		return nil, fmt.Errorf("failed to parse rate levels: %w", err)
+	}
+	if err := json.Unmarshal([]byte(scheduleJSON), &app.Schedule); err != nil {
+		return nil, fmt.Errorf("failed to parse schedule: %w", err)
+	}
+	if err := json.Unmarshal([]byte(pathLimitsJSON), &app.PathLatencyLimits); err != nil {
+		return nil, fmt.Errorf("failed to parse path latency limits: %w", err)
+	}
+	if settingsJSON != "" {
+		if err := json.Unmarshal([]byte(settingsJSON), &app.ControllerSettings); err != nil {
+			return nil, fmt.Errorf("failed to parse controller settings: %w", err)
+		}
+	}
+	app.SourcePath = sourcePath
+	app.LatencyLimit = latencyLimit
+	app.ActiveLayoutKey = activeLayoutKey
+
+	return &app, nil
 }
 
 func (r *functionAppRepo) Delete(id string) error {
@@ -198,10 +231,10 @@ func (r *functionCompositionRepo) Save(comp *core.FunctionComposition) error {
 	_, err = r.db.Exec(`
 		INSERT OR REPLACE INTO function_compositions (
 			id, function_app_id,
-			image, timestamp, files, components, status
-		) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			image, timestamp, source_revision, cache_key, files, components, status
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		comp.Id, comp.FunctionAppId, comp.Image,
-		comp.Timestamp, string(filesJSON), string(componentsJSON), comp.Status,
+		comp.Timestamp, comp.SourceRevision, comp.CacheKey, string(filesJSON), string(componentsJSON), comp.Status,
 	)
 
 	return err
@@ -209,7 +242,7 @@ func (r *functionCompositionRepo) Save(comp *core.FunctionComposition) error {
 
 func (r *functionCompositionRepo) GetByID(id string) (*core.FunctionComposition, error) {
 	row := r.db.QueryRow(`
-		SELECT id, function_app_id, image, timestamp, files, components, status
+		SELECT id, function_app_id, image, timestamp, source_revision, cache_key, files, components, status
 		FROM function_compositions
 		WHERE id = ?`, id)
 
@@ -217,7 +250,7 @@ func (r *functionCompositionRepo) GetByID(id string) (*core.FunctionComposition,
 	var filesJSON, componentsJSON string
 
 	err := row.Scan(
-		&comp.Id, &comp.FunctionAppId, &comp.Image, &comp.Timestamp,
+		&comp.Id, &comp.FunctionAppId, &comp.Image, &comp.Timestamp, &comp.SourceRevision, &comp.CacheKey,
 		&filesJSON, &componentsJSON, &comp.Status,
 	)
 	if err != nil {
@@ -265,9 +298,9 @@ func (r *functionCompositionRepo) saveWithTx(tx *sql.Tx, comp *core.FunctionComp
 	_, err = tx.Exec(`
 		INSERT OR REPLACE INTO function_compositions (
 			id, function_app_id,
-			image, timestamp, files, components, status
-		) VALUES (?, ?, ?, ?, ?, ?, ?)`,
-		comp.Id, comp.FunctionAppId, comp.Image, comp.Timestamp,
+			image, timestamp, source_revision, cache_key, files, components, status
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		comp.Id, comp.FunctionAppId, comp.Image, comp.Timestamp, comp.SourceRevision, comp.CacheKey,
 		string(filesJSON), string(componentsJSON), comp.Status,
 	)
 	return err
@@ -292,12 +325,12 @@ func (r *deploymentRepo) Save(deployment *core.Deployment) error {
 
 	_, err = r.db.Exec(`
 		INSERT OR REPLACE INTO deployments (
-			id, function_composition_id, node, namespace, routing_table, status, scale_min_replicas, scale_max_replicas, scale_target_concurrency, resources_memory, resources_cpu
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			id, function_composition_id, node, namespace, routing_table, status, scale_min_replicas, scale_max_replicas, scale_target_concurrency, resources_memory, resources_cpu, image, status_reason
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		deployment.Id, deployment.FunctionCompositionId, deployment.Node,
 		deployment.Namespace, string(routingTableJSON), deployment.Status,
 		deployment.Scale.MinReplicas, deployment.Scale.MaxReplicas,
-		deployment.Scale.TargetConcurrency, deployment.Resources.Memory, deployment.Resources.CPU,
+		deployment.Scale.TargetConcurrency, deployment.Resources.Memory, deployment.Resources.CPU, deployment.Image, deployment.StatusReason,
 	)
 	return err
 }
@@ -312,7 +345,7 @@ func (r *deploymentRepo) Delete(id string) error {
 
 func (r *deploymentRepo) GetByID(id string) (*core.Deployment, error) {
 	row := r.db.QueryRow(`
-		SELECT id, function_composition_id, node, namespace, routing_table, status, scale_min_replicas, scale_max_replicas, scale_target_concurrency, resources_memory, resources_cpu
+		SELECT id, function_composition_id, node, namespace, routing_table, status, scale_min_replicas, scale_max_replicas, scale_target_concurrency, resources_memory, resources_cpu, image, status_reason
 		FROM deployments
 		WHERE id = ?`, id)
 
@@ -323,7 +356,7 @@ func (r *deploymentRepo) GetByID(id string) (*core.Deployment, error) {
 		&deployment.Id, &deployment.FunctionCompositionId, &deployment.Node,
 		&deployment.Namespace, &routingTableJSON, &deployment.Status,
 		&deployment.Scale.MinReplicas, &deployment.Scale.MaxReplicas,
-		&deployment.Scale.TargetConcurrency, &deployment.Resources.Memory, &deployment.Resources.CPU,
+		&deployment.Scale.TargetConcurrency, &deployment.Resources.Memory, &deployment.Resources.CPU, &deployment.Image, &deployment.StatusReason,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -341,7 +374,7 @@ func (r *deploymentRepo) GetByID(id string) (*core.Deployment, error) {
 
 func (r *deploymentRepo) GetByFunctionCompositionID(functionCompositionID string) ([]*core.Deployment, error) {
 	rows, err := r.db.Query(`
-		SELECT id, function_composition_id, node, namespace, routing_table, status, scale_min_replicas, scale_max_replicas, scale_target_concurrency, resources_memory, resources_cpu
+		SELECT id, function_composition_id, node, namespace, routing_table, status, scale_min_replicas, scale_max_replicas, scale_target_concurrency, resources_memory, resources_cpu, image, status_reason
 		FROM deployments
 		WHERE function_composition_id = ?`, functionCompositionID)
 	if err != nil {
@@ -358,7 +391,7 @@ func (r *deploymentRepo) GetByFunctionCompositionID(functionCompositionID string
 			&deployment.Id, &deployment.FunctionCompositionId, &deployment.Node,
 			&deployment.Namespace, &routingTableJSON, &deployment.Status,
 			&deployment.Scale.MinReplicas, &deployment.Scale.MaxReplicas,
-			&deployment.Scale.TargetConcurrency, &deployment.Resources.Memory, &deployment.Resources.CPU,
+			&deployment.Scale.TargetConcurrency, &deployment.Resources.Memory, &deployment.Resources.CPU, &deployment.Image, &deployment.StatusReason,
 		)
 		if err != nil {
 			return nil, err
@@ -376,7 +409,7 @@ func (r *deploymentRepo) GetByFunctionCompositionID(functionCompositionID string
 
 func (r *deploymentRepo) GetByFunctionAppID(functionAppID string) ([]*core.Deployment, error) {
 	rows, err := r.db.Query(`
-		SELECT d.id, d.function_composition_id, d.node, d.namespace, d.routing_table, d.status, d.scale_min_replicas, d.scale_max_replicas, d.scale_target_concurrency, d.resources_memory, d.resources_cpu
+		SELECT d.id, d.function_composition_id, d.node, d.namespace, d.routing_table, d.status, d.scale_min_replicas, d.scale_max_replicas, d.scale_target_concurrency, d.resources_memory, d.resources_cpu, d.image, d.status_reason
 		FROM deployments d
 		INNER JOIN function_compositions fc ON d.function_composition_id = fc.id
 		WHERE fc.function_app_id = ?`, functionAppID)
@@ -394,7 +427,7 @@ func (r *deploymentRepo) GetByFunctionAppID(functionAppID string) ([]*core.Deplo
 			&deployment.Id, &deployment.FunctionCompositionId, &deployment.Node,
 			&deployment.Namespace, &routingTableJSON, &deployment.Status,
 			&deployment.Scale.MinReplicas, &deployment.Scale.MaxReplicas,
-			&deployment.Scale.TargetConcurrency, &deployment.Resources.Memory, &deployment.Resources.CPU,
+			&deployment.Scale.TargetConcurrency, &deployment.Resources.Memory, &deployment.Resources.CPU, &deployment.Image, &deployment.StatusReason,
 		)
 		if err != nil {
 			return nil, err
@@ -409,3 +442,375 @@ func (r *deploymentRepo) GetByFunctionAppID(functionAppID string) ([]*core.Deplo
 
 	return deployments, nil
 }
+
+type reconfigurationRepo struct {
+	db *sql.DB
+}
+
+func NewReconfigurationRepository(db *sql.DB) core.ReconfigurationRepository {
+	return &reconfigurationRepo{db: db}
+}
+
+const reconfigurationColumns = `id, function_app_id, action, policy, reason, trigger_metric, metric_type, trace_count, from_layout_key, to_layout_key, start_time, end_time, outcome, error, layout`
+
+func (r *reconfigurationRepo) Save(record *core.ReconfigurationRecord) error {
+	dbWriteMutex.Lock()
+	defer dbWriteMutex.Unlock()
+
+	var endTime int64
+	if !record.EndTime.IsZero() {
+		endTime = record.EndTime.UnixMilli()
+	}
+	layoutJSON, err := json.Marshal(record.Layout)
+	if err != nil {
+		return fmt.Errorf("failed to marshal layout: %w", err)
+	}
+
+	_, err = r.db.Exec(`
+		INSERT OR REPLACE INTO reconfigurations (`+reconfigurationColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		record.Id, record.FunctionAppId, record.Action, record.Policy, record.Reason,
+		record.TriggerMetric, record.MetricType, record.TraceCount, record.FromLayoutKey, record.ToLayoutKey,
+		record.StartTime.UnixMilli(), endTime, record.Outcome, record.Error, string(layoutJSON))
+	return err
+}
+
+func (r *reconfigurationRepo) GetByFunctionAppID(functionAppID string, from, to time.Time) ([]*core.ReconfigurationRecord, error) {
+	query := `SELECT ` + reconfigurationColumns + ` FROM reconfigurations WHERE function_app_id = ?`
+	args := []any{functionAppID}
+	if !from.IsZero() {
+		query += ` AND start_time >= ?`
+		args = append(args, from.UnixMilli())
+	}
+	if !to.IsZero() {
+		query += ` AND start_time <= ?`
+		args = append(args, to.UnixMilli())
+	}
+	query += ` ORDER BY start_time`
+
+	return r.query(query, args...)
+}
+
+func (r *reconfigurationRepo) GetInProgress() ([]*core.ReconfigurationRecord, error) {
+	return r.query(`SELECT `+reconfigurationColumns+` FROM reconfigurations WHERE outcome = ? ORDER BY start_time`,
+		core.ReconfigurationInProgress)
+}
+
+func (r *reconfigurationRepo) query(query string, args ...any) ([]*core.ReconfigurationRecord, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := make([]*core.ReconfigurationRecord, 0)
+	for rows.Next() {
+		var record core.ReconfigurationRecord
+		var policy, reason, metricType, fromKey, toKey, errMsg, layoutJSON sql.NullString
+		var startTime, endTime int64
+
+		err := rows.Scan(&record.Id, &record.FunctionAppId, &record.Action, &policy, &reason,
+			&record.TriggerMetric, &metricType, &record.TraceCount, &fromKey, &toKey,
+			&startTime, &endTime, &record.Outcome, &errMsg, &layoutJSON)
+		if err != nil {
+			return nil, err
+		}
+
+		record.Policy = policy.String
+		record.Reason = reason.String
+		record.MetricType = core.MetricType(metricType.String)
+		record.FromLayoutKey = fromKey.String
+		record.ToLayoutKey = toKey.String
+		record.Error = errMsg.String
+		record.StartTime = time.UnixMilli(startTime)
+		if endTime != 0 {
+			record.EndTime = time.UnixMilli(endTime)
+		}
+		if layoutJSON.String != "" {
+			if err := json.Unmarshal([]byte(layoutJSON.String), &record.Layout); err != nil {
+				return nil, fmt.Errorf("failed to parse layout: %w", err)
+			}
+		}
+		records = append(records, &record)
+	}
+
+	return records, rows.Err()
+}
+
+type appVersionRepo struct {
+	db *sql.DB
+}
+
+func NewAppVersionRepository(db *sql.DB) core.AppVersionRepository {
+	return &appVersionRepo{db: db}
+}
+
+func (r *appVersionRepo) Save(version *core.AppVersion) error {
+	dbWriteMutex.Lock()
+	defer dbWriteMutex.Unlock()
+
+	componentsJSON, err := json.Marshal(version.Components)
+	if err != nil {
+		return fmt.Errorf("failed to marshal components: %w", err)
+	}
+	linksJSON, err := json.Marshal(version.Links)
+	if err != nil {
+		return fmt.Errorf("failed to marshal links: %w", err)
+	}
+	filesJSON, err := json.Marshal(version.Files)
+	if err != nil {
+		return fmt.Errorf("failed to marshal files: %w", err)
+	}
+	imagesJSON, err := json.Marshal(version.Images)
+	if err != nil {
+		return fmt.Errorf("failed to marshal images: %w", err)
+	}
+
+	_, err = r.db.Exec(`
+		INSERT OR REPLACE INTO app_versions (id, function_app_id, version, source_revision, components, links, files, images, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		version.Id, version.FunctionAppId, version.Version, version.SourceRevision, string(componentsJSON),
+		string(linksJSON), string(filesJSON), string(imagesJSON), version.CreatedAt.UnixMilli())
+	return err
+}
+
+func (r *appVersionRepo) GetByFunctionAppID(functionAppID string) ([]*core.AppVersion, error) {
+	rows, err := r.db.Query(`
+		SELECT id, function_app_id, version, source_revision, components, links, files, images, created_at
+		FROM app_versions WHERE function_app_id = ? ORDER BY version`, functionAppID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := make([]*core.AppVersion, 0)
+	for rows.Next() {
+		var version core.AppVersion
+		var componentsJSON, linksJSON, filesJSON, imagesJSON string
+		var createdAt int64
+
+		if err := rows.Scan(&version.Id, &version.FunctionAppId, &version.Version, &version.SourceRevision,
+			&componentsJSON, &linksJSON, &filesJSON, &imagesJSON, &createdAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(componentsJSON), &version.Components); err != nil {
+			return nil, fmt.Errorf("failed to parse components: %w", err)
+		}
+		if err := json.Unmarshal([]byte(linksJSON), &version.Links); err != nil {
+			return nil, fmt.Errorf("failed to parse links: %w", err)
+		}
+		if err := json.Unmarshal([]byte(filesJSON), &version.Files); err != nil {
+			return nil, fmt.Errorf("failed to parse files: %w", err)
+		}
+		if err := json.Unmarshal([]byte(imagesJSON), &version.Images); err != nil {
+			return nil, fmt.Errorf("failed to parse images: %w", err)
+		}
+		version.CreatedAt = time.UnixMilli(createdAt)
+		versions = append(versions, &version)
+	}
+
+	return versions, rows.Err()
+}
+
+type buildCacheRepo struct {
+	db *sql.DB
+}
+
+func NewBuildCacheRepository(db *sql.DB) core.BuildCacheRepository {
+	return &buildCacheRepo{db: db}
+}
+
+func (r *buildCacheRepo) Get(key string) (*core.BuildCacheEntry, error) {
+	var entry core.BuildCacheEntry
+	var fcId sql.NullString
+	var createdAt int64
+	err := r.db.QueryRow(`SELECT key, image, function_composition_id, created_at FROM build_cache WHERE key = ?`, key).
+		Scan(&entry.Key, &entry.Image, &fcId, &createdAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	entry.FunctionCompositionId = fcId.String
+	entry.CreatedAt = time.UnixMilli(createdAt)
+	return &entry, nil
+}
+
+func (r *buildCacheRepo) Save(entry *core.BuildCacheEntry) error {
+	dbWriteMutex.Lock()
+	defer dbWriteMutex.Unlock()
+
+	_, err := r.db.Exec(`
+		INSERT OR REPLACE INTO build_cache (key, image, function_composition_id, created_at)
+		VALUES (?, ?, ?, ?)`,
+		entry.Key, entry.Image, entry.FunctionCompositionId, entry.CreatedAt.UnixMilli())
+	return err
+}
+
+type controllerStateRepo struct {
+	db *sql.DB
+}
+
+func NewControllerStateRepository(db *sql.DB) core.ControllerStateRepository {
+	return &controllerStateRepo{db: db}
+}
+
+func (r *controllerStateRepo) Save(state *core.ControllerState) error {
+	dbWriteMutex.Lock()
+	defer dbWriteMutex.Unlock()
+
+	historyJSON, err := json.Marshal(state.History)
+	if err != nil {
+		return fmt.Errorf("failed to marshal policy history: %w", err)
+	}
+
+	_, err = r.db.Exec(`
+		INSERT OR REPLACE INTO controller_state (function_app_id, history, shadow_layout_key, updated_at)
+		VALUES (?, ?, ?, ?)`,
+		state.FunctionAppId, string(historyJSON), state.ShadowLayoutKey, state.UpdatedAt.UnixMilli())
+	return err
+}
+
+func (r *controllerStateRepo) GetAll() ([]*core.ControllerState, error) {
+	rows, err := r.db.Query(`SELECT function_app_id, history, shadow_layout_key, updated_at FROM controller_state`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	states := make([]*core.ControllerState, 0)
+	for rows.Next() {
+		var state core.ControllerState
+		var historyJSON string
+		var shadowLayoutKey sql.NullString
+		var updatedAt sql.NullInt64
+
+		if err := rows.Scan(&state.FunctionAppId, &historyJSON, &shadowLayoutKey, &updatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(historyJSON), &state.History); err != nil {
+			return nil, fmt.Errorf("failed to parse policy history: %w", err)
+		}
+		state.ShadowLayoutKey = shadowLayoutKey.String
+		state.UpdatedAt = time.UnixMilli(updatedAt.Int64)
+		states = append(states, &state)
+	}
+
+	return states, rows.Err()
+}
+
+type webhookRepo struct {
+	db *sql.DB
+}
+
+func NewWebhookRepository(db *sql.DB) core.WebhookRepository {
+	return &webhookRepo{db: db}
+}
+
+func (r *webhookRepo) SaveSubscription(sub *core.WebhookSubscription) error {
+	dbWriteMutex.Lock()
+	defer dbWriteMutex.Unlock()
+
+	eventTypesJSON, err := json.Marshal(sub.EventTypes)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event types: %w", err)
+	}
+	appIdsJSON, err := json.Marshal(sub.AppIds)
+	if err != nil {
+		return fmt.Errorf("failed to marshal app ids: %w", err)
+	}
+
+	_, err = r.db.Exec(`
+		INSERT OR REPLACE INTO webhook_subscriptions (id, url, secret, event_types, app_ids, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		sub.Id, sub.URL, sub.Secret, string(eventTypesJSON), string(appIdsJSON), sub.CreatedAt.UnixMilli())
+	return err
+}
+
+func (r *webhookRepo) GetSubscriptions() ([]*core.WebhookSubscription, error) {
+	rows, err := r.db.Query(`SELECT id, url, secret, event_types, app_ids, created_at FROM webhook_subscriptions ORDER BY created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subs := make([]*core.WebhookSubscription, 0)
+	for rows.Next() {
+		var sub core.WebhookSubscription
+		var eventTypesJSON, appIdsJSON string
+		var createdAt int64
+
+		if err := rows.Scan(&sub.Id, &sub.URL, &sub.Secret, &eventTypesJSON, &appIdsJSON, &createdAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(eventTypesJSON), &sub.EventTypes); err != nil {
+			return nil, fmt.Errorf("failed to parse event types: %w", err)
+		}
+		if err := json.Unmarshal([]byte(appIdsJSON), &sub.AppIds); err != nil {
+			return nil, fmt.Errorf("failed to parse app ids: %w", err)
+		}
+		sub.CreatedAt = time.UnixMilli(createdAt)
+		subs = append(subs, &sub)
+	}
+
+	return subs, rows.Err()
+}
+
+func (r *webhookRepo) DeleteSubscription(id string) error {
+	dbWriteMutex.Lock()
+	defer dbWriteMutex.Unlock()
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM webhook_deliveries WHERE subscription_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM webhook_subscriptions WHERE id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *webhookRepo) SaveDelivery(delivery *core.WebhookDelivery) error {
+	dbWriteMutex.Lock()
+	defer dbWriteMutex.Unlock()
+
+	_, err := r.db.Exec(`
+		INSERT OR REPLACE INTO webhook_deliveries (id, subscription_id, event_id, event_type, attempt, time, status_code, delivered, error)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		delivery.Id, delivery.SubscriptionId, delivery.EventId, delivery.EventType, delivery.Attempt,
+		delivery.Time.UnixMilli(), delivery.StatusCode, delivery.Delivered, delivery.Error)
+	return err
+}
+
+func (r *webhookRepo) GetDeliveries(subscriptionId string, limit int) ([]*core.WebhookDelivery, error) {
+	rows, err := r.db.Query(`
+		SELECT id, subscription_id, event_id, event_type, attempt, time, status_code, delivered, error
+		FROM webhook_deliveries WHERE subscription_id = ? ORDER BY time DESC LIMIT ?`, subscriptionId, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := make([]*core.WebhookDelivery, 0)
+	for rows.Next() {
+		var delivery core.WebhookDelivery
+		var errMsg sql.NullString
+		var deliveryTime int64
+
+		if err := rows.Scan(&delivery.Id, &delivery.SubscriptionId, &delivery.EventId, &delivery.EventType, &delivery.Attempt,
+			&deliveryTime, &delivery.StatusCode, &delivery.Delivered, &errMsg); err != nil {
+			return nil, err
+		}
+		delivery.Time = time.UnixMilli(deliveryTime)
+		delivery.Error = errMsg.String
+		deliveries = append(deliveries, &delivery)
+	}
+
+	return deliveries, rows.Err()
+}
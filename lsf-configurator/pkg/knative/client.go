@@ -89,6 +89,7 @@ func (c *Client) Deploy(ctx context.Context, deployment core.Deployment, image,
 				RequiredNodes: []string{deployment.Node},
 			},
 			Namespace: deployment.Namespace,
+			Labels:    getDeployLabels(appId, deployment.FunctionCompositionId),
 			Options: fn.Options{
 				Scale: &fn.ScaleOptions{
 					Min:         int64Ptr(deployment.Scale.MinReplicas),
@@ -172,6 +173,17 @@ func getDeployEnvs(appId, deploymentId, resultStoreAddress string) []fn.Env {
 	return envs
 }
 
+// getDeployLabels marks the service as a deployment of the app, so the reconciler can find it
+func getDeployLabels(appId, fcId string) []fn.Label {
+	appLabel := core.AppLabel
+	fcLabel := core.FunctionCompositionLabel
+
+	return []fn.Label{
+		{Key: &appLabel, Value: &appId},
+		{Key: &fcLabel, Value: &fcId},
+	}
+}
+
 func int64Ptr(i int) *int64 {
 	i64 := int64(i)
 	return &i64
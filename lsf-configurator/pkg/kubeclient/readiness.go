@@ -0,0 +1,151 @@
+package kubeclient
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// readinessPollInterval is how often the service is checked while it cannot be watched
+const readinessPollInterval = 2 * time.Second
+
+var (
+	knativeServiceResource  = schema.GroupVersionResource{Group: "serving.knative.dev", Version: "v1", Resource: "services"}
+	knativeRevisionResource = schema.GroupVersionResource{Group: "serving.knative.dev", Version: "v1", Resource: "revisions"}
+)
+
+// failureReasons are the condition reasons of a revision which will not become ready without a change of the service
+var failureReasons = map[string]bool{
+	"ImagePullBackOff":         true,
+	"ErrImagePull":             true,
+	"InvalidImageName":         true,
+	"ContainerMissing":         true,
+	"Unschedulable":            true,
+	"CrashLoopBackOff":         true,
+	"ProgressDeadlineExceeded": true,
+}
+
+// WaitForServiceReady waits until the Ready condition of the knative service is True for its latest generation.
+// Knative only reports a revision ready once its pods pass their readiness probes. It returns early with the reason
+// if the latest revision failed, like when its image cannot be pulled or its pods cannot be scheduled.
+// The service is checked again on every change reported by a watch on it, and polled if the watch cannot be opened or ends.
+func (c *Client) WaitForServiceReady(ctx context.Context, namespace, serviceName string) error {
+	// the watch is opened before the first check, so a change right after the check is not missed
+	var events <-chan watch.Event
+	watcher, err := c.dynamic.Resource(knativeServiceResource).Namespace(namespace).Watch(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("metadata.name", serviceName).String(),
+	})
+	if err == nil {
+		defer watcher.Stop()
+		events = watcher.ResultChan()
+	}
+
+	reason := "service not found"
+	for {
+		status, err := c.serviceStatus(ctx, namespace, serviceName)
+		switch {
+		case err != nil:
+			reason = err.Error()
+		case status.ready:
+			return nil
+		case status.failed:
+			return fmt.Errorf("knative service %s failed: %s", serviceName, status.reason)
+		default:
+			reason = status.reason
+		}
+
+		if events == nil {
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("knative service %s is not ready in time: %s", serviceName, reason)
+			case <-time.After(readinessPollInterval):
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("knative service %s is not ready in time: %s", serviceName, reason)
+		case event, ok := <-events:
+			if !ok || event.Type == watch.Error {
+				events = nil
+			}
+		}
+	}
+}
+
+type serviceStatus struct {
+	ready  bool
+	failed bool // the latest revision will not become ready
+	reason string
+}
+
+func (c *Client) serviceStatus(ctx context.Context, namespace, serviceName string) (serviceStatus, error) {
+	svc, err := c.dynamic.Resource(knativeServiceResource).Namespace(namespace).Get(ctx, serviceName, metav1.GetOptions{})
+	if err != nil {
+		return serviceStatus{}, fmt.Errorf("failed to get knative service: %w", err)
+	}
+
+	var status serviceStatus
+	status.ready, status.reason = readyCondition(svc)
+	if status.ready {
+		return status, nil
+	}
+
+	// the service only reports RevisionFailed, the revision tells why
+	revisionName, _, _ := unstructured.NestedString(svc.Object, "status", "latestCreatedRevisionName")
+	if revisionName == "" {
+		return status, nil
+	}
+	revision, err := c.dynamic.Resource(knativeRevisionResource).Namespace(namespace).Get(ctx, revisionName, metav1.GetOptions{})
+	if err != nil {
+		return status, nil
+	}
+	if failed, reason := revisionFailure(revision); failed {
+		status.failed = true
+		status.reason = fmt.Sprintf("revision %s: %s", revisionName, reason)
+	}
+	return status, nil
+}
+
+// readyCondition reports whether the Ready condition of the service is True for its latest generation
+func readyCondition(svc *unstructured.Unstructured) (bool, string) {
+	observed, _, _ := unstructured.NestedInt64(svc.Object, "status", "observedGeneration")
+	if observed < svc.GetGeneration() {
+		return false, "latest generation not observed yet"
+	}
+
+	conditions, _, _ := unstructured.NestedSlice(svc.Object, "status", "conditions")
+	for _, cond := range conditions {
+		m, ok := cond.(map[string]interface{})
+		if !ok || m["type"] != "Ready" {
+			continue
+		}
+		if m["status"] == "True" {
+			return true, ""
+		}
+		return false, fmt.Sprintf("%v: %v", m["reason"], m["message"])
+	}
+	return false, "no Ready condition reported"
+}
+
+// revisionFailure reports whether a condition of the revision failed for one of the failureReasons
+func revisionFailure(revision *unstructured.Unstructured) (bool, string) {
+	conditions, _, _ := unstructured.NestedSlice(revision.Object, "status", "conditions")
+	for _, cond := range conditions {
+		m, ok := cond.(map[string]interface{})
+		if !ok || m["status"] == "True" {
+			continue
+		}
+		if reason, _ := m["reason"].(string); failureReasons[reason] {
+			return true, fmt.Sprintf("%s: %v", reason, m["message"])
+		}
+	}
+	return false, ""
+}
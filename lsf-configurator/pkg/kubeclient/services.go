@@ -0,0 +1,78 @@
+package kubeclient
+
+import (
+	"context"
+	"fmt"
+	"lsf-configurator/pkg/core"
+	"strconv"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	minScaleAnnotation = "autoscaling.knative.dev/min-scale"
+	maxScaleAnnotation = "autoscaling.knative.dev/max-scale"
+)
+
+// ListServices returns the knative services of the function apps in every namespace
+func (c *Client) ListServices(ctx context.Context) ([]core.KnativeService, error) {
+	list, err := c.dynamic.Resource(knativeServiceResource).Namespace(metav1.NamespaceAll).List(ctx, metav1.ListOptions{LabelSelector: core.AppLabel})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list knative services: %w", err)
+	}
+
+	services := make([]core.KnativeService, 0, len(list.Items))
+	for i := range list.Items {
+		services = append(services, toKnativeService(&list.Items[i]))
+	}
+	return services, nil
+}
+
+// toKnativeService reads the fields the knative client sets from the revision template of the service
+func toKnativeService(svc *unstructured.Unstructured) core.KnativeService {
+	labels := svc.GetLabels()
+	s := core.KnativeService{
+		Name:                  svc.GetName(),
+		Namespace:             svc.GetNamespace(),
+		AppId:                 labels[core.AppLabel],
+		FunctionCompositionId: labels[core.FunctionCompositionLabel],
+	}
+	s.Ready, s.Reason = readyCondition(svc)
+
+	annotations, _, _ := unstructured.NestedStringMap(svc.Object, "spec", "template", "metadata", "annotations")
+	s.Scale.MinReplicas, _ = strconv.Atoi(annotations[minScaleAnnotation])
+	s.Scale.MaxReplicas, _ = strconv.Atoi(annotations[maxScaleAnnotation])
+
+	containers, _, _ := unstructured.NestedSlice(svc.Object, "spec", "template", "spec", "containers")
+	if len(containers) > 0 {
+		if container, ok := containers[0].(map[string]interface{}); ok {
+			s.Image, _, _ = unstructured.NestedString(container, "image")
+			requests, _, _ := unstructured.NestedStringMap(container, "resources", "requests")
+			if memory, err := resource.ParseQuantity(requests["memory"]); err == nil {
+				s.Resources.Memory = int(memory.Value() / (1024 * 1024))
+			}
+			if cpu, err := resource.ParseQuantity(requests["cpu"]); err == nil {
+				s.Resources.CPU = int(cpu.MilliValue())
+			}
+		}
+	}
+
+	terms, _, _ := unstructured.NestedSlice(svc.Object, "spec", "template", "spec", "affinity", "nodeAffinity",
+		"requiredDuringSchedulingIgnoredDuringExecution", "nodeSelectorTerms")
+	if len(terms) > 0 {
+		if term, ok := terms[0].(map[string]interface{}); ok {
+			expressions, _, _ := unstructured.NestedSlice(term, "matchExpressions")
+			if len(expressions) > 0 {
+				if expr, ok := expressions[0].(map[string]interface{}); ok {
+					if nodes, _, _ := unstructured.NestedStringSlice(expr, "values"); len(nodes) > 0 {
+						s.Node = nodes[0]
+					}
+				}
+			}
+		}
+	}
+	return s
+}
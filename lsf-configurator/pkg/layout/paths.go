@@ -0,0 +1,118 @@
+package layout
+
+import (
+	"fmt"
+	"lsf-configurator/pkg/core"
+	"sort"
+)
+
+// pathConstraint is a latency requirement on the path from the entry component to an end component
+type pathConstraint struct {
+	end     string
+	cpEnd   int // SLAMBUC id of the end component
+	latency int // in ms
+}
+
+// pathConstraints returns the app-wide requirement, put on the path to the last component as before,
+// followed by the requirements of the paths to individual end components
+func pathConstraints(scenario core.LayoutScenario) []pathConstraint {
+	last := len(scenario.Profiles)
+	constraints := []pathConstraint{{end: scenario.Profiles[last-1].Name, cpEnd: last, latency: scenario.LatencyRequirement}}
+
+	ends := make([]string, 0, len(scenario.PathLatencyRequirements))
+	for end := range scenario.PathLatencyRequirements {
+		ends = append(ends, end)
+	}
+	sort.Strings(ends)
+	for _, end := range ends {
+		for i, p := range scenario.Profiles {
+			if p.Name == end {
+				constraints = append(constraints, pathConstraint{end: end, cpEnd: i + 1, latency: scenario.PathLatencyRequirements[end]})
+				break
+			}
+		}
+	}
+	return constraints
+}
+
+// runConstrained runs SLAMBUC with each constrained path as the critical path and returns the cheapest layout
+// meeting every constraint. SLAMBUC only bounds the latency of the critical path, the other paths are checked with estimatePathLatency.
+func (c *slambucCalculator) runConstrained(scenario core.LayoutScenario) (map[string][]core.ComponentProfile, float64, int, error) {
+	constraints := pathConstraints(scenario)
+	if len(constraints) == 1 {
+		return c.runSLAMBUC(scenario, constraints[0])
+	}
+
+	var best map[string][]core.ComponentProfile
+	var bestCost float64
+	var bestLatency int
+	var lastErr error
+	for _, constraint := range constraints {
+		layout, cost, latency, err := c.runSLAMBUC(scenario, constraint)
+		if err != nil {
+			lastErr = fmt.Errorf("critical path to %s: %w", constraint.end, err)
+			continue
+		}
+		if violated, estimate := violatedConstraint(layout, scenario, constraints, constraint, c.platformDelay); violated != nil {
+			lastErr = fmt.Errorf("critical path to %s: path to %s takes %dms, more than its %dms requirement", constraint.end, violated.end, estimate, violated.latency)
+			continue
+		}
+		if best == nil || cost < bestCost {
+			best, bestCost, bestLatency = layout, cost, latency
+		}
+	}
+	if best == nil {
+		return nil, 0, 0, fmt.Errorf("no layout meets every path latency requirement: %w", lastErr)
+	}
+	return best, bestCost, bestLatency, nil
+}
+
+// violatedConstraint returns the first constraint the layout does not meet and the estimated latency of its path.
+// The critical path SLAMBUC calculated the layout for is not estimated again.
+func violatedConstraint(layout map[string][]core.ComponentProfile, scenario core.LayoutScenario, constraints []pathConstraint,
+	critical pathConstraint, platformDelay int) (*pathConstraint, int) {
+	for i, constraint := range constraints {
+		if constraint.cpEnd == critical.cpEnd && constraint.latency >= critical.latency {
+			continue
+		}
+		if latency := estimatePathLatency(layout, scenario, constraint.end, platformDelay); latency > constraint.latency {
+			return &constraints[i], latency
+		}
+	}
+	return nil, 0
+}
+
+// estimatePathLatency adds up the runtimes of the components on the path from the entry component to end,
+// and the platform and data delay of every call on the path which crosses compositions
+func estimatePathLatency(layout map[string][]core.ComponentProfile, scenario core.LayoutScenario, end string, platformDelay int) int {
+	group := make(map[string]string)
+	runtime := make(map[string]int)
+	for node, profiles := range layout {
+		for _, p := range profiles {
+			group[p.Name] = node
+			runtime[p.Name] = p.Runtime
+		}
+	}
+	caller := make(map[string]core.ScenarioLink)
+	for _, l := range scenario.Links {
+		if _, ok := caller[l.To]; !ok {
+			caller[l.To] = l
+		}
+	}
+
+	latency := 0
+	visited := make(map[string]bool)
+	for comp := end; comp != "" && !visited[comp]; {
+		visited[comp] = true
+		latency += runtime[comp]
+		link, ok := caller[comp]
+		if !ok {
+			break
+		}
+		if group[link.From] != group[comp] {
+			latency += platformDelay + link.DataDelay
+		}
+		comp = link.From
+	}
+	return latency
+}
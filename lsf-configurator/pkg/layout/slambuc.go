@@ -3,9 +3,11 @@ package layout
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"lsf-configurator/pkg/core"
 	"os/exec"
+	"sort"
 )
 
 type slambucCalculator struct {
@@ -32,7 +34,7 @@ func (c *slambucCalculator) CalculateLayout(scenario core.LayoutScenario) (core.
 	maxReplicasSeen := initializeMaxReplicas(scenario.Profiles)
 
 	for iter := 0; iter < c.maxIterations; iter++ {
-		layout, optCost, latency, err := c.runSLAMBUC(scenario)
+		layout, optCost, latency, err := c.runConstrained(scenario)
 		if err != nil {
 			return nil, fmt.Errorf("SLAMBUC iteration %d failed: %v", iter, err)
 		}
@@ -68,7 +70,10 @@ func (c *slambucCalculator) CalculateLayout(scenario core.LayoutScenario) (core.
 	return nil, fmt.Errorf("failed to converge layout after %d iterations", c.maxIterations)
 }
 
-func (c *slambucCalculator) runSLAMBUC(scenario core.LayoutScenario) (map[string][]core.ComponentProfile, float64, int, error) {
+// runSLAMBUC partitions the call graph, keeping the latency of the path ending in constraint.end within constraint.latency.
+// SLAMBUC bounds every group by the same node memory, so if a group does not fit onto the node it is assigned to,
+// the graph is partitioned again with the free memory of that node as the bound.
+func (c *slambucCalculator) runSLAMBUC(scenario core.LayoutScenario, constraint pathConstraint) (map[string][]core.ComponentProfile, float64, int, error) {
 	idMap := make(map[string]int)
 	profileMap := make(map[int]core.ComponentProfile)
 	nodes := []map[string]interface{}{}
@@ -129,8 +134,8 @@ func (c *slambucCalculator) runSLAMBUC(scenario core.LayoutScenario) (map[string
 		"params": map[string]interface{}{
 			"root":   1,
 			"M":      scenario.AvailableNodeMemory,
-			"L":      scenario.LatencyRequirement,
-			"cp_end": len(scenario.Profiles),
+			"L":      constraint.latency,
+			"cp_end": constraint.cpEnd,
 			"delay":  c.platformDelay,
 		},
 		"nodes": nodes,
@@ -171,20 +176,75 @@ func (c *slambucCalculator) runSLAMBUC(scenario core.LayoutScenario) (map[string
 		return nil, 0, 0, fmt.Errorf("insufficient memory: layout has more groups (%d) than platform nodes (%d)", len(pyOutput.Layout), len(c.platformNodes))
 	}
 
-	layout := make(map[string][]core.ComponentProfile)
+	groups := make([][]core.ComponentProfile, len(pyOutput.Layout))
+	groupMemory := make([]int, len(pyOutput.Layout))
 	for i, group := range pyOutput.Layout {
-		var groupProfiles []core.ComponentProfile
 		for _, id := range group {
 			if prof, ok := profileMap[id]; ok {
-				groupProfiles = append(groupProfiles, prof)
+				groups[i] = append(groups[i], prof)
+				groupMemory[i] += prof.EffectiveMemory(
+					scenario.InvocationSharedMemoryRatio,
+					scenario.TargetConcurrency,
+					scenario.MemorySafetyBufferRatio,
+				) * prof.RequiredReplicas
 			}
 		}
-		layout[c.platformNodes[i]] = groupProfiles
+	}
+	groupNodes, err := c.assignNodes(groupMemory, scenario.NodeFreeMemory)
+	var tooLarge *nodeMemoryError
+	if errors.As(err, &tooLarge) && tooLarge.free < scenario.AvailableNodeMemory {
+		scenario.AvailableNodeMemory = tooLarge.free
+		return c.runSLAMBUC(scenario, constraint)
+	}
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	layout := make(map[string][]core.ComponentProfile)
+	for i, group := range groups {
+		layout[groupNodes[i]] = group
 	}
 	//log.Default().Printf("SLAMBUC layout result: %+v, cost: %f, latency: %d", layout, pyOutput.OptCost, pyOutput.Latency)
 	return layout, pyOutput.OptCost, pyOutput.Latency, nil
 }
 
+// assignNodes returns the platform node of each group. Without free memory information the groups are placed in node order,
+// otherwise the largest group goes to the node with the most free memory, the second largest to the next one and so on.
+func (c *slambucCalculator) assignNodes(groupMemory []int, freeMemory map[string]int) ([]string, error) {
+	if len(freeMemory) == 0 {
+		return c.platformNodes[:len(groupMemory)], nil
+	}
+
+	groupOrder := make([]int, len(groupMemory))
+	for i := range groupOrder {
+		groupOrder[i] = i
+	}
+	sort.SliceStable(groupOrder, func(i, j int) bool { return groupMemory[groupOrder[i]] > groupMemory[groupOrder[j]] })
+	nodes := append([]string(nil), c.platformNodes...)
+	sort.SliceStable(nodes, func(i, j int) bool { return freeMemory[nodes[i]] > freeMemory[nodes[j]] })
+
+	assigned := make([]string, len(groupMemory))
+	for rank, group := range groupOrder {
+		node := nodes[rank]
+		if freeMemory[node] < groupMemory[group] {
+			return nil, &nodeMemoryError{need: groupMemory[group], node: node, free: freeMemory[node]}
+		}
+		assigned[group] = node
+	}
+	return assigned, nil
+}
+
+// nodeMemoryError is returned by assignNodes when a group needs more memory than the node it is assigned to has free
+type nodeMemoryError struct {
+	need int
+	node string
+	free int
+}
+
+func (e *nodeMemoryError) Error() string {
+	return fmt.Sprintf("insufficient memory: a composition needs %dMB, but node %s only has %dMB not promised to other apps", e.need, e.node, e.free)
+}
+
 func (c *slambucCalculator) estimateReplicasPerGroup(layout map[string][]core.ComponentProfile, scenario core.LayoutScenario) []core.ComponentProfile {
 	updatedProfiles := make([]core.ComponentProfile, 0, len(scenario.Profiles))
 	compMap := make(map[string]core.ComponentProfile)
@@ -7,6 +7,10 @@ import (
 	"fmt"
 	"log"
 	"lsf-configurator/pkg/core"
+	"math"
+	"slices"
+	"strconv"
+	"time"
 
 	"github.com/elastic/go-elasticsearch/v8"
 	"github.com/elastic/go-elasticsearch/v8/typedapi/core/search"
@@ -29,10 +33,11 @@ type ApiKeyConfig struct {
 }
 
 type metricsClient struct {
-	client *elasticsearch.TypedClient
+	client         *elasticsearch.TypedClient
+	requestTimeout time.Duration // traces started this close to the end of a time range may still be running
 }
 
-func NewMetricsReader(backendAddr string) (core.MetricsReader, error) {
+func NewMetricsReader(backendAddr string, requestTimeout time.Duration) (core.MetricsReader, error) {
 	cfg := elasticsearch.Config{
 		Addresses: []string{
 			backendAddr,
@@ -45,7 +50,8 @@ func NewMetricsReader(backendAddr string) (core.MetricsReader, error) {
 	}
 
 	return &metricsClient{
-		client: es,
+		client:         es,
+		requestTimeout: requestTimeout,
 	}, nil
 }
 
@@ -227,12 +233,293 @@ func (c metricsClient) queryAppRuntimes(
 	extractor metricExtractorFunc,
 ) (map[string]float64, map[string]int, error) {
 
+	appAggregations := traceAggregations()
+	appAggregations[metricAggName] = metricAgg
+
+	appBuckets, err := c.searchAppTraces(timeRangeGte, appAggregations)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	metricResult := make(map[string]float64)
+	countResult := make(map[string]int)
+
+	for _, appBucket := range appBuckets {
+		appName := appBucket.Key.(string)
+
+		// Extract Metric (P95, Avg, etc.) using the injected extractor
+		metricInterface, ok := appBucket.Aggregations[metricAggName]
+		if ok && metricInterface != nil {
+			if value, ok := extractor(metricInterface); ok {
+				metricResult[appName] = value
+			}
+		}
+
+		countInterface, ok := appBucket.Aggregations["trace_count"]
+		if ok && countInterface != nil {
+			if countAgg, ok := countInterface.(*types.CardinalityAggregate); ok {
+				countResult[appName] = int(countAgg.Value)
+			}
+		}
+	}
+
+	return metricResult, countResult, nil
+}
+
+// QueryLatencyViolations returns the fraction of complete traces per app which took longer than the app's latency limit,
+// together with the number of complete traces. Apps without a limit are left out.
+func (c metricsClient) QueryLatencyViolations(timeRangeGte string, latencyLimits map[string]int) (map[string]float64, map[string]int, error) {
+	appBuckets, err := c.searchAppTraces(timeRangeGte, traceAggregations())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ratios := make(map[string]float64)
+	counts := make(map[string]int)
+	for _, appBucket := range appBuckets {
+		appName := appBucket.Key.(string)
+		limit, ok := latencyLimits[appName]
+		if !ok || limit <= 0 {
+			continue
+		}
+		tracesAgg, ok := appBucket.Aggregations["traces"].(*types.StringTermsAggregate)
+		if !ok {
+			continue
+		}
+
+		total, violations := 0, 0
+		for _, traceBucket := range tracesAgg.Buckets.([]types.StringTermsBucket) {
+			durationAgg, ok := traceBucket.Aggregations["trace_duration_ms"].(*types.SimpleValueAggregate)
+			if !ok || durationAgg.Value == nil {
+				continue
+			}
+			total++
+			if float64(*durationAgg.Value) > float64(limit) {
+				violations++
+			}
+		}
+		if total == 0 {
+			continue
+		}
+		ratios[appName] = float64(violations) / float64(total)
+		counts[appName] = total
+	}
+	return ratios, counts, nil
+}
+
+// QueryPathRuntimes returns the runtime of the path to each end component per app, measured from the start of a trace
+// to the end of the trace boundary end span of the end component, together with the number of traces per path
+func (c metricsClient) QueryPathRuntimes(timeRangeGte string, metricType core.MetricType) (map[string]map[string]float64, map[string]map[string]int, error) {
+	size := 1000
+	appAggregations := map[string]types.Aggregations{
+		"traces": {
+			Terms: &types.TermsAggregation{
+				Field: strPtr("trace.id"),
+				Size:  &size,
+			},
+			Aggregations: map[string]types.Aggregations{
+				"has_start_span": {
+					Filter: &types.Query{
+						Exists: &types.ExistsQuery{
+							Field: "labels.trace_boundary_start",
+						},
+					},
+				},
+				"min_start": {
+					Min: &types.MinAggregation{
+						Field: strPtr("@timestamp"),
+					},
+				},
+				"paths": {
+					Terms: &types.TermsAggregation{
+						Field: strPtr("labels.trace_boundary_end_component"),
+						Size:  &size,
+					},
+					Aggregations: map[string]types.Aggregations{
+						"max_end": {
+							Max: &types.MaxAggregation{
+								Script: &types.Script{
+									Source: strPtr("doc['@timestamp'].value.toInstant().toEpochMilli() + doc['span.duration.us'].value / 1000"),
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	appBuckets, err := c.searchAppTraces(timeRangeGte, appAggregations)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	runtimes := make(map[string]map[string]float64)
+	counts := make(map[string]map[string]int)
+	for _, appBucket := range appBuckets {
+		appName := appBucket.Key.(string)
+		tracesAgg, ok := appBucket.Aggregations["traces"].(*types.StringTermsAggregate)
+		if !ok {
+			continue
+		}
+
+		durations := make(map[string][]float64)
+		for _, traceBucket := range tracesAgg.Buckets.([]types.StringTermsBucket) {
+			// traces which started before the time range have no start span, their duration is unknown
+			start, ok := traceBucket.Aggregations["has_start_span"].(*types.FilterAggregate)
+			if !ok || start.DocCount == 0 {
+				continue
+			}
+			minStart, ok := traceBucket.Aggregations["min_start"].(*types.MinAggregate)
+			if !ok || minStart.Value == nil {
+				continue
+			}
+			pathsAgg, ok := traceBucket.Aggregations["paths"].(*types.StringTermsAggregate)
+			if !ok {
+				continue
+			}
+			for _, pathBucket := range pathsAgg.Buckets.([]types.StringTermsBucket) {
+				maxEnd, ok := pathBucket.Aggregations["max_end"].(*types.MaxAggregate)
+				if !ok || maxEnd.Value == nil {
+					continue
+				}
+				end := pathBucket.Key.(string)
+				durations[end] = append(durations[end], float64(*maxEnd.Value)-float64(*minStart.Value))
+			}
+		}
+		if len(durations) == 0 {
+			continue
+		}
+
+		runtimes[appName] = make(map[string]float64, len(durations))
+		counts[appName] = make(map[string]int, len(durations))
+		for end, values := range durations {
+			runtimes[appName][end] = aggregateDurations(values, metricType)
+			counts[appName][end] = len(values)
+		}
+	}
+	return runtimes, counts, nil
+}
+
+// aggregateDurations returns the 95th percentile (nearest rank) or the average of the durations
+func aggregateDurations(durations []float64, metricType core.MetricType) float64 {
+	if metricType == core.MetricTypeAverage {
+		var sum float64
+		for _, d := range durations {
+			sum += d
+		}
+		return sum / float64(len(durations))
+	}
+	sorted := slices.Clone(durations)
+	slices.Sort(sorted)
+	rank := int(math.Ceil(0.95*float64(len(sorted)))) - 1
+	return sorted[max(rank, 0)]
+}
+
+// incompleteTraces scripts count the traces of an app which have a boundary start span from before
+// params.started_before, in epoch milliseconds, but no boundary end span. Every trace of the app is looked at,
+// so the count covers the same traces as the cardinality of trace.id.
+const (
+	incompleteTracesInitScript = `state.traces = new HashMap();`
+	incompleteTracesMapScript  = `
+if (doc['trace.id'].size() == 0) { return; }
+String id = doc['trace.id'].value;
+int flags = state.traces.containsKey(id) ? state.traces.get(id) : 0;
+if (doc.containsKey('labels.trace_boundary_start') && doc['labels.trace_boundary_start'].size() > 0
+    && doc['@timestamp'].value.toInstant().toEpochMilli() <= params.started_before) { flags |= 1; }
+if (doc.containsKey('labels.trace_boundary_end') && doc['labels.trace_boundary_end'].size() > 0) { flags |= 2; }
+state.traces.put(id, flags);`
+	incompleteTracesCombineScript = `return state.traces;`
+	incompleteTracesReduceScript  = `
+Map traces = new HashMap();
+for (s in states) {
+  if (s == null) { continue; }
+  for (entry in s.entrySet()) {
+    def flags = traces.get(entry.getKey());
+    traces.put(entry.getKey(), flags == null ? entry.getValue() : flags | entry.getValue());
+  }
+}
+int incomplete = 0;
+for (flags in traces.values()) {
+  if (flags == 1) { incomplete++; }
+}
+return incomplete;`
+)
+
+// QueryAppHealth returns the number of traces per app, how many of them contain failed spans
+// and how many never reached a trace boundary end span, e.g. because a component crashed or timed out.
+// Traces which started within the request timeout of the end of the time range may still be running, they are not counted as incomplete.
+func (c metricsClient) QueryAppHealth(timeRangeGte string) (map[string]core.AppHealth, error) {
+	startedBefore := time.Now().Add(-c.requestTimeout).UnixMilli()
+	appAggregations := map[string]types.Aggregations{
+		"trace_count": {
+			Cardinality: &types.CardinalityAggregation{
+				Field: strPtr("trace.id"),
+			},
+		},
+		"failed_spans": {
+			Filter: &types.Query{
+				Term: map[string]types.TermQuery{
+					"event.outcome": {
+						Value: "failure",
+					},
+				},
+			},
+			Aggregations: map[string]types.Aggregations{
+				"failed_traces": {
+					Cardinality: &types.CardinalityAggregation{
+						Field: strPtr("trace.id"),
+					},
+				},
+			},
+		},
+		"incomplete_traces": {
+			ScriptedMetric: &types.ScriptedMetricAggregation{
+				Params:        map[string]json.RawMessage{"started_before": json.RawMessage(strconv.FormatInt(startedBefore, 10))},
+				InitScript:    &types.Script{Source: strPtr(incompleteTracesInitScript)},
+				MapScript:     &types.Script{Source: strPtr(incompleteTracesMapScript)},
+				CombineScript: &types.Script{Source: strPtr(incompleteTracesCombineScript)},
+				ReduceScript:  &types.Script{Source: strPtr(incompleteTracesReduceScript)},
+			},
+		},
+	}
+
+	appBuckets, err := c.searchAppTraces(timeRangeGte, appAggregations)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]core.AppHealth)
+	for _, appBucket := range appBuckets {
+		appName := appBucket.Key.(string)
+		var health core.AppHealth
+
+		if countAgg, ok := appBucket.Aggregations["trace_count"].(*types.CardinalityAggregate); ok {
+			health.TraceCount = int(countAgg.Value)
+		}
+		if failedAgg, ok := appBucket.Aggregations["failed_spans"].(*types.FilterAggregate); ok {
+			if tracesAgg, ok := failedAgg.Aggregations["failed_traces"].(*types.CardinalityAggregate); ok {
+				health.ErrorCount = int(tracesAgg.Value)
+			}
+		}
+		// traces which started before the time range have no start span, they are not counted
+		if incompleteAgg, ok := appBucket.Aggregations["incomplete_traces"].(*types.ScriptedMetricAggregate); ok {
+			if err := json.Unmarshal(incompleteAgg.Value, &health.IncompleteCount); err != nil {
+				log.Printf("Error parsing incomplete traces of app %s: %v", appName, err)
+			}
+		}
+		result[appName] = health
+	}
+	return result, nil
+}
+
+// traceAggregations groups the spans of each app into traces, keeps the complete ones and calculates their durations
+func traceAggregations() map[string]types.Aggregations {
 	size := 1000
-	appNameField := "labels.app_name"
 	startSpanLabelField := "labels.trace_boundary_start"
 	endSpanLabelField := "labels.trace_boundary_end"
 
-	appAggregations := map[string]types.Aggregations{
+	return map[string]types.Aggregations{
 		"trace_count": {
 			Cardinality: &types.CardinalityAggregation{
 				Field: strPtr("trace.id"),
@@ -296,8 +583,12 @@ func (c metricsClient) queryAppRuntimes(
 			},
 		},
 	}
+}
 
-	appAggregations[metricAggName] = metricAgg
+// searchAppTraces runs the per-app aggregations over the spans reported within the time range
+func (c metricsClient) searchAppTraces(timeRangeGte string, appAggregations map[string]types.Aggregations) ([]types.StringTermsBucket, error) {
+	size := 1000
+	appNameField := "labels.app_name"
 
 	res, err := c.client.Search().
 		Index(tracesIndex).
@@ -337,42 +628,184 @@ func (c metricsClient) queryAppRuntimes(
 		Do(context.Background())
 
 	if err != nil {
-		return nil, nil, fmt.Errorf("error querying metrics: %w", err)
+		return nil, fmt.Errorf("error querying metrics: %w", err)
 	}
 
 	appsInterface, exists := res.Aggregations["apps"]
 	if !exists || appsInterface == nil {
-		return make(map[string]float64), make(map[string]int), nil
+		return nil, nil
 	}
 
 	appsAgg, ok := appsInterface.(*types.StringTermsAggregate)
 	if !ok {
-		return nil, nil, errors.New("incorrect aggregation type for apps")
+		return nil, errors.New("incorrect aggregation type for apps")
 	}
 
-	metricResult := make(map[string]float64)
-	countResult := make(map[string]int)
+	return appsAgg.Buckets.([]types.StringTermsBucket), nil
+}
 
-	for _, appBucket := range appsAgg.Buckets.([]types.StringTermsBucket) {
-		appName := appBucket.Key.(string)
+func (c metricsClient) QueryComponentSpanStats(timeRangeGte string) (map[string]map[string]core.SpanStats, error) {
+	size := 1000
+	appNameField := "labels.app_name"
+	spanNameField := "span.name"
 
-		// Extract Metric (P95, Avg, etc.) using the injected extractor
-		metricInterface, ok := appBucket.Aggregations[metricAggName]
-		if ok && metricInterface != nil {
-			if value, ok := extractor(metricInterface); ok {
-				metricResult[appName] = value
+	res, err := c.client.Search().
+		Index(tracesIndex).
+		Request(&search.Request{
+			Size: intPtr(0),
+			Query: &types.Query{
+				Bool: &types.BoolQuery{
+					Filter: []types.Query{
+						{
+							Range: map[string]types.RangeQuery{
+								"@timestamp": &types.DateRangeQuery{
+									Gte: strPtr(timeRangeGte),
+									Lte: strPtr("now"),
+								},
+							},
+						},
+						{
+							Term: map[string]types.TermQuery{
+								"processor.event": {
+									Value: "span",
+								},
+							},
+						},
+					},
+				},
+			},
+			Aggregations: map[string]types.Aggregations{
+				"apps": {
+					Terms: &types.TermsAggregation{
+						Field: &appNameField,
+						Size:  &size,
+					},
+					Aggregations: map[string]types.Aggregations{
+						// component handler spans are named after the component, the rest is filtered out by the caller
+						"spans": {
+							Terms: &types.TermsAggregation{
+								Field: &spanNameField,
+								Size:  &size,
+							},
+							Aggregations: map[string]types.Aggregations{
+								"avg_duration_us": {
+									Avg: &types.AverageAggregation{
+										Field: strPtr("span.duration.us"),
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		}).
+		Do(context.Background())
+
+	if err != nil {
+		return nil, fmt.Errorf("error querying span statistics: %w", err)
+	}
+
+	result := make(map[string]map[string]core.SpanStats)
+	appsInterface, exists := res.Aggregations["apps"]
+	if !exists || appsInterface == nil {
+		return result, nil
+	}
+	appsAgg, ok := appsInterface.(*types.StringTermsAggregate)
+	if !ok {
+		return nil, errors.New("incorrect aggregation type for apps")
+	}
+
+	for _, appBucket := range appsAgg.Buckets.([]types.StringTermsBucket) {
+		spansAgg, ok := appBucket.Aggregations["spans"].(*types.StringTermsAggregate)
+		if !ok {
+			continue
+		}
+		stats := make(map[string]core.SpanStats)
+		for _, spanBucket := range spansAgg.Buckets.([]types.StringTermsBucket) {
+			avgAgg, ok := spanBucket.Aggregations["avg_duration_us"].(*types.AvgAggregate)
+			if !ok || avgAgg.Value == nil {
+				continue
+			}
+			stats[spanBucket.Key.(string)] = core.SpanStats{
+				AvgDurationMs: float64(*avgAgg.Value) / 1000,
+				Count:         int(spanBucket.DocCount),
 			}
 		}
+		result[appBucket.Key.(string)] = stats
+	}
 
-		countInterface, ok := appBucket.Aggregations["trace_count"]
-		if ok && countInterface != nil {
-			if countAgg, ok := countInterface.(*types.CardinalityAggregate); ok {
-				countResult[appName] = int(countAgg.Value)
-			}
+	return result, nil
+}
+
+func (c metricsClient) QueryServiceMemoryUsage(timeRangeGte string) (map[string]float64, error) {
+	size := 1000
+	serviceField := "kubernetes.labels.serving_knative_dev/service"
+	memoryField := "k8s.pod.memory.usage"
+
+	res, err := c.client.Search().
+		Index(metricsIndex).
+		Request(&search.Request{
+			Size: intPtr(0),
+			Query: &types.Query{
+				Bool: &types.BoolQuery{
+					Filter: []types.Query{
+						{
+							Range: map[string]types.RangeQuery{
+								"@timestamp": &types.DateRangeQuery{
+									Gte: strPtr(timeRangeGte),
+									Lte: strPtr("now"),
+								},
+							},
+						},
+						{
+							Exists: &types.ExistsQuery{
+								Field: memoryField,
+							},
+						},
+					},
+				},
+			},
+			Aggregations: map[string]types.Aggregations{
+				"services": {
+					Terms: &types.TermsAggregation{
+						Field: &serviceField,
+						Size:  &size,
+					},
+					Aggregations: map[string]types.Aggregations{
+						"avg_memory": {
+							Avg: &types.AverageAggregation{
+								Field: &memoryField,
+							},
+						},
+					},
+				},
+			},
+		}).
+		Do(context.Background())
+
+	if err != nil {
+		return nil, fmt.Errorf("error querying pod memory usage: %w", err)
+	}
+
+	result := make(map[string]float64)
+	servicesInterface, exists := res.Aggregations["services"]
+	if !exists || servicesInterface == nil {
+		return result, nil
+	}
+	servicesAgg, ok := servicesInterface.(*types.StringTermsAggregate)
+	if !ok {
+		return nil, errors.New("incorrect aggregation type for services")
+	}
+
+	for _, bucket := range servicesAgg.Buckets.([]types.StringTermsBucket) {
+		avgAgg, ok := bucket.Aggregations["avg_memory"].(*types.AvgAggregate)
+		if !ok || avgAgg.Value == nil {
+			continue
 		}
+		result[bucket.Key.(string)] = float64(*avgAgg.Value) / (1024 * 1024) // bytes to MB
 	}
 
-	return metricResult, countResult, nil
+	return result, nil
 }
 
 func strPtr(s string) *string { return &s }
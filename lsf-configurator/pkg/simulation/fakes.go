@@ -0,0 +1,445 @@
+package simulation
+
+import (
+	"context"
+	"fmt"
+	"lsf-configurator/pkg/core"
+	"sort"
+	"sync"
+)
+
+type KnEventType string
+
+const (
+	KnEventDeploy KnEventType = "deploy"
+	KnEventDelete KnEventType = "delete"
+)
+
+// KnEvent is a call received by the fake knative client
+type KnEvent struct {
+	Type                  KnEventType
+	DeploymentId          string
+	FunctionCompositionId string
+	Node                  string
+}
+
+// KnClient keeps the deployed knative services in memory
+type KnClient struct {
+	mu       sync.Mutex
+	services map[string]core.Deployment     // deploymentId -> deployment
+	observed map[string]core.KnativeService // deploymentId -> service as listed by ListServices
+	events   []KnEvent
+	// DeployErr is returned by Deploy if set, it can be changed between ticks to simulate failing deployments
+	DeployErr error
+}
+
+func NewKnClient() *KnClient {
+	return &KnClient{services: make(map[string]core.Deployment), observed: make(map[string]core.KnativeService)}
+}
+
+func (k *KnClient) Init(ctx context.Context, fc core.FunctionComposition, runtime, sourcePath string) (string, error) {
+	return sourcePath, nil
+}
+
+func (k *KnClient) Deploy(ctx context.Context, deployment core.Deployment, image, appId string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.DeployErr != nil {
+		return k.DeployErr
+	}
+	k.services[deployment.Id] = deployment
+	k.observed[deployment.Id] = core.KnativeService{
+		Name:                  deployment.Id,
+		Namespace:             deployment.Namespace,
+		AppId:                 appId,
+		FunctionCompositionId: deployment.FunctionCompositionId,
+		Image:                 image,
+		Node:                  deployment.Node,
+		Scale:                 core.Scale{MinReplicas: deployment.Scale.MinReplicas, MaxReplicas: deployment.Scale.MaxReplicas},
+		Resources:             deployment.Resources,
+		Ready:                 true,
+	}
+	k.events = append(k.events, KnEvent{KnEventDeploy, deployment.Id, deployment.FunctionCompositionId, deployment.Node})
+	return nil
+}
+
+func (k *KnClient) Delete(ctx context.Context, deployment core.Deployment) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	delete(k.services, deployment.Id)
+	delete(k.observed, deployment.Id)
+	k.events = append(k.events, KnEvent{KnEventDelete, deployment.Id, deployment.FunctionCompositionId, deployment.Node})
+	return nil
+}
+
+// ListServices returns the deployed services, unordered like the listing of the cluster
+func (k *KnClient) ListServices(ctx context.Context) ([]core.KnativeService, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	services := make([]core.KnativeService, 0, len(k.observed))
+	for _, svc := range k.observed {
+		services = append(services, svc)
+	}
+	return services, nil
+}
+
+// RemoveService deletes the service without a delete call, like deleting it by hand
+func (k *KnClient) RemoveService(deploymentId string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	delete(k.services, deploymentId)
+	delete(k.observed, deploymentId)
+}
+
+// Services returns the currently deployed services ordered by node and id
+func (k *KnClient) Services() []core.Deployment {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	services := make([]core.Deployment, 0, len(k.services))
+	for _, d := range k.services {
+		services = append(services, d)
+	}
+	sort.Slice(services, func(i, j int) bool {
+		if services[i].Node != services[j].Node {
+			return services[i].Node < services[j].Node
+		}
+		return services[i].Id < services[j].Id
+	})
+	return services
+}
+
+// Events returns every deploy and delete call in the order they were received
+func (k *KnClient) Events() []KnEvent {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return append([]KnEvent(nil), k.events...)
+}
+
+// Builder finishes every build right away by notifying the composer, like the tekton webhook does after a real build
+type Builder struct {
+	mu     sync.Mutex
+	notify func(fcId, imageURL, status string)
+	builds []string
+}
+
+func (b *Builder) Build(ctx context.Context, fc core.FunctionComposition, buildDir string) error {
+	b.mu.Lock()
+	b.builds = append(b.builds, fc.Id)
+	notify := b.notify
+	b.mu.Unlock()
+	image := "sim/" + fc.Id
+	if fc.Build.SourceRevision > 0 {
+		image = fmt.Sprintf("%s:r%d", image, fc.Build.SourceRevision)
+	}
+	if notify != nil {
+		go notify(fc.Id, image, "succeeded")
+	}
+	return nil
+}
+
+func (b *Builder) NotifyBuildFinished() {}
+
+// Builds returns the ids of the function compositions built so far
+func (b *Builder) Builds() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.builds...)
+}
+
+// RoutingClient keeps the routing tables in memory
+type RoutingClient struct {
+	mu     sync.Mutex
+	tables map[string]core.RoutingTable // deploymentId -> routing table
+}
+
+func NewRoutingClient() *RoutingClient {
+	return &RoutingClient{tables: make(map[string]core.RoutingTable)}
+}
+
+func (r *RoutingClient) SetRoutingTable(deployment core.Deployment) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tables[deployment.Id] = deployment.RoutingTable
+	return nil
+}
+
+func (r *RoutingClient) DeleteRoutingTable(deploymentId string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.tables, deploymentId)
+	return nil
+}
+
+func (r *RoutingClient) RoutingTable(deploymentId string) (core.RoutingTable, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	table, ok := r.tables[deploymentId]
+	return table, ok
+}
+
+// DNSClient keeps the DNS records in memory
+type DNSClient struct {
+	mu      sync.Mutex
+	records map[string]string // namespace/appName -> target service
+}
+
+func NewDNSClient() *DNSClient {
+	return &DNSClient{records: make(map[string]string)}
+}
+
+func (d *DNSClient) EnsureDNSRecord(ctx context.Context, namespace, appName, targetServiceName string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.records[namespace+"/"+appName] = targetServiceName
+	return nil
+}
+
+func (d *DNSClient) DeleteDNSRecord(ctx context.Context, namespace, appName string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.records, namespace+"/"+appName)
+	return nil
+}
+
+// ReadinessProber reports every knative service ready right away, unless NotReadyErr is set
+type ReadinessProber struct {
+	mu     sync.Mutex
+	probed []string
+	// NotReadyErr is returned for every probe if set, it can be changed between ticks to simulate deployments which never become ready
+	NotReadyErr error
+}
+
+func (p *ReadinessProber) WaitForServiceReady(ctx context.Context, namespace, serviceName string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.probed = append(p.probed, serviceName)
+	return p.NotReadyErr
+}
+
+// Probed returns the services probed so far in the order they were probed
+func (p *ReadinessProber) Probed() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.probed...)
+}
+
+// MetricsReader serves the metrics set by the simulation script
+type MetricsReader struct {
+	mu          sync.Mutex
+	runtimes    map[string]float64
+	traceCounts map[string]int
+	// appId -> end component -> path runtime and trace count
+	pathRuntimes    map[string]map[string]float64
+	pathTraceCounts map[string]map[string]int
+	nodes           []core.NodeMetrics
+	spanStats       map[string]map[string]core.SpanStats
+	memoryUsage     map[string]float64
+	violations      map[string]map[string]latencyViolations // timeRange -> appId -> violations
+	health          map[string]core.AppHealth
+	// Err is returned by every query if set
+	Err error
+}
+
+func NewMetricsReader() *MetricsReader {
+	return &MetricsReader{
+		runtimes:        make(map[string]float64),
+		traceCounts:     make(map[string]int),
+		pathRuntimes:    make(map[string]map[string]float64),
+		pathTraceCounts: make(map[string]map[string]int),
+		spanStats:       make(map[string]map[string]core.SpanStats),
+		memoryUsage:     make(map[string]float64),
+		violations:      make(map[string]map[string]latencyViolations),
+		health:          make(map[string]core.AppHealth),
+	}
+}
+
+type latencyViolations struct {
+	ratio      float64
+	traceCount int
+}
+
+// SetRuntime sets the latency reported for the app, the same value is served for every metric type
+func (m *MetricsReader) SetRuntime(appId string, runtimeMs float64, traceCount int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.runtimes[appId] = runtimeMs
+	m.traceCounts[appId] = traceCount
+}
+
+// SetPathRuntime sets the runtime of the path to the end component of the app, regardless of the query
+func (m *MetricsReader) SetPathRuntime(appId, end string, runtimeMs float64, traceCount int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.pathRuntimes[appId] == nil {
+		m.pathRuntimes[appId] = make(map[string]float64)
+		m.pathTraceCounts[appId] = make(map[string]int)
+	}
+	m.pathRuntimes[appId][end] = runtimeMs
+	m.pathTraceCounts[appId][end] = traceCount
+}
+
+// ClearRuntime makes the app report no traces
+func (m *MetricsReader) ClearRuntime(appId string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.runtimes, appId)
+	delete(m.traceCounts, appId)
+}
+
+// SetLatencyViolations sets the fraction of the app's traces exceeding its latency limit within the time range, e.g. now-5m
+func (m *MetricsReader) SetLatencyViolations(appId, timeRange string, ratio float64, traceCount int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.violations[timeRange] == nil {
+		m.violations[timeRange] = make(map[string]latencyViolations)
+	}
+	m.violations[timeRange][appId] = latencyViolations{ratio: ratio, traceCount: traceCount}
+}
+
+// SetAppHealth sets the failed and unfinished trace counts of the app, the same values are served for every time range
+func (m *MetricsReader) SetAppHealth(appId string, health core.AppHealth) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.health[appId] = health
+}
+
+func (m *MetricsReader) SetNodeMetrics(nodes []core.NodeMetrics) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nodes = nodes
+}
+
+func (m *MetricsReader) SetSpanStats(appId string, stats map[string]core.SpanStats) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.spanStats[appId] = stats
+}
+
+// SetMemoryUsage sets the average pod memory usage in MB of a deployment
+func (m *MetricsReader) SetMemoryUsage(deploymentId string, memoryMb float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.memoryUsage[deploymentId] = memoryMb
+}
+
+func (m *MetricsReader) QueryNodeMetrics() ([]core.NodeMetrics, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	return append([]core.NodeMetrics(nil), m.nodes...), nil
+}
+
+func (m *MetricsReader) Query95thPercentileAppRuntimes(timeRangeGte string) (map[string]float64, map[string]int, error) {
+	return m.appRuntimes()
+}
+
+func (m *MetricsReader) QueryAverageAppRuntimes(timeRangeGte string) (map[string]float64, map[string]int, error) {
+	return m.appRuntimes()
+}
+
+func (m *MetricsReader) appRuntimes() (map[string]float64, map[string]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return nil, nil, m.Err
+	}
+	runtimes := make(map[string]float64, len(m.runtimes))
+	traceCounts := make(map[string]int, len(m.traceCounts))
+	for appId, rt := range m.runtimes {
+		runtimes[appId] = rt
+		traceCounts[appId] = m.traceCounts[appId]
+	}
+	return runtimes, traceCounts, nil
+}
+
+func (m *MetricsReader) QueryLatencyViolations(timeRangeGte string, latencyLimits map[string]int) (map[string]float64, map[string]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return nil, nil, m.Err
+	}
+	ratios := make(map[string]float64)
+	counts := make(map[string]int)
+	for appId, v := range m.violations[timeRangeGte] {
+		if _, ok := latencyLimits[appId]; !ok {
+			continue
+		}
+		ratios[appId] = v.ratio
+		counts[appId] = v.traceCount
+	}
+	return ratios, counts, nil
+}
+
+func (m *MetricsReader) QueryPathRuntimes(timeRangeGte string, metricType core.MetricType) (map[string]map[string]float64, map[string]map[string]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return nil, nil, m.Err
+	}
+	return clone(m.pathRuntimes), clone(m.pathTraceCounts), nil
+}
+
+func (m *MetricsReader) QueryAppHealth(timeRangeGte string) (map[string]core.AppHealth, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	health := make(map[string]core.AppHealth, len(m.health))
+	for appId, h := range m.health {
+		health[appId] = h
+	}
+	return health, nil
+}
+
+func (m *MetricsReader) QueryComponentSpanStats(timeRangeGte string) (map[string]map[string]core.SpanStats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	stats := make(map[string]map[string]core.SpanStats, len(m.spanStats))
+	for appId, s := range m.spanStats {
+		stats[appId] = s
+	}
+	return stats, nil
+}
+
+func (m *MetricsReader) QueryServiceMemoryUsage(timeRangeGte string) (map[string]float64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	usage := make(map[string]float64, len(m.memoryUsage))
+	for id, mb := range m.memoryUsage {
+		usage[id] = mb
+	}
+	return usage, nil
+}
+
+func (m *MetricsReader) EnsureIndex(ctx context.Context, indexName string) error {
+	return nil
+}
+
+// ScenarioManager returns fixed layout candidates instead of running the layout calculation
+type ScenarioManager struct {
+	Candidates map[string]core.Layout
+	Ladder     []core.LayoutLevel
+}
+
+func (s *ScenarioManager) GenerateLayoutCandidates(
+	components []core.Component,
+	links []core.ComponentLink,
+	rateLevels []float64,
+	appLatencyReq int,
+	pathLatencyReqs map[string]int,
+	memoryAvailable int,
+	freeMemory map[string]int) (map[string]core.Layout, []core.LayoutLevel, error) {
+	if len(s.Ladder) == 0 {
+		return nil, nil, fmt.Errorf("no layout candidates configured for the simulation")
+	}
+	return clone(s.Candidates), clone(s.Ladder), nil
+}
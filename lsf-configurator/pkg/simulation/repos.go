@@ -0,0 +1,305 @@
+package simulation
+
+import (
+	"encoding/json"
+	"lsf-configurator/pkg/core"
+	"sort"
+	"sync"
+	"time"
+)
+
+// clone deep-copies v through JSON, so callers never share state with the store, just like with the database
+func clone[T any](v T) T {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	var copied T
+	if err := json.Unmarshal(data, &copied); err != nil {
+		panic(err)
+	}
+	return copied
+}
+
+// store holds the rows of the in-memory repositories, rows are kept in insertion order like in the database
+type store struct {
+	mu              sync.Mutex
+	apps            map[string]*core.FunctionApp
+	appOrder        []string
+	compositions    map[string]*core.FunctionComposition
+	compOrder       []string
+	deployments     map[string]*core.Deployment
+	depOrder        []string
+	reconfigs       map[string]*core.ReconfigurationRecord
+	reconfigOrder   []string
+	controllerState map[string]*core.ControllerState
+	versions        map[string][]*core.AppVersion // appId -> versions ordered by version number
+}
+
+func newStore() *store {
+	return &store{
+		apps:            make(map[string]*core.FunctionApp),
+		compositions:    make(map[string]*core.FunctionComposition),
+		deployments:     make(map[string]*core.Deployment),
+		reconfigs:       make(map[string]*core.ReconfigurationRecord),
+		controllerState: make(map[string]*core.ControllerState),
+		versions:        make(map[string][]*core.AppVersion),
+	}
+}
+
+func remove(ids []string, id string) []string {
+	for i, existing := range ids {
+		if existing == id {
+			return append(ids[:i], ids[i+1:]...)
+		}
+	}
+	return ids
+}
+
+type functionAppRepo struct{ s *store }
+
+// Save stores the app and its compositions, the deployments of the compositions are stored separately
+func (r *functionAppRepo) Save(app *core.FunctionApp) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	row := clone(app)
+	row.Compositions = nil
+	if _, ok := r.s.apps[app.Id]; !ok {
+		r.s.appOrder = append(r.s.appOrder, app.Id)
+	}
+	r.s.apps[app.Id] = row
+	for _, fc := range app.Compositions {
+		r.s.saveComposition(fc)
+	}
+	return nil
+}
+
+func (r *functionAppRepo) GetByID(id string) (*core.FunctionApp, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	row, ok := r.s.apps[id]
+	if !ok {
+		return nil, nil
+	}
+	app := clone(row)
+	app.Compositions = make([]*core.FunctionComposition, 0)
+	for _, fcId := range r.s.compOrder {
+		if r.s.compositions[fcId].FunctionAppId == id {
+			app.Compositions = append(app.Compositions, r.s.getComposition(fcId))
+		}
+	}
+	return app, nil
+}
+
+// GetAll returns the apps without their compositions, like the database repository
+func (r *functionAppRepo) GetAll() ([]*core.FunctionApp, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	apps := make([]*core.FunctionApp, 0, len(r.s.appOrder))
+	for _, id := range r.s.appOrder {
+		apps = append(apps, clone(r.s.apps[id]))
+	}
+	return apps, nil
+}
+
+func (r *functionAppRepo) Delete(id string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	delete(r.s.apps, id)
+	r.s.appOrder = remove(r.s.appOrder, id)
+	return nil
+}
+
+type functionCompositionRepo struct{ s *store }
+
+func (r *functionCompositionRepo) Save(comp *core.FunctionComposition) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	r.s.saveComposition(comp)
+	return nil
+}
+
+func (r *functionCompositionRepo) GetByID(id string) (*core.FunctionComposition, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	if _, ok := r.s.compositions[id]; !ok {
+		return nil, nil
+	}
+	return r.s.getComposition(id), nil
+}
+
+func (r *functionCompositionRepo) Delete(id string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	delete(r.s.compositions, id)
+	r.s.compOrder = remove(r.s.compOrder, id)
+	return nil
+}
+
+func (s *store) saveComposition(comp *core.FunctionComposition) {
+	row := clone(comp)
+	row.Deployments = nil
+	if _, ok := s.compositions[comp.Id]; !ok {
+		s.compOrder = append(s.compOrder, comp.Id)
+	}
+	s.compositions[comp.Id] = row
+}
+
+func (s *store) getComposition(id string) *core.FunctionComposition {
+	comp := clone(s.compositions[id])
+	comp.Deployments = s.deploymentsOf(id)
+	return comp
+}
+
+func (s *store) deploymentsOf(fcId string) []*core.Deployment {
+	var deployments []*core.Deployment
+	for _, depId := range s.depOrder {
+		if s.deployments[depId].FunctionCompositionId == fcId {
+			deployments = append(deployments, clone(s.deployments[depId]))
+		}
+	}
+	return deployments
+}
+
+type deploymentRepo struct{ s *store }
+
+func (r *deploymentRepo) Save(deployment *core.Deployment) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	if _, ok := r.s.deployments[deployment.Id]; !ok {
+		r.s.depOrder = append(r.s.depOrder, deployment.Id)
+	}
+	r.s.deployments[deployment.Id] = clone(deployment)
+	return nil
+}
+
+func (r *deploymentRepo) GetByID(id string) (*core.Deployment, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	row, ok := r.s.deployments[id]
+	if !ok {
+		return nil, nil
+	}
+	return clone(row), nil
+}
+
+func (r *deploymentRepo) GetByFunctionCompositionID(functionCompositionID string) ([]*core.Deployment, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	return r.s.deploymentsOf(functionCompositionID), nil
+}
+
+func (r *deploymentRepo) GetByFunctionAppID(functionAppID string) ([]*core.Deployment, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	var deployments []*core.Deployment
+	for _, fcId := range r.s.compOrder {
+		if r.s.compositions[fcId].FunctionAppId == functionAppID {
+			deployments = append(deployments, r.s.deploymentsOf(fcId)...)
+		}
+	}
+	return deployments, nil
+}
+
+func (r *deploymentRepo) Delete(id string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	delete(r.s.deployments, id)
+	r.s.depOrder = remove(r.s.depOrder, id)
+	return nil
+}
+
+type reconfigurationRepo struct{ s *store }
+
+func (r *reconfigurationRepo) Save(record *core.ReconfigurationRecord) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	if _, ok := r.s.reconfigs[record.Id]; !ok {
+		r.s.reconfigOrder = append(r.s.reconfigOrder, record.Id)
+	}
+	r.s.reconfigs[record.Id] = clone(record)
+	return nil
+}
+
+func (r *reconfigurationRepo) GetByFunctionAppID(functionAppID string, from, to time.Time) ([]*core.ReconfigurationRecord, error) {
+	return r.query(func(record *core.ReconfigurationRecord) bool {
+		return record.FunctionAppId == functionAppID &&
+			(from.IsZero() || !record.StartTime.Before(from)) &&
+			(to.IsZero() || !record.StartTime.After(to))
+	}), nil
+}
+
+func (r *reconfigurationRepo) GetInProgress() ([]*core.ReconfigurationRecord, error) {
+	return r.query(func(record *core.ReconfigurationRecord) bool {
+		return record.Outcome == core.ReconfigurationInProgress
+	}), nil
+}
+
+func (r *reconfigurationRepo) query(match func(record *core.ReconfigurationRecord) bool) []*core.ReconfigurationRecord {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	var records []*core.ReconfigurationRecord
+	for _, id := range r.s.reconfigOrder {
+		if record := r.s.reconfigs[id]; match(record) {
+			records = append(records, clone(record))
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].StartTime.Before(records[j].StartTime)
+	})
+	return records
+}
+
+type appVersionRepo struct{ s *store }
+
+func (r *appVersionRepo) Save(version *core.AppVersion) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	versions := r.s.versions[version.FunctionAppId]
+	for i, v := range versions {
+		if v.Id == version.Id || v.Version == version.Version {
+			versions[i] = clone(version)
+			return nil
+		}
+	}
+	versions = append(versions, clone(version))
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].Version < versions[j].Version
+	})
+	r.s.versions[version.FunctionAppId] = versions
+	return nil
+}
+
+func (r *appVersionRepo) GetByFunctionAppID(functionAppID string) ([]*core.AppVersion, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	versions := make([]*core.AppVersion, 0, len(r.s.versions[functionAppID]))
+	for _, v := range r.s.versions[functionAppID] {
+		versions = append(versions, clone(v))
+	}
+	return versions, nil
+}
+
+type controllerStateRepo struct{ s *store }
+
+func (r *controllerStateRepo) Save(state *core.ControllerState) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	r.s.controllerState[state.FunctionAppId] = clone(state)
+	return nil
+}
+
+func (r *controllerStateRepo) GetAll() ([]*core.ControllerState, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	ids := make([]string, 0, len(r.s.controllerState))
+	for id := range r.s.controllerState {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	states := make([]*core.ControllerState, 0, len(ids))
+	for _, id := range ids {
+		states = append(states, clone(r.s.controllerState[id]))
+	}
+	return states, nil
+}
@@ -0,0 +1,220 @@
+// Package simulation runs the latency controller against in-memory backends and a virtual clock,
+// so the reconfigurations caused by a scripted metric series can be replayed deterministically.
+package simulation
+
+import (
+	"fmt"
+	"lsf-configurator/pkg/core"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SimulationImage is the image of the prebuilt function compositions of simulated apps
+const SimulationImage = "sim/prebuilt"
+
+type Config struct {
+	Start                 time.Time     // initial time of the virtual clock, zero means 2025-01-01 UTC
+	Interval              time.Duration // virtual time between controller ticks, zero means 1s
+	Namespace             string
+	AvailableNodeMemoryGb int
+	Defaults              core.ControllerSettings
+	Profiler              core.ProfilerSettings
+	Placement             core.PlacementSettings
+	// MaxConcurrentTransitions caps the transitions started per tick, deployments finish within the tick in simulations
+	MaxConcurrentTransitions int
+}
+
+// Simulation wires a synchronous latency controller to the fakes, every tick finishes its deployments before returning
+type Simulation struct {
+	Clock           *VirtualClock
+	KnClient        *KnClient
+	Builder         *Builder
+	RoutingClient   *RoutingClient
+	DNSClient       *DNSClient
+	Readiness       *ReadinessProber
+	Metrics         *MetricsReader
+	ScenarioManager *ScenarioManager
+	Composer        *core.Composer
+	Controller      core.Controller
+	Reconciler      core.Reconciler
+
+	FunctionApps     core.FunctionAppRepository
+	Compositions     core.FunctionCompositionRepository
+	Deployments      core.DeploymentRepository
+	Reconfigurations core.ReconfigurationRepository
+	ControllerStates core.ControllerStateRepository
+	Versions         core.AppVersionRepository
+
+	interval time.Duration
+	tick     int
+}
+
+// Step is the state of the simulated apps after a tick
+type Step struct {
+	Tick          int
+	Time          time.Time
+	ActiveLayouts map[string]string // appId -> active layout key
+}
+
+// Script is called before every tick to set the metrics the controller will read
+type Script func(tick int, sim *Simulation)
+
+func New(conf Config) *Simulation {
+	if conf.Start.IsZero() {
+		conf.Start = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	if conf.Interval == 0 {
+		conf.Interval = time.Second
+	}
+	if conf.Defaults.MetricType == "" {
+		conf.Defaults.MetricType = core.MetricTypeP95
+	}
+
+	st := newStore()
+	sim := &Simulation{
+		Clock:            NewVirtualClock(conf.Start),
+		KnClient:         NewKnClient(),
+		Builder:          &Builder{},
+		RoutingClient:    NewRoutingClient(),
+		DNSClient:        NewDNSClient(),
+		Readiness:        &ReadinessProber{},
+		Metrics:          NewMetricsReader(),
+		ScenarioManager:  &ScenarioManager{},
+		FunctionApps:     &functionAppRepo{st},
+		Compositions:     &functionCompositionRepo{st},
+		Deployments:      &deploymentRepo{st},
+		Reconfigurations: &reconfigurationRepo{st},
+		ControllerStates: &controllerStateRepo{st},
+		Versions:         &appVersionRepo{st},
+		interval:         conf.Interval,
+	}
+	sim.Composer = core.NewComposer(sim.FunctionApps, sim.Compositions, sim.Deployments, sim.RoutingClient,
+		sim.KnClient, sim.Builder, sim.Metrics, sim.DNSClient)
+	sim.Builder.notify = sim.Composer.NotifyBuildReady
+	sim.Composer.SetReadinessProber(sim.Readiness, 0)
+	sim.Controller = core.NewController(sim.Composer, sim.Metrics, sim.ScenarioManager, sim.Reconfigurations, sim.ControllerStates, sim.Versions,
+		conf.Interval, conf.Namespace, conf.AvailableNodeMemoryGb, conf.Defaults, conf.Profiler, conf.Placement,
+		core.WithClock(sim.Clock), core.WithSynchronousDeployments(),
+		core.WithMaxConcurrentTransitions(conf.MaxConcurrentTransitions))
+	sim.Reconciler = core.NewReconciler(sim.Composer, sim.KnClient, conf.Interval, true, core.WithReconcilerClock(sim.Clock))
+	return sim
+}
+
+// AddApp stores the app with its layout candidates, creates prebuilt compositions for every candidate
+// and deploys the active layout, which is recorded as a manual reconfiguration
+func (sim *Simulation) AddApp(app *core.FunctionApp) error {
+	if len(app.LayoutLadder) == 0 || len(app.LayoutCandidates) == 0 {
+		return fmt.Errorf("app %s has no layout candidates", app.Id)
+	}
+	if app.ActiveLayoutKey == "" {
+		app.ActiveLayoutKey = app.LayoutLadder[0].Key
+	}
+	if err := sim.FunctionApps.Save(app); err != nil {
+		return err
+	}
+
+	created := make(map[string]bool)
+	for _, level := range app.LayoutLadder {
+		for _, info := range app.LayoutCandidates[level.Key] {
+			names := make([]string, len(info.ComponentProfiles))
+			for i, cp := range info.ComponentProfiles {
+				names[i] = cp.Name
+			}
+			sorted := append([]string(nil), names...)
+			sort.Strings(sorted)
+			key := strings.Join(sorted, ",")
+			if created[key] {
+				continue
+			}
+			if _, err := sim.Composer.AddFunctionComposition(app.Id, names, SimulationImage); err != nil {
+				return err
+			}
+			created[key] = true
+		}
+	}
+	return sim.Controller.SetActiveLayout(app.Id, app.ActiveLayoutKey)
+}
+
+// Run advances the virtual clock by the configured interval and runs a controller tick, ticks times.
+// The script, if not nil, is called before each tick.
+func (sim *Simulation) Run(ticks int, script Script) ([]Step, error) {
+	steps := make([]Step, 0, ticks)
+	for i := 0; i < ticks; i++ {
+		sim.tick++
+		sim.Clock.Advance(sim.interval)
+		if script != nil {
+			script(sim.tick, sim)
+		}
+		sim.Controller.Tick()
+
+		step, err := sim.snapshot()
+		if err != nil {
+			return steps, err
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+func (sim *Simulation) snapshot() (Step, error) {
+	apps, err := sim.FunctionApps.GetAll()
+	if err != nil {
+		return Step{}, err
+	}
+	step := Step{Tick: sim.tick, Time: sim.Clock.Now(), ActiveLayouts: make(map[string]string, len(apps))}
+	for _, app := range apps {
+		step.ActiveLayouts[app.Id] = app.ActiveLayoutKey
+	}
+	return step, nil
+}
+
+// LatencySeries returns a script which reports values[tick-1] as the latency of the app, with traceCount traces.
+// Negative values and ticks past the end of the series report no traces.
+func LatencySeries(appId string, traceCount int, values ...float64) Script {
+	return func(tick int, sim *Simulation) {
+		if tick > len(values) || values[tick-1] < 0 {
+			sim.Metrics.ClearRuntime(appId)
+			return
+		}
+		sim.Metrics.SetRuntime(appId, values[tick-1], traceCount)
+	}
+}
+
+// Transitions returns the layout keys the app was moved to, in order, including the initial deployment
+func (sim *Simulation) Transitions(appId string) ([]string, error) {
+	records, err := sim.Reconfigurations.GetByFunctionAppID(appId, time.Time{}, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, record := range records {
+		if record.Outcome == core.ReconfigurationSucceeded {
+			keys = append(keys, record.ToLayoutKey)
+		}
+	}
+	return keys, nil
+}
+
+// ActiveDeployments returns the deployed services of the app per node
+func (sim *Simulation) ActiveDeployments(appId string) (map[string][]core.Deployment, error) {
+	app, err := sim.Composer.GetFunctionApp(appId)
+	if err != nil {
+		return nil, err
+	}
+	if app == nil {
+		return nil, fmt.Errorf("app %s does not exist", appId)
+	}
+	compositions := make(map[string]bool, len(app.Compositions))
+	for _, fc := range app.Compositions {
+		compositions[fc.Id] = true
+	}
+
+	byNode := make(map[string][]core.Deployment)
+	for _, d := range sim.KnClient.Services() {
+		if compositions[d.FunctionCompositionId] {
+			byNode[d.Node] = append(byNode[d.Node], d)
+		}
+	}
+	return byNode, nil
+}
@@ -0,0 +1,466 @@
+package simulation
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"lsf-configurator/pkg/core"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestSimulation runs app "app" with components a and b and a ladder of three layouts: both components on n1,
+// the same with more replicas, and each component on its own node
+func newTestSimulation(t *testing.T, policy string) *Simulation {
+	t.Helper()
+	sim := New(Config{
+		Namespace:             "sim",
+		AvailableNodeMemoryGb: 8,
+		Defaults:              core.ControllerSettings{CooldownSeconds: ptr(2), DowngradeFactor: ptr(0.5), MinimalTraceCount: ptr(5)},
+	})
+	profile := func(name string) core.ComponentProfile {
+		return core.ComponentProfile{Name: name, Runtime: 10, Memory: 128, RequiredReplicas: 1}
+	}
+	combined := core.Layout{
+		"n1": {ComponentProfiles: []core.ComponentProfile{profile("a"), profile("b")}, RequiredReplicas: 1, Memory: 256, MCPU: 100, TargetConcurrency: 1},
+	}
+	scaled := core.Layout{
+		"n1": {ComponentProfiles: []core.ComponentProfile{profile("a"), profile("b")}, RequiredReplicas: 2, Memory: 256, MCPU: 100, TargetConcurrency: 1},
+	}
+	split := core.Layout{
+		"n1": {ComponentProfiles: []core.ComponentProfile{profile("a")}, RequiredReplicas: 2, Memory: 128, MCPU: 100, TargetConcurrency: 1},
+		"n2": {ComponentProfiles: []core.ComponentProfile{profile("b")}, RequiredReplicas: 2, Memory: 128, MCPU: 100, TargetConcurrency: 1},
+	}
+	app := &core.FunctionApp{
+		Id:               "app",
+		Name:             "app",
+		Runtime:          "python",
+		LatencyLimit:     100,
+		ControllerPolicy: policy,
+		Components:       []core.Component{{Name: "a", Memory: 128, Runtime: 10}, {Name: "b", Memory: 128, Runtime: 10}},
+		Links:            []core.ComponentLink{{From: "a", To: "b", InvocationRate: core.InvocationRate{Min: 1, Max: 10}}},
+		LayoutCandidates: map[string]core.Layout{"combined": combined, "scaled": scaled, "split": split},
+		LayoutLadder: []core.LayoutLevel{
+			{Key: "combined", RateLevel: 0, IngressRate: 1},
+			{Key: "scaled", RateLevel: 0.5, IngressRate: 5},
+			{Key: "split", RateLevel: 1, IngressRate: 10},
+		},
+		RateLevels: []float64{0, 0.5, 1},
+	}
+	if err := sim.AddApp(app); err != nil {
+		t.Fatalf("failed to add app: %v", err)
+	}
+	return sim
+}
+
+func ptr[T any](v T) *T { return &v }
+
+// series repeats value count times, so scripted series read as phases
+func series(phases ...[2]float64) []float64 {
+	var values []float64
+	for _, phase := range phases {
+		for i := 0; i < int(phase[1]); i++ {
+			values = append(values, phase[0])
+		}
+	}
+	return values
+}
+
+// deployedComponents returns the components of the app's deployed services per node, like "a,b"
+func deployedComponents(t *testing.T, sim *Simulation) map[string][]string {
+	t.Helper()
+	byNode, err := sim.ActiveDeployments("app")
+	if err != nil {
+		t.Fatalf("failed to get active deployments: %v", err)
+	}
+	components := make(map[string][]string, len(byNode))
+	for node, deployments := range byNode {
+		for _, d := range deployments {
+			fc, err := sim.Compositions.GetByID(d.FunctionCompositionId)
+			if err != nil || fc == nil {
+				t.Fatalf("deployment %s has no function composition %s: %v", d.Id, d.FunctionCompositionId, err)
+			}
+			names := append([]string(nil), fc.Components...)
+			sort.Strings(names)
+			components[node] = append(components[node], strings.Join(names, ","))
+		}
+		sort.Strings(components[node])
+	}
+	return components
+}
+
+// recordOutcomes returns the action and outcome of every reconfiguration of the app, like "upgrade:succeeded"
+func recordOutcomes(t *testing.T, sim *Simulation) []string {
+	t.Helper()
+	records, err := sim.Reconfigurations.GetByFunctionAppID("app", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("failed to get reconfigurations: %v", err)
+	}
+	outcomes := make([]string, len(records))
+	for i, record := range records {
+		outcomes[i] = fmt.Sprintf("%s:%s", record.Action, record.Outcome)
+	}
+	return outcomes
+}
+
+// updateApp changes the stored app, the controller reads it again on the next tick
+func updateApp(t *testing.T, sim *Simulation, update func(app *core.FunctionApp)) {
+	t.Helper()
+	app, err := sim.FunctionApps.GetByID("app")
+	if err != nil || app == nil {
+		t.Fatalf("failed to get app: %v", err)
+	}
+	update(app)
+	if err := sim.FunctionApps.Save(app); err != nil {
+		t.Fatalf("failed to save app: %v", err)
+	}
+}
+
+// withSource gives the app a source directory holding its component files, so its source can be updated
+func withSource(t *testing.T, sim *Simulation) {
+	t.Helper()
+	dir := t.TempDir()
+	for _, name := range []string{"a.py", "b.py"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("def handler(): pass\n"), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	updateApp(t, sim, func(app *core.FunctionApp) { app.SourcePath = dir })
+}
+
+// updateSource uploads the files, given as name -> content, as the next source revision of the app
+func updateSource(t *testing.T, sim *Simulation, files map[string]string) {
+	t.Helper()
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for name, content := range files {
+		part, err := w.CreateFormFile("files", name)
+		if err != nil {
+			t.Fatalf("failed to add %s: %v", name, err)
+		}
+		part.Write([]byte(content))
+	}
+	w.Close()
+	form, err := multipart.NewReader(&body, w.Boundary()).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatalf("failed to read form: %v", err)
+	}
+	t.Cleanup(func() { form.RemoveAll() })
+	if _, err := sim.Controller.UpdateSource("app", form.File["files"]); err != nil {
+		t.Fatalf("failed to update source: %v", err)
+	}
+}
+
+// compositionImages returns the image of every composition of the app by its components, like "a,b"
+func compositionImages(t *testing.T, sim *Simulation) map[string]string {
+	t.Helper()
+	app, err := sim.Composer.GetFunctionApp("app")
+	if err != nil || app == nil {
+		t.Fatalf("failed to get app: %v", err)
+	}
+	images := make(map[string]string, len(app.Compositions))
+	for _, fc := range app.Compositions {
+		names := append([]string(nil), fc.Components...)
+		sort.Strings(names)
+		images[strings.Join(names, ",")] = fc.Build.Image
+	}
+	return images
+}
+
+// addHog deploys app "hog" with a single component taking all but 192MB of n2 in its active layout "big",
+// and a layout "small" on n1 which frees n2 again
+func addHog(t *testing.T, sim *Simulation) {
+	t.Helper()
+	layout := func(node string, memory int) core.Layout {
+		return core.Layout{node: {
+			ComponentProfiles: []core.ComponentProfile{{Name: "h", Runtime: 10, Memory: memory, RequiredReplicas: 1}},
+			RequiredReplicas:  1, Memory: memory, MCPU: 100, TargetConcurrency: 1,
+		}}
+	}
+	hog := &core.FunctionApp{
+		Id:               "hog",
+		Name:             "hog",
+		Runtime:          "python",
+		LatencyLimit:     100,
+		ControllerPaused: true,
+		Components:       []core.Component{{Name: "h", Memory: 128, Runtime: 10}},
+		LayoutCandidates: map[string]core.Layout{"small": layout("n1", 128), "big": layout("n2", 8*1024-192)},
+		LayoutLadder:     []core.LayoutLevel{{Key: "small", RateLevel: 0}, {Key: "big", RateLevel: 1}},
+		RateLevels:       []float64{0, 1},
+		ActiveLayoutKey:  "big",
+	}
+	if err := sim.AddApp(hog); err != nil {
+		t.Fatalf("failed to add app hog: %v", err)
+	}
+}
+
+func TestLatencySeries(t *testing.T) {
+	const silent = -1 // no traces reported
+
+	tests := []struct {
+		name        string
+		policy      string
+		traceCount  int
+		latencies   []float64
+		actions     map[int]func(t *testing.T, sim *Simulation) // run before the tick, after its latency was set
+		transitions []string
+		outcomes    []string // action:outcome of every reconfiguration record, not checked if nil
+		deployed    map[string][]string
+		check       func(t *testing.T, sim *Simulation)
+	}{
+		{
+			name:        "latency within the limit keeps the initial layout",
+			policy:      core.PolicyThreshold,
+			traceCount:  10,
+			latencies:   series([2]float64{80, 10}),
+			transitions: []string{"combined"},
+			deployed:    map[string][]string{"n1": {"a,b"}},
+		},
+		{
+			name:        "threshold upgrades one level per cooldown",
+			policy:      core.PolicyThreshold,
+			traceCount:  10,
+			latencies:   series([2]float64{150, 6}),
+			transitions: []string{"combined", "scaled", "split"},
+			deployed:    map[string][]string{"n1": {"a"}, "n2": {"b"}},
+		},
+		{
+			name:        "too few traces never upgrade",
+			policy:      core.PolicyThreshold,
+			traceCount:  2,
+			latencies:   series([2]float64{150, 6}),
+			transitions: []string{"combined"},
+			deployed:    map[string][]string{"n1": {"a,b"}},
+		},
+		{
+			name:        "silent app is downgraded after the upgrade",
+			policy:      core.PolicyThreshold,
+			traceCount:  10,
+			latencies:   series([2]float64{150, 2}, [2]float64{silent, 20}),
+			transitions: []string{"combined", "scaled", "combined"},
+			deployed:    map[string][]string{"n1": {"a,b"}},
+		},
+		{
+			name:        "hysteresis waits for consecutive samples above the limit",
+			policy:      core.PolicyHysteresis,
+			traceCount:  10,
+			latencies:   series([2]float64{150, 6}),
+			transitions: []string{"combined", "scaled"},
+			deployed:    map[string][]string{"n1": {"a,b"}},
+		},
+		{
+			name:        "hysteresis resets on a sample inside the band",
+			policy:      core.PolicyHysteresis,
+			traceCount:  10,
+			latencies:   series([2]float64{150, 4}, [2]float64{80, 1}, [2]float64{150, 4}),
+			transitions: []string{"combined"},
+			deployed:    map[string][]string{"n1": {"a,b"}},
+		},
+		{
+			name:        "pid holds while no runtime is reported after an upgrade",
+			policy:      core.PolicyPID,
+			traceCount:  10,
+			latencies:   series([2]float64{150, 2}, [2]float64{silent, 10}),
+			transitions: []string{"combined", "scaled"},
+			deployed:    map[string][]string{"n1": {"a,b"}},
+		},
+		{
+			name:       "upgrade is postponed while the layout does not fit onto the nodes",
+			policy:     core.PolicyThreshold,
+			traceCount: 10,
+			latencies:  series([2]float64{150, 35}),
+			actions: map[int]func(t *testing.T, sim *Simulation){
+				0: addHog,
+				10: func(t *testing.T, sim *Simulation) {
+					if err := sim.Controller.SetActiveLayout("hog", "small"); err != nil {
+						t.Fatalf("failed to move app hog: %v", err)
+					}
+				},
+			},
+			transitions: []string{"combined", "scaled", "split"},
+			outcomes:    []string{"manual:succeeded", "upgrade:succeeded", "upgrade:postponed", "upgrade:succeeded"},
+			deployed:    map[string][]string{"n1": {"a"}, "n2": {"b"}},
+		},
+		{
+			name:       "layout which does not become ready is rolled back",
+			policy:     core.PolicyThreshold,
+			traceCount: 10,
+			latencies:  series([2]float64{150, 4}),
+			actions: map[int]func(t *testing.T, sim *Simulation){
+				3: func(t *testing.T, sim *Simulation) { sim.Readiness.NotReadyErr = errors.New("revision failed") },
+			},
+			transitions: []string{"combined", "scaled"},
+			outcomes:    []string{"manual:succeeded", "upgrade:succeeded", "upgrade:rolled_back"},
+			deployed:    map[string][]string{"n1": {"a,b"}},
+		},
+		{
+			name:       "requests failing after an upgrade roll the app back",
+			policy:     core.PolicyThreshold,
+			traceCount: 10,
+			latencies:  series([2]float64{150, 2}, [2]float64{80, 20}),
+			actions: map[int]func(t *testing.T, sim *Simulation){
+				0: func(t *testing.T, sim *Simulation) {
+					updateApp(t, sim, func(app *core.FunctionApp) {
+						app.ControllerSettings = &core.ControllerSettings{ErrorRateThreshold: ptr(0.1)}
+					})
+				},
+				4: func(t *testing.T, sim *Simulation) {
+					sim.Metrics.SetAppHealth("app", core.AppHealth{TraceCount: 100, ErrorCount: 50})
+				},
+				18: func(t *testing.T, sim *Simulation) { sim.Metrics.SetAppHealth("app", core.AppHealth{TraceCount: 100}) },
+			},
+			transitions: []string{"combined", "scaled", "combined"},
+			outcomes:    []string{"manual:succeeded", "upgrade:succeeded", "rollback:succeeded"},
+			deployed:    map[string][]string{"n1": {"a,b"}},
+		},
+		{
+			name:       "slo burn rate upgrades once per short window on the new layout",
+			policy:     core.PolicySLO,
+			traceCount: 10,
+			latencies:  series([2]float64{silent, 500}),
+			actions: map[int]func(t *testing.T, sim *Simulation){
+				0: func(t *testing.T, sim *Simulation) {
+					updateApp(t, sim, func(app *core.FunctionApp) {
+						app.ControllerSettings = &core.ControllerSettings{SLOTarget: 0.99, SLOPeriodDays: 30}
+					})
+					for _, timeRange := range []string{"now-1h", "now-5m", "now-6h", "now-30m"} {
+						sim.Metrics.SetLatencyViolations("app", timeRange, 0.5, 10)
+					}
+				},
+			},
+			transitions: []string{"combined", "scaled"},
+			deployed:    map[string][]string{"n1": {"a,b"}},
+		},
+		{
+			name:       "source update which fails to deploy keeps the previous images",
+			policy:     core.PolicyThreshold,
+			traceCount: 10,
+			latencies:  series([2]float64{80, 4}),
+			actions: map[int]func(t *testing.T, sim *Simulation){
+				0: withSource,
+				2: func(t *testing.T, sim *Simulation) {
+					sim.KnClient.DeployErr = errors.New("admission webhook denied the request")
+					updateSource(t, sim, map[string]string{"a.py": "def handler(): return 1\n"})
+				},
+			},
+			transitions: []string{"combined"},
+			outcomes:    []string{"manual:succeeded", "source_update:rolled_back"},
+			deployed:    map[string][]string{"n1": {"a,b"}},
+			check: func(t *testing.T, sim *Simulation) {
+				want := map[string]string{"a,b": SimulationImage, "a": SimulationImage, "b": SimulationImage}
+				if images := compositionImages(t, sim); !reflect.DeepEqual(images, want) {
+					t.Errorf("composition images = %v, want %v", images, want)
+				}
+			},
+		},
+		{
+			name:       "version rollback redeploys the images of the version and keeps them if it fails",
+			policy:     core.PolicyThreshold,
+			traceCount: 10,
+			latencies:  series([2]float64{80, 6}),
+			actions: map[int]func(t *testing.T, sim *Simulation){
+				0: withSource,
+				2: func(t *testing.T, sim *Simulation) {
+					updateSource(t, sim, map[string]string{"b.py": "def handler(): return 1\n"})
+				},
+				4: func(t *testing.T, sim *Simulation) {
+					if _, err := sim.Controller.RollbackToVersion("app", 1); err != nil {
+						t.Fatalf("failed to roll back to version 1: %v", err)
+					}
+				},
+				6: func(t *testing.T, sim *Simulation) {
+					sim.KnClient.DeployErr = errors.New("admission webhook denied the request")
+					if _, err := sim.Controller.RollbackToVersion("app", 2); err != nil {
+						t.Fatalf("failed to roll back to version 2: %v", err)
+					}
+				},
+			},
+			transitions: []string{"combined", "combined", "combined"},
+			outcomes:    []string{"manual:succeeded", "source_update:succeeded", "version_rollback:succeeded", "version_rollback:rolled_back"},
+			deployed:    map[string][]string{"n1": {"a,b"}},
+			check: func(t *testing.T, sim *Simulation) {
+				want := map[string]string{"a,b": SimulationImage, "a": SimulationImage, "b": SimulationImage}
+				if images := compositionImages(t, sim); !reflect.DeepEqual(images, want) {
+					t.Errorf("composition images = %v, want %v", images, want)
+				}
+			},
+		},
+		{
+			name:       "reconciler backs off and gives up on a deployment which fails to redeploy",
+			policy:     core.PolicyThreshold,
+			traceCount: 10,
+			latencies:  series([2]float64{80, 1}),
+			actions: map[int]func(t *testing.T, sim *Simulation){
+				0: func(t *testing.T, sim *Simulation) {
+					byNode, err := sim.ActiveDeployments("app")
+					if err != nil {
+						t.Fatalf("failed to get active deployments: %v", err)
+					}
+					sim.KnClient.RemoveService(byNode["n1"][0].Id)
+					sim.KnClient.DeployErr = errors.New("admission webhook denied the request")
+				},
+			},
+			transitions: []string{"combined"},
+			deployed:    map[string][]string{},
+			check: func(t *testing.T, sim *Simulation) {
+				var attempts []int
+				for i := 0; i < 60; i++ {
+					sim.Clock.Advance(time.Second)
+					for _, d := range sim.Reconciler.Reconcile(context.Background()).ForApp("app").Drifts {
+						if d.Action == "redeploy" && d.Error != "" && (len(attempts) == 0 || attempts[len(attempts)-1] != d.Attempts) {
+							attempts = append(attempts, d.Attempts)
+						}
+					}
+				}
+				if want := []int{1, 2, 3, 4, 5}; !reflect.DeepEqual(attempts, want) {
+					t.Errorf("failed redeploy attempts = %v, want %v", attempts, want)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sim := newTestSimulation(t, tt.policy)
+			latency := LatencySeries("app", tt.traceCount, tt.latencies...)
+			script := func(tick int, sim *Simulation) {
+				latency(tick, sim)
+				if action := tt.actions[tick]; action != nil {
+					action(t, sim)
+				}
+			}
+			if action := tt.actions[0]; action != nil {
+				action(t, sim)
+			}
+			if _, err := sim.Run(len(tt.latencies), script); err != nil {
+				t.Fatalf("simulation failed: %v", err)
+			}
+
+			transitions, err := sim.Transitions("app")
+			if err != nil {
+				t.Fatalf("failed to get transitions: %v", err)
+			}
+			if !reflect.DeepEqual(transitions, tt.transitions) {
+				t.Errorf("transitions = %v, want %v", transitions, tt.transitions)
+			}
+			if tt.outcomes != nil {
+				if outcomes := recordOutcomes(t, sim); !reflect.DeepEqual(outcomes, tt.outcomes) {
+					t.Errorf("reconfiguration outcomes = %v, want %v", outcomes, tt.outcomes)
+				}
+			}
+			if deployed := deployedComponents(t, sim); !reflect.DeepEqual(deployed, tt.deployed) {
+				t.Errorf("deployed components = %v, want %v", deployed, tt.deployed)
+			}
+			if tt.check != nil {
+				tt.check(t, sim)
+			}
+		})
+	}
+}
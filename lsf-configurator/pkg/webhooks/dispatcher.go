@@ -0,0 +1,155 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"lsf-configurator/pkg/core"
+	"lsf-configurator/pkg/uuid"
+	"net/http"
+	"time"
+)
+
+const (
+	EventHeader     = "X-LSF-Event"
+	DeliveryHeader  = "X-LSF-Delivery"
+	SignatureHeader = "X-LSF-Signature" // sha256=<hex encoded HMAC-SHA256 of the body, keyed with the subscription's secret>
+
+	maxAttempts    = 5
+	initialBackoff = 2 * time.Second
+	maxBackoff     = time.Minute
+	requestTimeout = 10 * time.Second
+	queueSize      = 256
+)
+
+// Dispatcher posts the events of the composer and the controller to the webhook subscribers.
+// Failed deliveries are retried with exponential backoff, every attempt is recorded in the repository.
+type Dispatcher struct {
+	repo    core.WebhookRepository
+	client  *http.Client
+	queue   chan core.Event
+	backoff time.Duration
+}
+
+func NewDispatcher(repo core.WebhookRepository) *Dispatcher {
+	return &Dispatcher{
+		repo:    repo,
+		client:  &http.Client{Timeout: requestTimeout},
+		queue:   make(chan core.Event, queueSize),
+		backoff: initialBackoff,
+	}
+}
+
+// Notify queues the event for delivery, events are dropped while the queue is full
+func (d *Dispatcher) Notify(event core.Event) {
+	select {
+	case d.queue <- event:
+	default:
+		log.Printf("Webhook queue is full, dropping event %s %s of app %s", event.Type, event.Id, event.AppId)
+	}
+}
+
+// Run delivers the queued events until ctx is done
+func (d *Dispatcher) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-d.queue:
+			d.dispatch(ctx, event)
+		}
+	}
+}
+
+func (d *Dispatcher) dispatch(ctx context.Context, event core.Event) {
+	subs, err := d.repo.GetSubscriptions()
+	if err != nil {
+		log.Printf("Error loading webhook subscriptions, dropping event %s %s: %v", event.Type, event.Id, err)
+		return
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error marshalling event %s %s: %v", event.Type, event.Id, err)
+		return
+	}
+	for _, sub := range subs {
+		if sub.Matches(event) {
+			// retries of one subscriber must not hold back the others
+			go d.deliver(ctx, sub, event, payload)
+		}
+	}
+}
+
+// deliver posts the payload to the subscriber until it is accepted or maxAttempts attempts failed
+func (d *Dispatcher) deliver(ctx context.Context, sub *core.WebhookSubscription, event core.Event, payload []byte) {
+	backoff := d.backoff
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		delivery := &core.WebhookDelivery{
+			Id:             uuid.New(),
+			SubscriptionId: sub.Id,
+			EventId:        event.Id,
+			EventType:      event.Type,
+			Attempt:        attempt,
+			Time:           time.Now(),
+		}
+		statusCode, err := d.post(ctx, sub, event, payload)
+		delivery.StatusCode = statusCode
+		delivery.Delivered = err == nil
+		if err != nil {
+			delivery.Error = err.Error()
+		}
+		if saveErr := d.repo.SaveDelivery(delivery); saveErr != nil {
+			log.Printf("Error saving webhook delivery %s: %v", delivery.Id, saveErr)
+		}
+		if err == nil {
+			return
+		}
+		if attempt == maxAttempts {
+			log.Printf("Giving up delivering event %s %s to %s after %d attempts: %v", event.Type, event.Id, sub.URL, attempt, err)
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+func (d *Dispatcher) post(ctx context.Context, sub *core.WebhookSubscription, event core.Event, payload []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, string(event.Type))
+	req.Header.Set(DeliveryHeader, event.Id)
+	req.Header.Set(SignatureHeader, "sha256="+Sign(sub.Secret, payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("subscriber responded with status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of the payload, subscribers compare it with the signature header
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
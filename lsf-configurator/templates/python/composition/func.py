@@ -170,6 +170,8 @@ QUEUE_START_TIME_HEADER = "X-Request-Start-Time"
 CORRELATION_ID_HEADER = "X-Correlation-ID"
 TRACE_BOUNDARY_START_LABEL = "trace_boundary_start"
 TRACE_BOUNDARY_END_LABEL = "trace_boundary_end"
+# names the end component, so the latency of each path through the call graph can be measured
+TRACE_BOUNDARY_END_COMPONENT_LABEL = "trace_boundary_end_component"
 
 
 def main(context: Context) -> Tuple[str, int]:
@@ -272,7 +274,10 @@ def main(context: Context) -> Tuple[str, int]:
                     with tracer.start_as_current_span(
                         "write_result",
                         context=span_context,
-                        attributes={TRACE_BOUNDARY_END_LABEL: True},
+                        attributes={
+                            TRACE_BOUNDARY_END_LABEL: True,
+                            TRACE_BOUNDARY_END_COMPONENT_LABEL: component,
+                        },
                     ) as span:
                         try:
                             write_result(o, correlation_id)